@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/websocket"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	port         = flag.Int("port", 42070, "port to listen on")
+	writeTimeout = flag.Duration("write-timeout", 10*time.Second, "how long a slow client may hold up a message before it is dropped")
+)
+
+const page = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>Chat</title>
+  </head>
+  <body>
+    <h1>Chat</h1>
+    <pre id="log"></pre>
+    <form id="form">
+      <input id="name" placeholder="name" size="10">
+      <input id="text" placeholder="message" size="50" autofocus>
+      <button>Send</button>
+    </form>
+    <script>
+      const log = document.getElementById("log");
+      const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/");
+      ws.onmessage = (e) => { log.textContent += e.data + "\n"; };
+      ws.onclose = (e) => { log.textContent += "* disconnected (" + e.code + ")\n"; };
+      document.getElementById("form").onsubmit = (e) => {
+        e.preventDefault();
+        const name = document.getElementById("name").value || "anonymous";
+        const text = document.getElementById("text");
+        ws.send(name + ": " + text.value);
+        text.value = "";
+      };
+    </script>
+  </body>
+</html>
+`
+
+// room relays every message to every member.
+type room struct {
+	mu      sync.Mutex
+	members map[*websocket.Conn]bool
+}
+
+func (r *room) join(c *websocket.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.members[c] = true
+}
+
+func (r *room) leave(c *websocket.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.members, c)
+}
+
+func (r *room) size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.members)
+}
+
+func (r *room) broadcast(msg string) {
+	r.mu.Lock()
+	members := make([]*websocket.Conn, 0, len(r.members))
+	for c := range r.members {
+		members = append(members, c)
+	}
+	r.mu.Unlock()
+
+	for _, c := range members {
+		c.NetConn().SetWriteDeadline(time.Now().Add(*writeTimeout))
+		if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			// the member's reader sees the closed connection and leaves
+			c.NetConn().Close()
+		}
+	}
+}
+
+var (
+	chat     = &room{members: map[*websocket.Conn]bool{}}
+	upgrader = &websocket.Upgrader{EnableCompression: true, ReadLimit: 4096}
+)
+
+func main() {
+	flag.Parse()
+
+	server, err := server.Serve(*port, handler)
+	if err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+	defer server.Close()
+	log.Println("Chat server started on port", *port)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Println("Chat server gracefully stopped")
+}
+
+func handler(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Path() != "/" {
+		body := []byte("404 Not Found\n")
+		w.WriteStatusLine(response.StatusCodeNotFound)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+		return
+	}
+	if websocket.IsUpgrade(req) {
+		handlerChat(w, req)
+		return
+	}
+
+	body := []byte(page)
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("Content-Type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func handlerChat(w *response.Writer, req *request.Request) {
+	c, err := upgrader.Upgrade(w, req)
+	if err != nil {
+		log.Printf("chat: %v", err)
+		return
+	}
+	chat.join(c)
+	addr := c.NetConn().RemoteAddr()
+	log.Printf("chat: %s joined", addr)
+	chat.broadcast("* someone joined")
+
+	for {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			log.Printf("chat: %s left: %v", addr, err)
+			break
+		}
+		chat.broadcast(string(msg))
+	}
+	chat.leave(c)
+	chat.broadcast(fmt.Sprintf("* someone left, %d here", chat.size()))
+}
@@ -1,26 +1,196 @@
 package main
 
 import (
-	"crypto/sha256"
-	"errors"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"httpfromtcp/internal/accesslog"
+	"httpfromtcp/internal/cache"
+	"httpfromtcp/internal/fileserver"
+	"httpfromtcp/internal/form"
 	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/middleware"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/proxy"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/session"
+	"httpfromtcp/internal/sse"
+	"httpfromtcp/internal/trace"
 	"io"
 	"log"
-	"net/http"
+	"log/slog"
+	"maps"
+	"net/netip"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const port = 42069
 
+var (
+	upstreamURLs    = flag.String("upstream", "https://httpbin.org", "comma-separated origins that /httpbin/ requests are proxied to")
+	healthCheckPath = flag.String("health-path", "", "path polled to check upstream health when balancing over several origins")
+	cacheSize       = flag.Int64("cache-size", 0, "bytes of proxied responses to cache in memory, 0 disables the cache")
+	cacheDir        = flag.String("cache-dir", "", "directory to cache proxied responses in instead of memory")
+	forward         = flag.Bool("forward-proxy", false, "also act as a forward proxy for absolute-form and CONNECT requests")
+	forwardAllow    = flag.String("forward-allow", "", "comma-separated hosts the forward proxy may reach, empty allows all")
+	assetsDir       = flag.String("assets", "assets", "directory served under /assets/")
+	decodeBodies    = flag.Bool("decode-bodies", false, "decompress gzip and deflate request bodies before handling them")
+	errorTemplates  = flag.String("error-templates", "", "directory of error.html, error.txt or <status>.html templates for error pages")
+	maxBodySize     = flag.Int64("max-body-size", form.DefaultLimits.MaxTotalSize, "largest request body, in bytes, read into memory; larger ones are refused with 413 before being read, 0 means no limit")
+	maxUploadSize   = flag.Int64("max-upload-size", form.DefaultLimits.MaxTotalSize, "largest form body, in bytes, accepted by /upload, which is streamed rather than held to -max-body-size")
+	sessionKeys     = flag.String("session-keys", "", "comma-separated hex keys signing session cookies, newest first; random when empty")
+	sessionDir      = flag.String("session-dir", "", "directory to keep sessions in instead of memory")
+	proxyProtocol   = flag.String("proxy-protocol", "", "comma-separated addresses or CIDRs of load balancers that send a PROXY protocol header")
+	trustedProxies  = flag.String("trusted-proxies", "", "comma-separated addresses or CIDRs of proxies whose Forwarded and X-Forwarded-* headers are believed")
+	requestTimeout  = flag.Duration("request-timeout", 0, "cancel requests, including proxied ones and event streams, that take longer; 0 means no limit")
+	maxDecodedSize  = flag.Int64("max-decoded-size", middleware.DefaultMaxDecodedSize, "largest request body, in bytes, that -decode-bodies will produce")
+	accessLog       = flag.String("access-log", "", "file to log requests to, reopened on SIGHUP; - for stdout, empty disables the log")
+	accessLogFormat = flag.String("access-log-format", "combined", "access log format: common, combined or json")
+	metricsPath     = flag.String("metrics-path", "/metrics", "path Prometheus metrics are served at, empty disables them")
+	traceFile       = flag.String("trace-file", "", "file to write finished trace spans to as JSON lines; - for stdout")
+	traceEndpoint   = flag.String("trace-otlp", "", "OTLP/HTTP traces URL of a collector to send spans to, such as http://localhost:4318/v1/traces")
+)
+
+var (
+	reverseProxy *proxy.ReverseProxy
+	forwardProxy *proxy.ForwardProxy
+	assets       *fileserver.FileServer
+	renderer     = problem.Default
+	registry     *metrics.Registry
+	visits       server.Handler
+	// clock is the feed streamed from /events
+	clock = sse.NewFeed(60)
+	// pages are the dynamic HTML pages, which clients can revalidate
+	pages = server.Chain(handler200, middleware.ETag(false))
+)
+
 func main() {
-	server, err := server.Serve(port, handler)
+	flag.Parse()
+
+	if *errorTemplates != "" {
+		templates, err := problem.LoadPages(*errorTemplates)
+		if err != nil {
+			log.Fatalf("Error loading error templates: %v", err)
+		}
+		renderer = templates
+	}
+
+	upstreams := strings.Split(*upstreamURLs, ",")
+	if len(upstreams) == 1 {
+		p, err := proxy.NewReverseProxy(upstreams[0])
+		if err != nil {
+			log.Fatalf("Error configuring proxy: %v", err)
+		}
+		reverseProxy = p
+	} else {
+		pool, err := proxy.NewPool(proxy.RoundRobin, upstreams...)
+		if err != nil {
+			log.Fatalf("Error configuring upstream pool: %v", err)
+		}
+		pool.HealthCheckPath = *healthCheckPath
+		pool.Start()
+		defer pool.Close()
+		reverseProxy = proxy.NewPoolProxy(pool)
+	}
+	reverseProxy.StripPrefix = "/httpbin"
+
+	if *cacheDir != "" {
+		store, err := cache.NewDiskStore(*cacheDir)
+		if err != nil {
+			log.Fatalf("Error opening cache directory: %v", err)
+		}
+		reverseProxy.Cache = cache.New(store)
+	} else if *cacheSize > 0 {
+		reverseProxy.Cache = cache.New(cache.NewMemoryStore(*cacheSize))
+	}
+
+	if *forward {
+		forwardProxy = proxy.NewForwardProxy()
+		if *forwardAllow != "" {
+			forwardProxy.AllowedHosts = strings.Split(*forwardAllow, ",")
+		}
+	}
+
+	assets = fileserver.Dir(*assetsDir)
+	assets.StripPrefix = "/assets"
+	assets.Listing = true
+
+	sessions, err := newSessionManager()
+	if err != nil {
+		log.Fatalf("Error configuring sessions: %v", err)
+	}
+	visits = server.Chain(handlerVisits, sessions.Middleware())
+	go tick()
+
+	var chain []server.Middleware
+	if *trustedProxies != "" {
+		proxies, err := parsePrefixes(*trustedProxies)
+		if err != nil {
+			log.Fatalf("Error parsing -trusted-proxies: %v", err)
+		}
+		chain = append(chain, middleware.Forwarded(proxies))
+	}
+	chain = append(chain, middleware.RequestID())
+	if *traceFile != "" || *traceEndpoint != "" {
+		tracer, closeTracer, err := newTracer()
+		if err != nil {
+			log.Fatalf("Error configuring tracing: %v", err)
+		}
+		defer closeTracer()
+		chain = append(chain, tracer.Middleware())
+	}
+	if *accessLog != "" {
+		logger, closeLog, err := newAccessLogger()
+		if err != nil {
+			log.Fatalf("Error opening access log: %v", err)
+		}
+		defer closeLog()
+		chain = append(chain, accesslog.Middleware(logger))
+	}
+	var httpMetrics *metrics.HTTP
+	if *metricsPath != "" {
+		registry = metrics.NewRegistry()
+		httpMetrics = metrics.NewHTTP(registry)
+		reverseProxy.ObserveUpstream = httpMetrics.ObserveUpstream
+		chain = append(chain, httpMetrics.Middleware(route))
+	}
+	chain = append(chain, middleware.Compress(middleware.DefaultCompressMinSize))
+	if *decodeBodies {
+		chain = append(chain, middleware.DecodeBody(*maxDecodedSize))
+	}
+
+	opts := []server.Option{
+		server.WithErrorRenderer(renderer),
+		server.WithRequestTimeout(*requestTimeout),
+		server.WithMaxBodySize(*maxBodySize),
+		// uploads are parsed as they arrive, spilling large files to disk
+		server.WithStreamedBodies(func(req *request.Request) bool {
+			return req.RequestLine.Path() == "/upload"
+		}),
+	}
+	if *proxyProtocol != "" {
+		balancers, err := parsePrefixes(*proxyProtocol)
+		if err != nil {
+			log.Fatalf("Error parsing -proxy-protocol: %v", err)
+		}
+		opts = append(opts, server.WithProxyProtocol(balancers))
+	}
+	if httpMetrics != nil {
+		opts = append(opts, server.WithObserver(httpMetrics))
+	}
+
+	server, err := server.Serve(port, server.Chain(handler, chain...), opts...)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -33,143 +203,316 @@ func main() {
 	log.Println("Server gracefully stopped")
 }
 
+// newAccessLogger opens the -access-log file, which logrotate can have
+// reopened with SIGHUP.
+func newAccessLogger() (*slog.Logger, func(), error) {
+	format, err := accesslog.ParseFormat(*accessLogFormat)
+	if err != nil {
+		return nil, nil, err
+	}
+	var out io.Writer = os.Stdout
+	closeLog := func() {}
+	if *accessLog != "-" {
+		f, err := accesslog.OpenFile(*accessLog)
+		if err != nil {
+			return nil, nil, err
+		}
+		stop := f.ReopenOn(syscall.SIGHUP)
+		out = f
+		closeLog = func() {
+			stop()
+			f.Close()
+		}
+	}
+	return slog.New(accesslog.NewHandler(out, format)), closeLog, nil
+}
+
+// route names the route of req in metrics, following handler.
+func route(req *request.Request) string {
+	if form := req.RequestLine.Form(); forwardProxy != nil && (form == request.AbsoluteForm || form == request.AuthorityForm) {
+		return "forward"
+	}
+	path := req.RequestLine.Path()
+	switch {
+	case path == *metricsPath, path == "/yourproblem", path == "/myproblem", path == "/video",
+		path == "/visits", path == "/events", path == "/upload":
+		return path
+	case path == "/assets" || strings.HasPrefix(path, "/assets/"):
+		return "/assets/"
+	case strings.HasPrefix(path, "/httpbin/"):
+		return "/httpbin/"
+	}
+	return "pages"
+}
+
+// newTracer exports spans to the -trace-file or the -trace-otlp collector.
+func newTracer() (*trace.Tracer, func(), error) {
+	switch {
+	case *traceFile != "" && *traceEndpoint != "":
+		return nil, nil, fmt.Errorf("-trace-file and -trace-otlp cannot both be set")
+	case *traceEndpoint != "":
+		exporter := trace.NewOTLPExporter(*traceEndpoint, "httpserver")
+		return trace.NewTracer(exporter), func() { exporter.Close() }, nil
+	case *traceFile == "-":
+		return trace.NewTracer(trace.NewFileExporter(os.Stdout)), func() {}, nil
+	}
+	f, err := os.OpenFile(*traceFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return trace.NewTracer(trace.NewFileExporter(f)), func() { f.Close() }, nil
+}
+
 func handler(w *response.Writer, req *request.Request) {
-	if req.RequestLine.RequestTarget == "/yourproblem" {
+	// only proxy requests name a destination; OPTIONS * is for this server
+	form := req.RequestLine.Form()
+	if forwardProxy != nil && (form == request.AbsoluteForm || form == request.AuthorityForm) {
+		forwardProxy.Handle(w, req)
+		return
+	}
+
+	path := req.RequestLine.Path()
+	if registry != nil && path == *metricsPath {
+		registry.Handle(w, req)
+		return
+	}
+
+	if path == "/yourproblem" {
 		handler400(w, req)
 		return
 	}
 
-	if req.RequestLine.RequestTarget == "/myproblem" {
+	if path == "/myproblem" {
 		handler500(w, req)
 		return
 	}
 
-	if req.RequestLine.RequestTarget == "/video" {
-		handlerVideo(w, req)
+	if path == "/video" {
+		assets.ServeFile(w, req, "vim.mp4")
+		return
+	}
+
+	if path == "/assets" || strings.HasPrefix(path, "/assets/") {
+		assets.Handle(w, req)
 		return
 	}
 
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		handlerProxy(w, req)
+	if path == "/visits" {
+		visits(w, req)
 		return
 	}
 
-	handler200(w, req)
+	if path == "/events" {
+		handlerEvents(w, req)
+		return
+	}
+
+	if path == "/upload" {
+		handlerUpload(w, req)
+		return
+	}
+
+	if strings.HasPrefix(path, "/httpbin/") {
+		reverseProxy.Handle(w, req)
+		return
+	}
+
+	pages(w, req)
 }
 
-func handler400(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.StatusCodeBadRequest)
-	body := []byte(`<html>
-<head>
-<title>400 Bad Request</title>
-</head>
-<body>
-<h1>Bad Request</h1>
-<p>Your request honestly kinda sucked.</p>
-</body>
-</html>
-`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
-	return
+// parsePrefixes parses a comma-separated list of CIDRs, where a bare
+// address stands for itself alone.
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
 }
 
-func handler500(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.StatusCodeInternalServerError)
-	body := []byte(`<html>
-<head>
-<title>500 Internal Server Error</title>
-</head>
-<body>
-<h1>Internal Server Error</h1>
-<p>Okay, you know what? This one is on me.</p>
-</body>
-</html>
-`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+func newSessionManager() (*session.Manager, error) {
+	var store session.Store = session.NewMemoryStore()
+	if *sessionDir != "" {
+		fileStore, err := session.NewFileStore(*sessionDir)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+
+	var codecs []*session.Codec
+	for _, k := range strings.Split(*sessionKeys, ",") {
+		if k == "" {
+			continue
+		}
+		key, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, err
+		}
+		codec, err := session.NewCodec(key, nil)
+		if err != nil {
+			return nil, err
+		}
+		codecs = append(codecs, codec)
+	}
+	if len(codecs) == 0 {
+		key := make([]byte, 32)
+		rand.Read(key)
+		codec, err := session.NewCodec(key, nil)
+		if err != nil {
+			return nil, err
+		}
+		codecs = append(codecs, codec)
+	}
+	return session.NewManager(store, codecs...), nil
 }
 
-func handler200(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.StatusCodeSuccess)
-	body := []byte(`<html>
-<head>
-<title>200 OK</title>
-</head>
-<body>
-<h1>Success!</h1>
-<p>Your request was an absolute banger.</p>
-</body>
-</html>
-`)
+// tick publishes the time to clock every second.
+func tick() {
+	for now := range time.Tick(time.Second) {
+		clock.Publish(sse.Event{Event: "tick", Data: now.UTC().Format(time.RFC3339)})
+	}
+}
+
+// handlerEvents streams clock, resuming where a reconnecting client left
+// off.
+func handlerEvents(w *response.Writer, req *request.Request) {
+	stream, err := sse.Open(w, req, sse.DefaultHeartbeat)
+	if err != nil {
+		log.Printf("Error opening event stream: %v", err)
+		return
+	}
+	if err := stream.Follow(clock); err != nil {
+		log.Printf("Event stream for %s ended: %v", req.RemoteAddr, err)
+	}
+	stream.Close()
+}
+
+// handlerVisits counts the client's visits in its session.
+func handlerVisits(w *response.Writer, req *request.Request) {
+	s := session.Get(req)
+	n, _ := strconv.Atoi(s.Get("visits"))
+	n++
+	s.Set("visits", strconv.Itoa(n))
+
+	body := []byte(fmt.Sprintf("You have visited %d time(s).\n", n))
 	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
+	h.Override("Cache-Control", "no-store")
+	w.WriteStatusLine(response.StatusCodeSuccess)
 	w.WriteHeaders(h)
 	w.WriteBody(body)
-	return
 }
 
-func handlerProxy(w *response.Writer, req *request.Request) {
-	resource := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-	url := fmt.Sprintf("https://httpbin.org/%s", resource)
+type uploadedFile struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
 
-	res, err := http.Get(url)
-	if err != nil {
-		log.Fatalf("failed request to %s, %s", url, err)
+// handlerUpload echoes the fields and files of a submitted form as JSON.
+func handlerUpload(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "POST" {
+		w.OnWriteHeaders(func(_ response.StatusCode, h headers.Headers) {
+			h.Override("Allow", "POST")
+		})
+		renderer.Render(w, req, problem.New(response.StatusCodeMethodNotAllowed, "Uploads must be POSTed."))
+		return
 	}
-	defer res.Body.Close()
 
-	w.WriteStatusLine(response.StatusCode(res.StatusCode))
-	h := response.GetDefaultHeaders(0)
-	h.Override("Transfer-Encoding", "chunked")
-	h.Set("Trailer", "X-Content-SHA256")
-	h.Set("Trailer", "X-Content-Length")
-	h.Remove("Content-Length")
-	w.WriteHeaders(h)
+	f, err := form.Parse(req, form.Limits{MaxTotalSize: *maxUploadSize})
+	if err != nil {
+		renderer.Render(w, req, problem.New(form.StatusCode(err), err.Error()))
+		return
+	}
+	defer f.RemoveAll()
 
-	buf := make([]byte, 1024)
-	responseTracker := []byte{}
-	for {
-		n, err := res.Body.Read(buf)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				_, err = w.WriteChunkedBodyDone()
-				if err != nil {
-					fmt.Println("error writing chunked body done:", err)
-				}
-				break
-			}
-			log.Fatalf("error reading into buffer: %s", err)
+	result := struct {
+		Fields map[string][]string `json:"fields"`
+		Files  []uploadedFile      `json:"files"`
+	}{Fields: f.Values, Files: []uploadedFile{}}
+	for _, field := range slices.Sorted(maps.Keys(f.Files)) {
+		for _, file := range f.Files[field] {
+			contentType, _ := file.Headers.Get("Content-Type")
+			result.Files = append(result.Files, uploadedFile{
+				Field:       file.Name,
+				Filename:    file.Filename,
+				ContentType: contentType,
+				Size:        file.Size,
+			})
 		}
+	}
 
-		_, err = w.WriteChunkedBody(buf[:n])
-		if err != nil {
-			fmt.Println("error writing chunked body:", err)
-		}
-		responseTracker = append(responseTracker, buf[:n]...)
+	body, err := json.Marshal(result)
+	if err != nil {
+		renderer.Render(w, req, problem.New(response.StatusCodeInternalServerError, err.Error()))
+		return
 	}
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("Content-Type", "application/json")
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
 
-	sum := sha256.Sum256(responseTracker)
-	trailers := headers.NewHeaders()
-	trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", sum))
-	trailers.Set("X-Content-Length", fmt.Sprintf("%d", len(responseTracker)))
-	w.WriteTrailers(trailers)
+func handler400(w *response.Writer, req *request.Request) {
+	renderer.Render(w, req, &problem.Problem{
+		Status: response.StatusCodeBadRequest,
+		Detail: "Your request honestly kinda sucked.",
+	})
 }
 
-func handlerVideo(w *response.Writer, _ *request.Request) {
-	vid, err := os.ReadFile("assets/vim.mp4")
-	if err != nil {
-		log.Fatalf("failed to read file: %s", err)
-	}
+func handler500(w *response.Writer, req *request.Request) {
+	renderer.Render(w, req, &problem.Problem{
+		Status: response.StatusCodeInternalServerError,
+		Detail: "Okay, you know what? This one is on me.",
+	})
+}
 
+const (
+	successHTML = `<html>
+<head>
+<title>200 OK</title>
+</head>
+<body>
+<h1>Success!</h1>
+<p>Your request was an absolute banger.</p>
+</body>
+</html>
+`
+	successText = "Success!\nYour request was an absolute banger.\n"
+	successJSON = `{"message":"Success!","detail":"Your request was an absolute banger."}` + "\n"
+)
+
+// handler200 sends the success page in whichever format the client
+// accepts, HTML by default.
+func handler200(w *response.Writer, req *request.Request) {
+	body, contentType := successHTML, "text/html; charset=utf-8"
+	switch headers.NegotiateMediaType(req.Headers, "text/html", "application/json", "text/plain") {
+	case "application/json":
+		body, contentType = successJSON, "application/json"
+	case "text/plain":
+		body, contentType = successText, "text/plain; charset=utf-8"
+	}
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("Content-Type", contentType)
+	h.Set("Vary", "Accept")
 	w.WriteStatusLine(response.StatusCodeSuccess)
-	h := response.GetDefaultHeaders(len(vid))
-	h.Override("Content-Type", "video/mp4")
 	w.WriteHeaders(h)
-	w.WriteBody(vid)
-
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody([]byte(body))
+	}
 }
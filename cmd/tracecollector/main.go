@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// tracecollector stands in for an OpenTelemetry collector during local
+// development: it accepts OTLP/HTTP traces in JSON encoding and prints
+// each span as a line of JSON.
+
+var port = flag.Int("port", 4318, "port to listen on, 4318 being the OTLP/HTTP default")
+
+// traces is the part of an ExportTraceServiceRequest needed to pick out
+// the spans.
+type traces struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []json.RawMessage `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+var stdout sync.Mutex
+
+func main() {
+	flag.Parse()
+
+	server, err := server.Serve(*port, handler)
+	if err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+	defer server.Close()
+	log.Println("Trace collector started on port", *port)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Println("Trace collector gracefully stopped")
+}
+
+func handler(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Path() != "/v1/traces" {
+		reply(w, response.StatusCodeNotFound, "404 Not Found\n")
+		return
+	}
+	if req.RequestLine.Method != "POST" {
+		reply(w, response.StatusCodeMethodNotAllowed, "405 Method Not Allowed\n")
+		return
+	}
+	if contentType, _ := req.Headers.Get("Content-Type"); contentType != "application/json" {
+		reply(w, response.StatusCodeUnsupportedMediaType, "only the JSON encoding is supported\n")
+		return
+	}
+
+	var t traces
+	if err := json.Unmarshal(req.Body, &t); err != nil {
+		reply(w, response.StatusCodeBadRequest, "invalid OTLP request\n")
+		return
+	}
+	stdout.Lock()
+	for _, rs := range t.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				os.Stdout.Write(append(span, '\n'))
+			}
+		}
+	}
+	stdout.Unlock()
+
+	h := response.GetDefaultHeaders(2)
+	h.Override("Content-Type", "application/json")
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte("{}"))
+}
+
+func reply(w *response.Writer, status response.StatusCode, body string) {
+	w.WriteStatusLine(status)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
@@ -0,0 +1,73 @@
+package accesslog
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/trace"
+	"log"
+	"log/slog"
+	"time"
+)
+
+// Keys of the attributes each access record carries.
+const (
+	KeyRemoteAddr = "remote_addr"
+	KeyMethod     = "method"
+	KeyTarget     = "target"
+	KeyProto      = "proto"
+	KeyStatus     = "status"
+	KeyBytes      = "bytes"
+	KeyDuration   = "duration_ms"
+	KeyUserAgent  = "user_agent"
+	KeyReferer    = "referer"
+	KeyRequestID  = "request_id"
+	KeyTraceID    = "trace_id"
+)
+
+// Middleware logs one record per request to logger, at info level and
+// timed at the start of the request, once the handler has returned. It
+// should come before every middleware but Forwarded, RequestID and
+// tracing, so that it logs the resolved client, the request and trace IDs,
+// and the status and bytes sent after compression.
+func Middleware(logger *slog.Logger) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+
+			ctx := req.Context()
+			handler := logger.Handler()
+			if !handler.Enabled(ctx, slog.LevelInfo) {
+				return
+			}
+			remoteAddr := req.ClientIP
+			if remoteAddr == "" {
+				remoteAddr = req.RemoteAddr
+			}
+			userAgent, _ := req.Headers.Get("User-Agent")
+			referer, _ := req.Headers.Get("Referer")
+			requestID, _ := req.Headers.Get("X-Request-ID")
+
+			record := slog.NewRecord(start, slog.LevelInfo, "request", 0)
+			record.AddAttrs(
+				slog.String(KeyRemoteAddr, remoteAddr),
+				slog.String(KeyMethod, req.RequestLine.Method),
+				slog.String(KeyTarget, req.RequestLine.RequestTarget),
+				slog.String(KeyProto, "HTTP/"+req.RequestLine.HttpVersion),
+				slog.Int(KeyStatus, int(w.StatusCode())),
+				slog.Int64(KeyBytes, w.BodyBytes()),
+				slog.Float64(KeyDuration, float64(time.Since(start).Microseconds())/1000),
+				slog.String(KeyUserAgent, userAgent),
+				slog.String(KeyReferer, referer),
+				slog.String(KeyRequestID, requestID),
+			)
+			if span := trace.SpanFromContext(ctx); span != nil {
+				record.AddAttrs(slog.String(KeyTraceID, span.Context.TraceID.String()))
+			}
+			if err := handler.Handle(ctx, record); err != nil {
+				log.Printf("accesslog: error writing record: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/trace"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		body := []byte("hello")
+		if req.RequestLine.Path() == "/empty" {
+			body = nil
+		}
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	// serve runs raw through the middleware and returns the line logged in
+	// format f
+	serve := func(f Format, raw string) string {
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		req.ClientIP = "203.0.113.7"
+		var out bytes.Buffer
+		Middleware(slog.New(NewHandler(&out, f)))(handler)(response.NewRecorder().Writer, req)
+		return out.String()
+	}
+	raw := "GET /hello?x=1 HTTP/1.1\r\nHost: localhost\r\nUser-Agent: curl/8.0\r\nReferer: http://example.com/\r\nX-Request-ID: abc123\r\n\r\n"
+
+	// Test: Common and Combined lines
+	clf := `^203\.0\.113\.7 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /hello\?x=1 HTTP/1\.1" 200 5`
+	assert.Regexp(t, regexp.MustCompile(clf+"\n$"), serve(Common, raw))
+	assert.Regexp(t, regexp.MustCompile(clf+` "http://example.com/" "curl/8.0"`+"\n$"), serve(Combined, raw))
+
+	// Test: Missing fields and empty bodies are written as -
+	line := serve(Combined, "GET /empty HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(line, `"GET /empty HTTP/1.1" 200 - "-" "-"`+"\n"), line)
+
+	// Test: Quotes and control characters cannot break out of a field
+	line = serve(Combined, "GET / HTTP/1.1\r\nHost: localhost\r\nUser-Agent: a\" b\\\x01\xff\r\n\r\n")
+	assert.True(t, strings.HasSuffix(line, `"-" "a\" b\\\x01\xff"`+"\n"), line)
+
+	// Test: JSON lines carry every field
+	var record map[string]any
+	require.NoError(t, json.Unmarshal([]byte(serve(JSON, raw)), &record))
+	for key, want := range map[string]any{
+		KeyRemoteAddr: "203.0.113.7",
+		KeyMethod:     "GET",
+		KeyTarget:     "/hello?x=1",
+		KeyProto:      "HTTP/1.1",
+		KeyStatus:     float64(200),
+		KeyBytes:      float64(5),
+		KeyUserAgent:  "curl/8.0",
+		KeyReferer:    "http://example.com/",
+		KeyRequestID:  "abc123",
+	} {
+		assert.Equal(t, want, record[key], key)
+	}
+	assert.Contains(t, record, KeyDuration)
+	assert.Contains(t, record, slog.TimeKey)
+	assert.NotContains(t, record, slog.LevelKey)
+	assert.NotContains(t, record, slog.MessageKey)
+
+	// Test: Traced requests are logged with their trace ID
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	tracer := trace.NewTracer(trace.NewFileExporter(io.Discard))
+	server.Chain(handler, tracer.Middleware(), Middleware(slog.New(NewHandler(&out, JSON))))(response.NewRecorder().Writer, req)
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Regexp(t, regexp.MustCompile("^[0-9a-f]{32}$"), record[KeyTraceID])
+
+	// Test: Formats are parsed by name
+	for name, want := range map[string]Format{"common": Common, "Combined": Combined, "json": JSON} {
+		f, err := ParseFormat(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, f)
+	}
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenFile(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Test: Writes are appended
+	_, err = f.Write([]byte("one\n"))
+	require.NoError(t, err)
+
+	// Test: After a rotation, Reopen starts a new file at the same path
+	require.NoError(t, os.Rename(path, path+".1"))
+	_, err = f.Write([]byte("two\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Reopen())
+	_, err = f.Write([]byte("three\n"))
+	require.NoError(t, err)
+	rotated, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", string(rotated))
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "three\n", string(current))
+
+	// Test: SIGHUP reopens the file
+	stop := f.ReopenOn(syscall.SIGHUP)
+	defer stop()
+	require.NoError(t, os.Rename(path, path+".2"))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	_, err = f.Write([]byte("four\n"))
+	require.NoError(t, err)
+	current, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "four\n", string(current))
+}
@@ -0,0 +1,82 @@
+package accesslog
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// File is an append-only log file that can be reopened at the same path,
+// so that logrotate can move it away and have a fresh one created.
+type File struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func OpenFile(path string) (*File, error) {
+	f, err := openAppend(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: path, f: f}, nil
+}
+
+func openAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Write(p)
+}
+
+// Reopen opens the path again and switches writes to it. If that fails,
+// writes keep going to the file already open.
+func (f *File) Reopen() error {
+	nf, err := openAppend(f.path)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	old := f.f
+	f.f = nf
+	f.mu.Unlock()
+	return old.Close()
+}
+
+// ReopenOn reopens the file each time one of sigs arrives, typically
+// SIGHUP sent by logrotate's postrotate script, until stop is called.
+func (f *File) ReopenOn(sigs ...os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				if err := f.Reopen(); err != nil {
+					log.Printf("accesslog: error reopening %s: %v", f.path, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
@@ -0,0 +1,126 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Format is the layout of access log lines.
+type Format int
+
+const (
+	// Common is the Apache Common Log Format:
+	// host ident user [time] "request line" status bytes
+	Common Format = iota
+	// Combined is Common followed by the quoted Referer and User-Agent.
+	Combined
+	// JSON writes one JSON object per request with every field, duration
+	// and request ID included.
+	JSON
+)
+
+// ParseFormat parses "common", "combined" or "json".
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "common":
+		return Common, nil
+	case "combined":
+		return Combined, nil
+	case "json":
+		return JSON, nil
+	}
+	return 0, fmt.Errorf("accesslog: unknown format %q", s)
+}
+
+// NewHandler returns a slog.Handler that writes the records Middleware
+// logs to w in format f.
+func NewHandler(w io.Writer, f Format) slog.Handler {
+	if f == JSON {
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{
+			// every line is an access record, so level and message are noise
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && (a.Key == slog.LevelKey || a.Key == slog.MessageKey) {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+	}
+	return &textHandler{mu: &sync.Mutex{}, w: w, combined: f == Combined}
+}
+
+// clfTime is the time layout of the Common Log Format.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// textHandler writes records in the Common or Combined format. The formats
+// are fixed, so attributes they have no place for are dropped.
+type textHandler struct {
+	mu       *sync.Mutex
+	w        io.Writer
+	combined bool
+}
+
+func (h *textHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *textHandler) WithGroup(string) slog.Handler { return h }
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := map[string]string{}
+	r.Attrs(func(a slog.Attr) bool {
+		fields[a.Key] = a.Value.String()
+		return true
+	})
+	field := func(key string) string {
+		if v := fields[key]; v != "" {
+			return v
+		}
+		return "-"
+	}
+
+	bytes := field(KeyBytes)
+	if bytes == "0" {
+		bytes = "-"
+	}
+	requestLine := fields[KeyMethod] + " " + fields[KeyTarget] + " " + fields[KeyProto]
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - - [%s] \"%s\" %s %s",
+		field(KeyRemoteAddr), r.Time.Format(clfTime), escape(requestLine), field(KeyStatus), bytes)
+	if h.combined {
+		fmt.Fprintf(&b, " \"%s\" \"%s\"", escape(field(KeyReferer)), escape(field(KeyUserAgent)))
+	}
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// escape makes s safe inside a quoted field the way Apache does: quotes
+// and backslashes are escaped, and other control or non-ASCII bytes are
+// written as \xhh, so a client cannot forge log lines.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			b.WriteString(`\x`)
+			b.WriteString(strconv.FormatUint(uint64(c)>>4, 16))
+			b.WriteString(strconv.FormatUint(uint64(c)&0xf, 16))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
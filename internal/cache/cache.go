@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultMaxEntrySize = 8 << 20
+
+// Store keeps cache entries by key. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, e *Entry)
+	Delete(key string)
+}
+
+type Status int
+
+const (
+	Miss Status = iota
+	// Fresh entries can be served as they are.
+	Fresh
+	// StaleWhileRevalidate entries can be served while a background request
+	// refreshes them.
+	StaleWhileRevalidate
+	// Stale entries must be revalidated with the origin before being served.
+	Stale
+)
+
+// Cache is a shared HTTP cache following RFC 9111.
+type Cache struct {
+	Store Store
+	// MaxEntrySize is the largest response body that will be stored.
+	MaxEntrySize int64
+}
+
+func New(store Store) *Cache {
+	return &Cache{
+		Store:        store,
+		MaxEntrySize: defaultMaxEntrySize,
+	}
+}
+
+// Cacheable reports whether a response to req may come from, or be stored
+// in, the cache.
+func Cacheable(req *request.Request) bool {
+	if req.RequestLine.Method != "GET" {
+		return false
+	}
+	return !ParseCacheControl(req.Headers).Has("no-store")
+}
+
+// Storable reports whether a response with the given status and headers to
+// req may be stored by a shared cache (RFC 9111 section 3).
+func Storable(req *request.Request, status response.StatusCode, h headers.Headers) bool {
+	if !Cacheable(req) {
+		return false
+	}
+	// partial and not-modified responses only make sense next to a stored
+	// full response
+	if status == 206 || status == 304 {
+		return false
+	}
+	if _, ok := req.Headers.Get("Range"); ok {
+		return false
+	}
+
+	cc := ParseCacheControl(h)
+	if cc.Has("no-store") || cc.Has("private") {
+		return false
+	}
+	if v, ok := h.Get("Vary"); ok && strings.TrimSpace(v) == "*" {
+		return false
+	}
+	// responses that set cookies are specific to one client
+	if _, ok := h.Get("Set-Cookie"); ok {
+		return false
+	}
+
+	if _, ok := req.Headers.Get("Authorization"); ok {
+		if !cc.Has("public") && !cc.Has("s-maxage") && !cc.Has("must-revalidate") {
+			return false
+		}
+	}
+
+	if cc.Has("public") || cc.Has("s-maxage") || cc.Has("max-age") {
+		return true
+	}
+	if _, ok := h.Get("Expires"); ok {
+		return true
+	}
+	return heuristicallyCacheable(status)
+}
+
+// Lookup finds the stored response for req and reports whether it can be
+// used as is.
+func (c *Cache) Lookup(req *request.Request, now time.Time) (*Entry, Status) {
+	if !Cacheable(req) {
+		return nil, Miss
+	}
+
+	key := Key(req)
+	e, ok := c.Store.Get(key)
+	if !ok {
+		return nil, Miss
+	}
+	if e.Vary != nil {
+		e, ok = c.Store.Get(variantKey(key, e.Vary, req))
+		if !ok {
+			return nil, Miss
+		}
+	}
+
+	return e, evaluate(req, e, now)
+}
+
+func evaluate(req *request.Request, e *Entry, now time.Time) Status {
+	reqCC := ParseCacheControl(req.Headers)
+	resCC := ParseCacheControl(e.Headers)
+	if reqCC.Has("no-cache") || resCC.Has("no-cache") {
+		return Stale
+	}
+
+	age := e.Age(now)
+	lifetime := e.FreshnessLifetime()
+	if maxAge, ok := reqCC.Duration("max-age"); ok && age > maxAge {
+		return Stale
+	}
+
+	remaining := lifetime - age
+	if minFresh, ok := reqCC.Duration("min-fresh"); ok {
+		remaining -= minFresh
+	}
+	if remaining > 0 {
+		return Fresh
+	}
+
+	if e.MustRevalidate() {
+		return Stale
+	}
+	if maxStale, ok := reqCC["max-stale"]; ok {
+		// a bare max-stale accepts a response of any staleness
+		if d, _ := reqCC.Duration("max-stale"); maxStale == "" || -remaining <= d {
+			return Fresh
+		}
+	}
+	if window, ok := resCC.Duration("stale-while-revalidate"); ok && -remaining <= window {
+		return StaleWhileRevalidate
+	}
+	return Stale
+}
+
+// Put stores e as the response to req.
+func (c *Cache) Put(req *request.Request, e *Entry) {
+	if c.MaxEntrySize > 0 && int64(len(e.Body)) > c.MaxEntrySize {
+		return
+	}
+
+	key := Key(req)
+	vary := varyHeaders(e.Headers)
+	if len(vary) == 0 {
+		c.Store.Set(key, e)
+		return
+	}
+	c.Store.Set(key, &Entry{Vary: vary})
+	c.Store.Set(variantKey(key, vary, req), e)
+}
+
+// Invalidate drops the stored response for the target of req. Caches must
+// do this after a successful unsafe request (RFC 9111 section 4.4).
+func (c *Cache) Invalidate(req *request.Request) {
+	key := Key(req)
+	if e, ok := c.Store.Get(key); ok && e.Vary != nil {
+		c.Store.Delete(variantKey(key, e.Vary, req))
+	}
+	c.Store.Delete(key)
+}
+
+// Key identifies the resource req asks for: its host, which is case
+// insensitive, and its target. Variants of it share the key.
+func Key(req *request.Request) string {
+	host, _ := req.Headers.Get("Host")
+	return strings.ToLower(host) + " " + req.RequestLine.RequestTarget
+}
+
+// variantKey extends a primary key with the request header values that a
+// varying response was selected by.
+func variantKey(key string, vary []string, req *request.Request) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range vary {
+		v, _ := req.Headers.Get(name)
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strings.Join(strings.Fields(v), " "))
+	}
+	return b.String()
+}
+
+func varyHeaders(h headers.Headers) []string {
+	v, ok := h.Get("Vary")
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
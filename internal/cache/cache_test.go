@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getRequest(t *testing.T, hdrs ...string) *request.Request {
+	b := request.NewBuilder("GET", "/resource").Header("Host", "example.com")
+	for i := 0; i+1 < len(hdrs); i += 2 {
+		b.Header(hdrs[i], hdrs[i+1])
+	}
+	req, err := b.Build()
+	require.NoError(t, err)
+	return req
+}
+
+func entry(now time.Time, hdrs ...string) *Entry {
+	h := headers.NewHeaders()
+	h.Set("Date", headers.FormatTime(now))
+	for i := 0; i+1 < len(hdrs); i += 2 {
+		h.Set(hdrs[i], hdrs[i+1])
+	}
+	return &Entry{
+		StatusCode:   200,
+		Headers:      h,
+		Body:         []byte("body"),
+		RequestTime:  now,
+		ResponseTime: now,
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Test: max-age, with s-maxage taking precedence
+	e := entry(now, "Cache-Control", "max-age=60")
+	assert.Equal(t, 60*time.Second, e.FreshnessLifetime())
+	e = entry(now, "Cache-Control", "max-age=60, s-maxage=10")
+	assert.Equal(t, 10*time.Second, e.FreshnessLifetime())
+
+	// Test: Expires relative to Date
+	e = entry(now, "Expires", headers.FormatTime(now.Add(time.Hour)))
+	assert.Equal(t, time.Hour, e.FreshnessLifetime())
+	e = entry(now, "Expires", "0")
+	assert.Equal(t, time.Duration(0), e.FreshnessLifetime())
+
+	// Test: Heuristic freshness from Last-Modified
+	e = entry(now, "Last-Modified", headers.FormatTime(now.Add(-10*time.Hour)))
+	assert.Equal(t, time.Hour, e.FreshnessLifetime())
+
+	// Test: Age accounts for the upstream Age header and resident time
+	e = entry(now, "Age", "30")
+	assert.Equal(t, 40*time.Second, e.Age(now.Add(10*time.Second)))
+
+	// Test: Quoted Cache-Control arguments are not split
+	cc := ParseCacheControl(headers.Headers{"cache-control": `private="set-cookie, x-user", max-age=5`})
+	assert.Equal(t, "set-cookie, x-user", cc["private"])
+	assert.Equal(t, "5", cc["max-age"])
+}
+
+func TestLookup(t *testing.T) {
+	now := time.Now()
+	c := New(NewMemoryStore(1 << 20))
+
+	// Test: Fresh, then stale, then stale-while-revalidate
+	req := getRequest(t)
+	c.Put(req, entry(now, "Cache-Control", "max-age=60, stale-while-revalidate=30"))
+	_, status := c.Lookup(req, now.Add(10*time.Second))
+	assert.Equal(t, Fresh, status)
+	_, status = c.Lookup(req, now.Add(70*time.Second))
+	assert.Equal(t, StaleWhileRevalidate, status)
+	_, status = c.Lookup(req, now.Add(100*time.Second))
+	assert.Equal(t, Stale, status)
+
+	// Test: Request directives
+	_, status = c.Lookup(getRequest(t, "Cache-Control", "no-cache"), now)
+	assert.Equal(t, Stale, status)
+	_, status = c.Lookup(getRequest(t, "Cache-Control", "max-age=5"), now.Add(10*time.Second))
+	assert.Equal(t, Stale, status)
+	_, status = c.Lookup(getRequest(t, "Cache-Control", "max-stale=60"), now.Add(100*time.Second))
+	assert.Equal(t, Fresh, status)
+
+	// Test: must-revalidate disables serving stale
+	c.Put(req, entry(now, "Cache-Control", "max-age=1, must-revalidate, stale-while-revalidate=30"))
+	_, status = c.Lookup(req, now.Add(5*time.Second))
+	assert.Equal(t, Stale, status)
+
+	// Test: Vary selects the variant by request header
+	gz := getRequest(t, "Accept-Encoding", "gzip")
+	plain := getRequest(t, "Accept-Encoding", "identity")
+	e := entry(now, "Cache-Control", "max-age=60", "Vary", "Accept-Encoding")
+	e.Body = []byte("gzipped")
+	c.Put(gz, e)
+	got, status := c.Lookup(gz, now)
+	assert.Equal(t, Fresh, status)
+	assert.Equal(t, "gzipped", string(got.Body))
+	_, status = c.Lookup(plain, now)
+	assert.Equal(t, Miss, status)
+
+	// Test: Invalidate
+	c.Invalidate(gz)
+	_, status = c.Lookup(gz, now)
+	assert.Equal(t, Miss, status)
+
+	// Test: Storability
+	assert.True(t, Storable(req, 200, headers.Headers{"cache-control": "max-age=60"}))
+	assert.False(t, Storable(req, 200, headers.Headers{"cache-control": "no-store"}))
+	assert.False(t, Storable(req, 200, headers.Headers{"cache-control": "private, max-age=60"}))
+	assert.False(t, Storable(req, 200, headers.Headers{"cache-control": "max-age=60", "vary": "*"}))
+	assert.False(t, Storable(req, 500, headers.Headers{}))
+	assert.False(t, Storable(getRequest(t, "Authorization", "Basic x"), 200, headers.Headers{"cache-control": "max-age=60"}))
+	assert.True(t, Storable(getRequest(t, "Authorization", "Basic x"), 200, headers.Headers{"cache-control": "public, max-age=60"}))
+	assert.False(t, Storable(getRequest(t, "Cache-Control", "no-store"), 200, headers.Headers{"cache-control": "max-age=60"}))
+
+	// Test: The key ignores the case of the host
+	upper, err := request.NewBuilder("GET", "/resource").Header("Host", "EXAMPLE.com").Build()
+	require.NoError(t, err)
+	assert.Equal(t, Key(req), Key(upper))
+}
+
+func TestStores(t *testing.T) {
+	now := time.Now()
+
+	// Test: Memory store evicts the least recently used entry
+	s := NewMemoryStore(300)
+	for _, key := range []string{"a", "b"} {
+		e := entry(now)
+		e.Body = make([]byte, 100)
+		s.Set(key, e)
+	}
+	s.Get("a")
+	e := entry(now)
+	e.Body = make([]byte, 100)
+	s.Set("c", e)
+	_, ok := s.Get("b")
+	assert.False(t, ok)
+	_, ok = s.Get("a")
+	assert.True(t, ok)
+	_, ok = s.Get("c")
+	assert.True(t, ok)
+	assert.LessOrEqual(t, s.Size(), int64(300))
+
+	// Test: Disk store round trip
+	d, err := NewDiskStore(t.TempDir())
+	require.NoError(t, err)
+	d.Set("key", entry(now, "Cache-Control", "max-age=60"))
+	got, ok := d.Get("key")
+	require.True(t, ok)
+	assert.Equal(t, "body", string(got.Body))
+	assert.Equal(t, "max-age=60", got.Headers["cache-control"])
+	assert.True(t, got.ResponseTime.Equal(now))
+	d.Delete("key")
+	_, ok = d.Get("key")
+	assert.False(t, ok)
+}
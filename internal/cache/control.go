@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"httpfromtcp/internal/headers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directives are the parsed directives of a Cache-Control header, keyed by
+// lowercase name. Directives without an argument map to "".
+type Directives map[string]string
+
+func ParseCacheControl(h headers.Headers) Directives {
+	d := Directives{}
+	v, ok := h.Get("Cache-Control")
+	if !ok {
+		return d
+	}
+
+	for _, part := range splitDirectives(v) {
+		name, value, _ := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		d[name] = value
+	}
+	return d
+}
+
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// Duration returns a delta-seconds directive such as max-age as a duration.
+func (d Directives) Duration(name string) (time.Duration, bool) {
+	v, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || seconds < 0 {
+		// an invalid max-age must be treated as stale (RFC 9111 section 4.2.1)
+		return 0, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// splitDirectives splits on commas that are not inside a quoted string, as
+// in `private="set-cookie, x-user", max-age=60`.
+func splitDirectives(v string) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				parts = append(parts, v[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, v[start:])
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// DiskStore keeps one file per entry in a directory, so cached responses
+// survive restarts. File names are hashes of the cache keys.
+type DiskStore struct {
+	dir string
+}
+
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+func (s *DiskStore) Get(key string) (*Entry, bool) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("cache: reading entry: %v", err)
+		}
+		return nil, false
+	}
+	defer f.Close()
+
+	var e Entry
+	if err := gob.NewDecoder(f).Decode(&e); err != nil {
+		log.Printf("cache: decoding %s: %v", f.Name(), err)
+		return nil, false
+	}
+	return &e, true
+}
+
+// Set writes the entry to a temporary file and renames it into place, so a
+// concurrent Get never sees a partial entry.
+func (s *DiskStore) Set(key string, e *Entry) {
+	tmp, err := os.CreateTemp(s.dir, ".entry-*")
+	if err != nil {
+		log.Printf("cache: writing entry: %v", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	err = gob.NewEncoder(tmp).Encode(e)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(key))
+	}
+	if err != nil {
+		log.Printf("cache: writing entry: %v", err)
+	}
+}
+
+func (s *DiskStore) Delete(key string) {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("cache: deleting entry: %v", err)
+	}
+}
+
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
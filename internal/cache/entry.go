@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/response"
+	"strconv"
+	"time"
+)
+
+const maxHeuristicLifetime = 24 * time.Hour
+
+// Entry is a stored response together with the times needed to compute its
+// age.
+type Entry struct {
+	StatusCode response.StatusCode
+	Headers    headers.Headers
+	Body       []byte
+	// RequestTime and ResponseTime are when the request that produced this
+	// response was sent and when its response was received.
+	RequestTime  time.Time
+	ResponseTime time.Time
+	// Vary is only set on the placeholder stored under a resource's primary
+	// key when its responses vary; it lists the request headers that select
+	// the variant.
+	Vary []string
+}
+
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body))
+	for k, v := range e.Headers {
+		n += int64(len(k) + len(v))
+	}
+	return n
+}
+
+// Age is the current age of the entry as defined in RFC 9111 section 4.2.3.
+func (e *Entry) Age(now time.Time) time.Duration {
+	apparentAge := time.Duration(0)
+	if date, ok := e.date(); ok {
+		apparentAge = max(0, e.ResponseTime.Sub(date))
+	}
+
+	ageValue := time.Duration(0)
+	if v, ok := e.Headers.Get("Age"); ok {
+		if seconds, err := strconv.ParseInt(v, 10, 64); err == nil && seconds > 0 {
+			ageValue = time.Duration(seconds) * time.Second
+		}
+	}
+
+	responseDelay := e.ResponseTime.Sub(e.RequestTime)
+	correctedAgeValue := ageValue + responseDelay
+	correctedInitialAge := max(apparentAge, correctedAgeValue)
+	residentTime := now.Sub(e.ResponseTime)
+	return correctedInitialAge + residentTime
+}
+
+// FreshnessLifetime is how long the response stays fresh in a shared cache,
+// from s-maxage, max-age, Expires or, failing those, a heuristic based on
+// Last-Modified (RFC 9111 section 4.2.1).
+func (e *Entry) FreshnessLifetime() time.Duration {
+	cc := ParseCacheControl(e.Headers)
+	if d, ok := cc.Duration("s-maxage"); ok {
+		return d
+	}
+	if d, ok := cc.Duration("max-age"); ok {
+		return d
+	}
+
+	if v, ok := e.Headers.Get("Expires"); ok {
+		expires, err := headers.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		date, ok := e.date()
+		if !ok {
+			date = e.ResponseTime
+		}
+		return max(0, expires.Sub(date))
+	}
+
+	if v, ok := e.Headers.Get("Last-Modified"); ok && heuristicallyCacheable(e.StatusCode) {
+		lastModified, err := headers.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		date, ok := e.date()
+		if !ok {
+			date = e.ResponseTime
+		}
+		return min(max(0, date.Sub(lastModified)/10), maxHeuristicLifetime)
+	}
+	return 0
+}
+
+// Fresh reports whether the entry can be served without revalidation.
+func (e *Entry) Fresh(now time.Time) bool {
+	return e.FreshnessLifetime() > e.Age(now)
+}
+
+// MustRevalidate reports whether the entry, once stale, may only be served
+// after the origin has validated it, even when the origin cannot be reached
+// (RFC 9111 sections 4.2.4 and 5.2.2).
+func (e *Entry) MustRevalidate() bool {
+	cc := ParseCacheControl(e.Headers)
+	return cc.Has("must-revalidate") || cc.Has("proxy-revalidate") || cc.Has("no-cache") || cc.Has("s-maxage")
+}
+
+// Validators returns the conditional request headers that revalidate this
+// entry with the origin.
+func (e *Entry) Validators() headers.Headers {
+	h := headers.NewHeaders()
+	if etag, ok := e.Headers.Get("ETag"); ok {
+		h.Set("If-None-Match", etag)
+	}
+	if lastModified, ok := e.Headers.Get("Last-Modified"); ok {
+		h.Set("If-Modified-Since", lastModified)
+	}
+	return h
+}
+
+// HasValidators reports whether the entry can be revalidated at all.
+func (e *Entry) HasValidators() bool {
+	return len(e.Validators()) > 0
+}
+
+// Revalidated folds the headers of a 304 Not Modified response into a copy
+// of the entry and restarts its age (RFC 9111 section 4.3.4).
+func (e *Entry) Revalidated(notModified headers.Headers, requestTime, responseTime time.Time) *Entry {
+	updated := *e
+	updated.Headers = e.Headers.Clone()
+	for k, v := range notModified {
+		switch k {
+		case "content-length", "transfer-encoding", "connection", "keep-alive":
+			continue
+		}
+		updated.Headers.Override(k, v)
+	}
+	updated.RequestTime = requestTime
+	updated.ResponseTime = responseTime
+	return &updated
+}
+
+func (e *Entry) date() (time.Time, bool) {
+	v, ok := e.Headers.Get("Date")
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := headers.ParseTime(v)
+	return t, err == nil
+}
+
+// heuristicallyCacheable lists the status codes that may be cached without
+// explicit freshness information (RFC 9110 section 15.1).
+func heuristicallyCacheable(status response.StatusCode) bool {
+	switch status {
+	case 200, 203, 204, 206, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+	return false
+}
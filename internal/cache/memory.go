@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// MemoryStore is an in-memory Store that evicts the least recently used
+// entries once the stored responses exceed MaxBytes.
+type MemoryStore struct {
+	maxBytes int64
+	size     int64
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (s *MemoryStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*memoryItem).entry, true
+}
+
+func (s *MemoryStore) Set(key string, e *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := &memoryItem{key: key, entry: e, size: int64(len(key)) + e.size()}
+	if s.maxBytes > 0 && item.size > s.maxBytes {
+		return
+	}
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	s.entries[key] = s.order.PushFront(item)
+	s.size += item.size
+
+	for s.maxBytes > 0 && s.size > s.maxBytes {
+		s.remove(s.order.Back())
+	}
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+}
+
+// Size returns the number of bytes currently held.
+func (s *MemoryStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *MemoryStore) remove(el *list.Element) {
+	item := s.order.Remove(el).(*memoryItem)
+	delete(s.entries, item.key)
+	s.size -= item.size
+}
@@ -0,0 +1,141 @@
+package chunked
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxLineLength = 4096
+
+var ErrLineTooLong = errors.New("chunked: line too long")
+
+// Reader decodes a body sent with Transfer-Encoding: chunked. Trailer fields
+// that follow the last chunk are collected into Trailers once Read returns
+// io.EOF.
+type Reader struct {
+	r         *bufio.Reader
+	remaining int64
+	needCRLF  bool
+	err       error
+	Trailers  headers.Headers
+}
+
+func NewReader(r *bufio.Reader) *Reader {
+	return &Reader{
+		r:        r,
+		Trailers: headers.NewHeaders(),
+	}
+}
+
+func (cr *Reader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+
+	if cr.remaining == 0 {
+		if cr.needCRLF {
+			if cr.err = expectCRLF(cr.r); cr.err != nil {
+				return 0, cr.err
+			}
+			cr.needCRLF = false
+		}
+
+		size, err := readChunkSize(cr.r)
+		if err != nil {
+			cr.err = err
+			return 0, err
+		}
+
+		if size == 0 {
+			cr.err = cr.readTrailers()
+			if cr.err == nil {
+				cr.err = io.EOF
+			}
+			return 0, cr.err
+		}
+		cr.remaining = size
+		cr.needCRLF = true
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.remaining -= int64(n)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		cr.err = err
+	}
+	return n, err
+}
+
+func (cr *Reader) readTrailers() error {
+	for {
+		line, err := readLine(cr.r)
+		if err != nil {
+			return err
+		}
+		_, done, err := cr.Trailers.Parse([]byte(line + "\r\n"))
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+func readChunkSize(r *bufio.Reader) (int64, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return 0, err
+	}
+
+	// chunk extensions are allowed after a ';' and are ignored
+	if idx := strings.IndexByte(line, ';'); idx != -1 {
+		line = line[:idx]
+	}
+	line = strings.TrimSpace(line)
+
+	size, err := strconv.ParseInt(line, 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("chunked: invalid chunk size %q", line)
+	}
+	return size, nil
+}
+
+func expectCRLF(r *bufio.Reader) error {
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return fmt.Errorf("chunked: missing CRLF after chunk data")
+	}
+	return nil
+}
+
+// readLine reads a single line and strips its CRLF (or bare LF) terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return "", ErrLineTooLong
+		}
+		if errors.Is(err, io.EOF) {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if len(line) > maxLineLength {
+		return "", ErrLineTooLong
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
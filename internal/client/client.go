@@ -0,0 +1,388 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxIdleConnsPerHost = 2
+	defaultIdleTimeout         = 90 * time.Second
+	defaultMaxRedirects        = 10
+	defaultDialTimeout         = 30 * time.Second
+)
+
+// Client is an HTTP/1.1 client built on the request, headers and chunked
+// packages. Connections are kept alive and pooled per scheme and host.
+type Client struct {
+	// MaxIdleConnsPerHost caps the number of idle connections kept per host.
+	MaxIdleConnsPerHost int
+	// IdleTimeout is how long an idle connection stays in the pool.
+	IdleTimeout time.Duration
+	// MaxRedirects is how many redirects Do follows; zero disables following.
+	MaxRedirects int
+	TLSConfig    *tls.Config
+	Dialer       *net.Dialer
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+func New() *Client {
+	return &Client{
+		MaxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+		IdleTimeout:         defaultIdleTimeout,
+		MaxRedirects:        defaultMaxRedirects,
+		Dialer:              &net.Dialer{Timeout: defaultDialTimeout},
+	}
+}
+
+type persistConn struct {
+	key    string
+	conn   net.Conn
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	idleAt time.Time
+}
+
+// Get issues a GET request for rawURL.
+func (c *Client) Get(ctx context.Context, rawURL string) (*Response, error) {
+	req, err := request.NewBuilder("GET", rawURL).Build()
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req)
+}
+
+// Do sends req and returns the response. The request target must be an
+// absolute URL such as "http://localhost:42069/path". ctx bounds the whole
+// exchange, including reading the response body. A redirect that would
+// resend a BodyReader, which cannot be replayed, is returned unfollowed.
+//
+// The caller must close the response body; a body read to EOF returns its
+// connection to the pool.
+func (c *Client) Do(ctx context.Context, req *request.Request) (*Response, error) {
+	u, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request target: %w", err)
+	}
+
+	for redirects := 0; ; redirects++ {
+		res, err := c.roundTrip(ctx, u, req)
+		if err != nil {
+			return nil, err
+		}
+
+		location, ok := res.Headers.Get("Location")
+		if !ok || !isRedirect(res.StatusCode) || c.MaxRedirects == 0 {
+			return res, nil
+		}
+		if redirects >= c.MaxRedirects {
+			res.Body.Close()
+			return nil, fmt.Errorf("stopped after %d redirects", c.MaxRedirects)
+		}
+
+		next, err := u.Parse(location)
+		if err != nil {
+			res.Body.Close()
+			return nil, fmt.Errorf("invalid redirect location %q: %w", location, err)
+		}
+		// a streamed body has already been consumed and cannot be resent,
+		// so only redirects that drop the body are followed
+		nextReq := redirectRequest(req, res.StatusCode, u, next)
+		if nextReq.BodyReader != nil {
+			return res, nil
+		}
+
+		// draining a short body lets the connection go back to the pool
+		io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+
+		req = nextReq
+		u = next
+	}
+}
+
+// CloseIdleConnections closes every pooled connection.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+	}
+}
+
+func (c *Client) roundTrip(ctx context.Context, u *url.URL, req *request.Request) (*Response, error) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in %q", u.String())
+	}
+
+	for {
+		pc, reused, err := c.getConn(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := c.exchange(ctx, pc, u, req)
+		if err == nil {
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// a pooled connection may have been closed by the server while it
+		// sat idle; that is only worth retrying on a fresh connection
+		if reused && isStaleConnErr(err) && req.BodyReader == nil {
+			continue
+		}
+		return nil, err
+	}
+}
+
+func (c *Client) exchange(ctx context.Context, pc *persistConn, u *url.URL, req *request.Request) (*Response, error) {
+	// unblock any pending read or write as soon as ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		pc.conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	_, err := wireRequest(req, u).WriteTo(pc.bw)
+	if err == nil {
+		err = pc.bw.Flush()
+	}
+	if err != nil {
+		stop()
+		pc.conn.Close()
+		return nil, err
+	}
+
+	res, err := ReadResponse(pc.br, req.RequestLine.Method)
+	if err != nil {
+		stop()
+		pc.conn.Close()
+		return nil, err
+	}
+
+	b := &body{
+		r:        res.Body,
+		ctx:      ctx,
+		pc:       pc,
+		client:   c,
+		stop:     stop,
+		reusable: reusable(req, res),
+	}
+	if !res.hasBody(req.RequestLine.Method) {
+		b.release(true)
+	}
+	res.Body = b
+	return res, nil
+}
+
+func (c *Client) getConn(ctx context.Context, u *url.URL) (*persistConn, bool, error) {
+	key := u.Scheme + "://" + hostPort(u)
+
+	c.mu.Lock()
+	for len(c.idle[key]) > 0 {
+		conns := c.idle[key]
+		pc := conns[len(conns)-1]
+		c.idle[key] = conns[:len(conns)-1]
+		if c.IdleTimeout > 0 && time.Since(pc.idleAt) > c.IdleTimeout {
+			pc.conn.Close()
+			continue
+		}
+		c.mu.Unlock()
+		return pc, true, nil
+	}
+	c.mu.Unlock()
+
+	pc, err := c.dial(ctx, u, key)
+	return pc, false, err
+}
+
+func (c *Client) dial(ctx context.Context, u *url.URL, key string) (*persistConn, error) {
+	dialer := c.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: defaultDialTimeout}
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort(u))
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "https" {
+		cfg := &tls.Config{}
+		if c.TLSConfig != nil {
+			cfg = c.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	return &persistConn{
+		key:  key,
+		conn: conn,
+		br:   bufio.NewReader(conn),
+		bw:   bufio.NewWriter(conn),
+	}, nil
+}
+
+func (c *Client) putIdle(pc *persistConn) {
+	pc.conn.SetDeadline(time.Time{})
+	pc.idleAt = time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle == nil {
+		c.idle = map[string][]*persistConn{}
+	}
+	if len(c.idle[pc.key]) >= c.MaxIdleConnsPerHost {
+		pc.conn.Close()
+		return
+	}
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+}
+
+// body hands its connection back to the client once the response body has
+// been read to EOF, or closes it if the body is abandoned early.
+type body struct {
+	r        io.Reader
+	ctx      context.Context
+	pc       *persistConn
+	client   *Client
+	stop     func() bool
+	reusable bool
+	once     sync.Once
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			b.release(true)
+			return n, io.EOF
+		}
+		b.release(false)
+		if b.ctx.Err() != nil {
+			err = b.ctx.Err()
+		}
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.release(false)
+	return nil
+}
+
+func (b *body) release(complete bool) {
+	b.once.Do(func() {
+		// stop reports false when the context already fired, in which case
+		// the connection has a deadline in the past and is useless
+		stopped := b.stop()
+		if complete && stopped && b.reusable {
+			b.client.putIdle(b.pc)
+			return
+		}
+		b.pc.conn.Close()
+	})
+}
+
+// wireRequest rewrites req for sending to u's origin: the target becomes
+// origin-form and the Host header is filled in from u.
+func wireRequest(req *request.Request, u *url.URL) *request.Request {
+	out := req.Clone()
+	out.RequestLine.RequestTarget = u.RequestURI()
+	if _, ok := out.Headers.Get("Host"); !ok {
+		out.Headers.Set("Host", u.Host)
+	}
+	return out
+}
+
+func redirectRequest(req *request.Request, status response.StatusCode, from, to *url.URL) *request.Request {
+	next := req.Clone()
+	next.RequestLine.RequestTarget = to.String()
+	next.Headers.Remove("Host")
+
+	method := req.RequestLine.Method
+	if status == 303 || ((status == 301 || status == 302) && method == "POST") {
+		if method != "HEAD" {
+			next.RequestLine.Method = "GET"
+		}
+		next.Body = nil
+		next.BodyReader = nil
+		next.Headers.Remove("Content-Length")
+		next.Headers.Remove("Content-Type")
+		next.Headers.Remove("Transfer-Encoding")
+	}
+
+	if !strings.EqualFold(from.Hostname(), to.Hostname()) {
+		next.Headers.Remove("Authorization")
+		next.Headers.Remove("Cookie")
+	}
+	return next
+}
+
+func reusable(req *request.Request, res *Response) bool {
+	if res.HttpVersion != "1.1" || res.closeDelimited(req.RequestLine.Method) {
+		return false
+	}
+	for _, h := range []headers.Headers{req.Headers, res.Headers} {
+		if v, ok := h.Get("Connection"); ok && strings.EqualFold(strings.TrimSpace(v), "close") {
+			return false
+		}
+	}
+	return true
+}
+
+func isRedirect(status response.StatusCode) bool {
+	switch status {
+	case 301, 302, 303, 307, 308:
+		return true
+	}
+	return false
+}
+
+func isStaleConnErr(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
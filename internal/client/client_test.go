@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"httpfromtcp/internal/request"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawServer answers every request on a connection with whatever respond
+// returns, and counts the connections it accepted.
+type rawServer struct {
+	listener net.Listener
+	conns    atomic.Int32
+}
+
+func newRawServer(t *testing.T, respond func(req *request.Request) string) *rawServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &rawServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.conns.Add(1)
+			go func() {
+				defer conn.Close()
+				for {
+					req, err := request.RequestFromReader(conn)
+					if err != nil {
+						return
+					}
+					if _, err := io.WriteString(conn, respond(req)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *rawServer) url(path string) string {
+	return "http://" + s.listener.Addr().String() + path
+}
+
+func TestClientDo(t *testing.T) {
+	// Test: Content-Length body and keep-alive reuse
+	srv := newRawServer(t, func(req *request.Request) string {
+		body := "hello from " + req.RequestLine.RequestTarget
+		return "HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	})
+	c := New()
+	for _, path := range []string{"/one", "/two"} {
+		res, err := c.Get(context.Background(), srv.url(path))
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, "hello from "+path, string(body))
+		assert.Equal(t, 200, int(res.StatusCode))
+		assert.Equal(t, "OK", res.Reason)
+	}
+	assert.Equal(t, int32(1), srv.conns.Load())
+
+	// Test: Interim 1xx responses are skipped and the connection is reused
+	srv = newRawServer(t, func(req *request.Request) string {
+		body := "hello from " + req.RequestLine.RequestTarget
+		return "HTTP/1.1 100 Continue\r\n\r\n" +
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	})
+	c = New()
+	for _, path := range []string{"/one", "/two"} {
+		res, err := c.Get(context.Background(), srv.url(path))
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, 200, int(res.StatusCode))
+		assert.Equal(t, "hello from "+path, string(body))
+	}
+	assert.Equal(t, int32(1), srv.conns.Load())
+
+	// Test: Chunked body with trailers
+	srv = newRawServer(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+			"5\r\nhello\r\n7;ext=1\r\n, world\r\n0\r\nX-Sum: abc\r\n\r\n"
+	})
+	res, err := c.Get(context.Background(), srv.url("/chunked"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(body))
+	v, ok := res.Trailers.Get("X-Sum")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", v)
+
+	// Test: Connection: close is not pooled
+	srv = newRawServer(t, func(req *request.Request) string {
+		return "HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n"
+	})
+	for i := 0; i < 2; i++ {
+		res, err := c.Get(context.Background(), srv.url("/"))
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+	assert.Equal(t, int32(2), srv.conns.Load())
+
+	// Test: Redirects are followed and 303 switches to GET
+	var methods []string
+	srv = newRawServer(t, func(req *request.Request) string {
+		methods = append(methods, req.RequestLine.Method)
+		if req.RequestLine.RequestTarget == "/start" {
+			return "HTTP/1.1 303 See Other\r\nLocation: /end\r\nContent-Length: 0\r\n\r\n"
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\ndone"
+	})
+	req := &request.Request{
+		RequestLine: request.RequestLine{HttpVersion: "1.1", RequestTarget: srv.url("/start"), Method: "POST"},
+		Headers:     map[string]string{},
+		Body:        []byte("payload"),
+	}
+	res, err = c.Do(context.Background(), req)
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "done", string(body))
+	assert.Equal(t, []string{"POST", "GET"}, methods)
+
+	// Test: Redirects that would resend a streamed body are not followed
+	for _, status := range []string{"301 Moved Permanently", "302 Found", "307 Temporary Redirect", "308 Permanent Redirect"} {
+		methods = nil
+		srv = newRawServer(t, func(req *request.Request) string {
+			methods = append(methods, req.RequestLine.Method)
+			return "HTTP/1.1 " + status + "\r\nLocation: /end\r\nContent-Length: 0\r\n\r\n"
+		})
+		req, err = request.NewBuilder("PUT", srv.url("/start")).BodyReader(strings.NewReader("payload")).Build()
+		require.NoError(t, err)
+		res, err = c.Do(context.Background(), req)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, status[:3], strconv.Itoa(int(res.StatusCode)))
+		assert.Equal(t, []string{"PUT"}, methods)
+	}
+
+	// Test: Redirect loops stop
+	srv = newRawServer(t, func(req *request.Request) string {
+		return "HTTP/1.1 302 Found\r\nLocation: /again\r\nContent-Length: 0\r\n\r\n"
+	})
+	_, err = c.Get(context.Background(), srv.url("/"))
+	require.Error(t, err)
+
+	// Test: Context deadline aborts a stalled response
+	srv = newRawServer(t, func(req *request.Request) string {
+		time.Sleep(200 * time.Millisecond)
+		return "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.Get(ctx, srv.url("/slow"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
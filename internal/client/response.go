@@ -0,0 +1,212 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/chunked"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/response"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxHeaderBytes = 1 << 20
+
+// Response is an HTTP/1.1 response read from an upstream server.
+type Response struct {
+	HttpVersion string
+	StatusCode  response.StatusCode
+	Reason      string
+	Headers     headers.Headers
+	// SetCookies are the Set-Cookie lines one by one. Headers joins them
+	// with commas, which cannot be split again since Expires dates hold
+	// commas too.
+	SetCookies []string
+	// Trailers is filled in once Body has been read to EOF.
+	Trailers headers.Headers
+	Body     io.ReadCloser
+}
+
+// ReadResponse parses a response from r. method is the method of the request
+// the response answers, since HEAD responses never carry a body.
+//
+// Interim 1xx responses, such as 100 Continue and 103 Early Hints, are
+// skipped; 101 Switching Protocols is final and returned.
+//
+// Only the bytes belonging to this response are consumed from r, so the
+// reader can be reused for the next response on a persistent connection.
+func ReadResponse(r *bufio.Reader, method string) (*Response, error) {
+	var res *Response
+	for {
+		var err error
+		res, err = readHead(r)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode < 100 || res.StatusCode >= 200 || res.StatusCode == 101 {
+			break
+		}
+	}
+
+	body, err := res.bodyReader(r, method)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(body)
+	return res, nil
+}
+
+// readHead reads the status line and headers of a response.
+func readHead(r *bufio.Reader) (*Response, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := parseStatusLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Headers = headers.NewHeaders()
+	res.Trailers = headers.NewHeaders()
+	read := 0
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		read += len(line)
+		if read > maxHeaderBytes {
+			return nil, fmt.Errorf("response headers exceed %d bytes", maxHeaderBytes)
+		}
+
+		_, done, err := res.Headers.Parse([]byte(line + "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return res, nil
+		}
+		if name, value, _ := strings.Cut(line, ":"); strings.EqualFold(strings.TrimSpace(name), "Set-Cookie") {
+			res.SetCookies = append(res.SetCookies, strings.TrimSpace(value))
+		}
+	}
+}
+
+func (res *Response) bodyReader(r *bufio.Reader, method string) (io.Reader, error) {
+	if !res.hasBody(method) {
+		return strings.NewReader(""), nil
+	}
+
+	if te, ok := res.Headers.Get("Transfer-Encoding"); ok && isChunked(te) {
+		cr := chunked.NewReader(r)
+		cr.Trailers = res.Trailers
+		return cr, nil
+	}
+
+	if cl, ok := res.Headers.Get("Content-Length"); ok {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid Content-Length: %q", cl)
+		}
+		return &exactReader{r: r, remaining: n}, nil
+	}
+
+	// neither framing header is present, so the body runs until the server
+	// closes the connection
+	return r, nil
+}
+
+func (res *Response) hasBody(method string) bool {
+	if method == "HEAD" {
+		return false
+	}
+	code := int(res.StatusCode)
+	return !(code >= 100 && code < 200) && code != 204 && code != 304
+}
+
+// closeDelimited reports whether the body of res is only terminated by the
+// server closing the connection.
+func (res *Response) closeDelimited(method string) bool {
+	if !res.hasBody(method) {
+		return false
+	}
+	if te, ok := res.Headers.Get("Transfer-Encoding"); ok && isChunked(te) {
+		return false
+	}
+	_, ok := res.Headers.Get("Content-Length")
+	return !ok
+}
+
+func parseStatusLine(line string) (*Response, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid status line: %q", line)
+	}
+
+	httpParts := strings.Split(parts[0], "/")
+	if len(httpParts) != 2 || httpParts[0] != "HTTP" {
+		return nil, fmt.Errorf("unrecognized http version: %q", parts[0])
+	}
+	if httpParts[1] != "1.1" && httpParts[1] != "1.0" {
+		return nil, fmt.Errorf("unsupported http version: %q", parts[0])
+	}
+
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 {
+		return nil, fmt.Errorf("invalid status code: %q", parts[1])
+	}
+
+	res := &Response{
+		HttpVersion: httpParts[1],
+		StatusCode:  response.StatusCode(code),
+	}
+	if len(parts) == 3 {
+		res.Reason = parts[2]
+	}
+	return res, nil
+}
+
+func isChunked(te string) bool {
+	codings := strings.Split(te, ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return "", fmt.Errorf("response line too long")
+		}
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+// exactReader reads a Content-Length delimited body and reports a truncated
+// body as io.ErrUnexpectedEOF rather than a clean EOF.
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (er *exactReader) Read(p []byte) (int, error) {
+	if er.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > er.remaining {
+		p = p[:er.remaining]
+	}
+	n, err := er.r.Read(p)
+	er.remaining -= int64(n)
+	if err != nil && errors.Is(err, io.EOF) && er.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,219 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SameSite is the SameSite attribute of a cookie.
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out, so browsers apply their
+	// own default.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// Cookie is a cookie sent by a client, or set by a server with Set-Cookie
+// (RFC 6265). Only Name and Value are sent by clients.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Domain  string
+	Path    string
+	Expires time.Time
+	// MaxAge is the lifetime in seconds. Zero leaves the attribute out, a
+	// negative value deletes the cookie with "Max-Age=0".
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned keeps the cookie in storage partitioned by the top-level
+	// site (CHIPS). It requires Secure.
+	Partitioned bool
+}
+
+// Valid reports why c cannot be sent in a Set-Cookie header.
+func (c *Cookie) Valid() error {
+	if c.Name == "" || !headers.ValidName(c.Name) {
+		return fmt.Errorf("invalid cookie name %q", c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("invalid value for cookie %s", c.Name)
+	}
+	if !validAttribute(c.Domain) || !validAttribute(c.Path) {
+		return fmt.Errorf("invalid domain or path for cookie %s", c.Name)
+	}
+	if (c.Partitioned || c.SameSite == SameSiteNone) && !c.Secure {
+		return fmt.Errorf("cookie %s must be Secure to be Partitioned or SameSite=None", c.Name)
+	}
+	return nil
+}
+
+// String formats c as a Set-Cookie header value.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+	if c.Domain != "" {
+		b.WriteString("; Domain=")
+		b.WriteString(strings.TrimPrefix(c.Domain, "."))
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(c.Path)
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=")
+		b.WriteString(headers.FormatTime(c.Expires))
+	}
+	switch {
+	case c.MaxAge > 0:
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=")
+		b.WriteString(c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Parse parses the cookies of a Cookie header value. Pairs that are not
+// valid cookies are skipped. Commas separate pairs as well as semicolons,
+// since Headers joins repeated Cookie lines with one and a cookie value
+// cannot contain it.
+func Parse(v string) []*Cookie {
+	var cookies []*Cookie
+	for _, pair := range strings.FieldsFunc(v, func(r rune) bool { return r == ';' || r == ',' }) {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !headers.ValidName(name) {
+			continue
+		}
+		value, ok = unquote(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// ParseSetCookie parses a Set-Cookie header value. Unknown attributes and
+// attributes with invalid values are ignored, as RFC 6265 section 5.2 asks
+// of user agents.
+func ParseSetCookie(v string) (*Cookie, error) {
+	parts := strings.Split(v, ";")
+	name, value, ok := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	name = strings.TrimSpace(name)
+	if !ok || !headers.ValidName(name) {
+		return nil, errors.New("invalid Set-Cookie name")
+	}
+	value, ok = unquote(strings.TrimSpace(value))
+	if !ok {
+		return nil, fmt.Errorf("invalid value for cookie %s", name)
+	}
+
+	c := &Cookie{Name: name, Value: value}
+	for _, attr := range parts[1:] {
+		key, val, _ := strings.Cut(attr, "=")
+		key, val = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(val)
+		switch key {
+		case "domain":
+			c.Domain = strings.ToLower(strings.TrimPrefix(val, "."))
+		case "path":
+			if strings.HasPrefix(val, "/") {
+				c.Path = val
+			}
+		case "expires":
+			if t, err := headers.ParseTime(val); err == nil {
+				c.Expires = t
+			}
+		case "max-age":
+			if n, err := strconv.Atoi(val); err == nil {
+				c.MaxAge = n
+				if n <= 0 {
+					c.MaxAge = -1
+				}
+			}
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(val) {
+			case "lax":
+				c.SameSite = SameSiteLax
+			case "strict":
+				c.SameSite = SameSiteStrict
+			case "none":
+				c.SameSite = SameSiteNone
+			}
+		case "partitioned":
+			c.Partitioned = true
+		}
+	}
+	return c, nil
+}
+
+// unquote strips the optional double quotes around a cookie value and
+// reports whether what is left only has cookie-octets.
+func unquote(v string) (string, bool) {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	return v, validValue(v)
+}
+
+// validValue reports whether v only has cookie-octets (RFC 6265 section
+// 4.1.1): visible ASCII except DQUOTE, comma, semicolon and backslash.
+func validValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func validAttribute(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c < ' ' || c == 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
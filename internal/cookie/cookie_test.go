@@ -0,0 +1,99 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Pairs are split on semicolons, quotes are removed
+	cookies := Parse(`session=abc123; theme="dark"; empty=`)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, &Cookie{Name: "session", Value: "abc123"}, cookies[0])
+	assert.Equal(t, &Cookie{Name: "theme", Value: "dark"}, cookies[1])
+	assert.Equal(t, &Cookie{Name: "empty", Value: ""}, cookies[2])
+
+	// Test: Repeated Cookie lines joined by Headers still split
+	cookies = Parse("a=1, b=2")
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "b", cookies[1].Name)
+
+	// Test: Invalid pairs are skipped
+	cookies = Parse(`novalue; bad name=x; ok=1; quote=a"b; =x`)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+	assert.Empty(t, Parse(""))
+}
+
+func TestString(t *testing.T) {
+	expires := time.Date(2030, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+	// Test: Every attribute is written in order
+	c := &Cookie{
+		Name:        "id",
+		Value:       "a3fWa",
+		Domain:      ".example.com",
+		Path:        "/",
+		Expires:     expires,
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "id=a3fWa; Domain=example.com; Path=/; Expires=Wed, 02 Jan 2030 15:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	// Test: A negative MaxAge deletes the cookie
+	c = &Cookie{Name: "id", MaxAge: -1, SameSite: SameSiteLax}
+	assert.Equal(t, "id=; Max-Age=0; SameSite=Lax", c.String())
+
+	// Test: Invalid cookies are refused
+	assert.Error(t, (&Cookie{Name: "", Value: "x"}).Valid())
+	assert.Error(t, (&Cookie{Name: "a b", Value: "x"}).Valid())
+	assert.Error(t, (&Cookie{Name: "a", Value: "x;y"}).Valid())
+	assert.Error(t, (&Cookie{Name: "a", Value: "x y"}).Valid())
+	assert.Error(t, (&Cookie{Name: "a", Path: "/\r\nX: y"}).Valid())
+	assert.Error(t, (&Cookie{Name: "a", Partitioned: true}).Valid())
+	assert.Error(t, (&Cookie{Name: "a", SameSite: SameSiteNone}).Valid())
+}
+
+func TestParseSetCookie(t *testing.T) {
+	// Test: Attributes are read case-insensitively
+	c, err := ParseSetCookie(`id="a3fWa"; domain=.Example.com; path=/docs; expires=Wed, 02 Jan 2030 15:04:05 GMT; max-age=60; secure; HTTPONLY; SameSite=strict; Partitioned`)
+	require.NoError(t, err)
+	assert.Equal(t, &Cookie{
+		Name:        "id",
+		Value:       "a3fWa",
+		Domain:      "example.com",
+		Path:        "/docs",
+		Expires:     time.Date(2030, time.January, 2, 15, 4, 5, 0, time.UTC),
+		MaxAge:      60,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteStrict,
+		Partitioned: true,
+	}, c)
+
+	// Test: String round-trips
+	again, err := ParseSetCookie(c.String())
+	require.NoError(t, err)
+	assert.Equal(t, c, again)
+
+	// Test: Invalid attribute values are ignored
+	c, err = ParseSetCookie("a=b; Max-Age=soon; Expires=tomorrow; Path=docs; SameSite=sometimes; Flavour=mint")
+	require.NoError(t, err)
+	assert.Equal(t, &Cookie{Name: "a", Value: "b"}, c)
+	c, err = ParseSetCookie("a=b; Max-Age=0")
+	require.NoError(t, err)
+	assert.Equal(t, -1, c.MaxAge)
+
+	// Test: A missing or invalid name is an error
+	_, err = ParseSetCookie("justvalue")
+	assert.Error(t, err)
+	_, err = ParseSetCookie("a=b\"c")
+	assert.Error(t, err)
+}
@@ -0,0 +1,321 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var errIsDir = errors.New("is a directory")
+
+// FileServer serves files from an fs.FS, such as os.DirFS or an embed.FS.
+type FileServer struct {
+	fsys fs.FS
+	// StripPrefix is removed from the request path before looking up the
+	// file, so "/assets/vim.mp4" can map to "vim.mp4".
+	StripPrefix string
+	// IndexFile is served for a directory that contains it.
+	IndexFile string
+	// Listing enables HTML or JSON listings of directories without an index.
+	Listing bool
+	// Precompressed serves "name.gz", when it exists, in place of name to
+	// clients that accept gzip.
+	Precompressed bool
+}
+
+func New(fsys fs.FS) *FileServer {
+	return &FileServer{
+		fsys:          fsys,
+		IndexFile:     "index.html",
+		Precompressed: true,
+	}
+}
+
+// Dir serves the files below root on disk.
+func Dir(root string) *FileServer {
+	return New(os.DirFS(root))
+}
+
+// Handle is a server.Handler.
+func (s *FileServer) Handle(w *response.Writer, req *request.Request) {
+	if !s.checkMethod(w, req) {
+		return
+	}
+
+	urlPath, err := url.PathUnescape(req.RequestLine.Path())
+	if err != nil || strings.ContainsAny(urlPath, "\x00\\") {
+		problem.Error(w, req, response.StatusCodeBadRequest)
+		return
+	}
+	// cleaning a rooted path resolves every ".." without ever leaving "/"
+	urlPath = path.Clean("/" + urlPath)
+	if s.StripPrefix != "" {
+		stripped, ok := strings.CutPrefix(urlPath, strings.TrimSuffix(s.StripPrefix, "/"))
+		if !ok || stripped != "" && !strings.HasPrefix(stripped, "/") {
+			problem.Error(w, req, response.StatusCodeNotFound)
+			return
+		}
+		urlPath = path.Clean("/" + stripped)
+	}
+
+	name := strings.TrimPrefix(urlPath, "/")
+	if name == "" {
+		name = "."
+	}
+
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		writeFSError(w, req, err)
+		return
+	}
+
+	if info.IsDir() {
+		// directories are served with a trailing slash so relative links in
+		// an index or listing resolve inside them. The redirect is relative,
+		// as a path like //evil.com/.. would otherwise send the client off
+		// to another host.
+		if !strings.HasSuffix(req.RequestLine.Path(), "/") {
+			location := "./" + path.Base(req.RequestLine.Path()) + "/"
+			if u, err := req.RequestLine.URL(); err == nil && u.RawQuery != "" {
+				location += "?" + u.RawQuery
+			}
+			redirect(w, location)
+			return
+		}
+		s.serveDir(w, req, name)
+		return
+	}
+
+	s.serveFile(w, req, name)
+}
+
+// ServeFile serves the named file regardless of the request path.
+func (s *FileServer) ServeFile(w *response.Writer, req *request.Request, name string) {
+	if !s.checkMethod(w, req) {
+		return
+	}
+	if !fs.ValidPath(name) {
+		problem.Error(w, req, response.StatusCodeBadRequest)
+		return
+	}
+	s.serveFile(w, req, name)
+}
+
+func (s *FileServer) checkMethod(w *response.Writer, req *request.Request) bool {
+	if req.RequestLine.Method == "GET" || req.RequestLine.Method == "HEAD" {
+		return true
+	}
+	w.OnWriteHeaders(func(_ response.StatusCode, h headers.Headers) {
+		h.Set("Allow", "GET, HEAD")
+	})
+	problem.Error(w, req, response.StatusCodeMethodNotAllowed)
+	return false
+}
+
+func (s *FileServer) serveDir(w *response.Writer, req *request.Request, name string) {
+	if s.IndexFile != "" {
+		index := path.Join(name, s.IndexFile)
+		if info, err := fs.Stat(s.fsys, index); err == nil && !info.IsDir() {
+			s.serveFile(w, req, index)
+			return
+		}
+	}
+
+	if !s.Listing {
+		problem.Error(w, req, response.StatusCodeForbidden)
+		return
+	}
+
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		writeFSError(w, req, err)
+		return
+	}
+	writeListing(w, req, entries)
+}
+
+func (s *FileServer) serveFile(w *response.Writer, req *request.Request, name string) {
+	f, info, err := s.open(name)
+	if err != nil {
+		writeFSError(w, req, err)
+		return
+	}
+	defer func() { f.Close() }()
+
+	// a precompressed sibling is a different representation of the same
+	// resource, so its type comes from the original name
+	contentType := typeByExtension(name)
+	encoding := ""
+	vary := false
+	if s.Precompressed && contentType != "" {
+		if gz, gzInfo, err := s.open(name + ".gz"); err == nil {
+			vary = true
+			if headers.NegotiateEncoding(req.Headers, "gzip") == "gzip" {
+				f.Close()
+				f, info, encoding = gz, gzInfo, "gzip"
+			} else {
+				gz.Close()
+			}
+		}
+	}
+
+	content, size, err := openContent(f, info)
+	if err != nil {
+		log.Printf("fileserver: reading %s: %v", name, err)
+		problem.Error(w, req, response.StatusCodeInternalServerError)
+		return
+	}
+
+	modTime := info.ModTime()
+	etag := fileETag(modTime, size)
+	h := response.GetDefaultHeaders(int(size))
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("ETag", etag)
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+	if vary {
+		h.Set("Vary", "Accept-Encoding")
+	}
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", headers.FormatTime(modTime))
+	}
+
+	switch response.CheckPreconditions(req, etag, modTime) {
+	case response.StatusCodeNotModified:
+		w.WriteStatusLine(response.StatusCodeNotModified)
+		w.WriteHeaders(response.NotModifiedHeaders(h))
+		return
+	case response.StatusCodePreconditionFailed:
+		problem.Error(w, req, response.StatusCodePreconditionFailed)
+		return
+	}
+
+	if contentType == "" {
+		contentType, err = sniffFile(content)
+		if err != nil {
+			log.Printf("fileserver: reading %s: %v", name, err)
+			problem.Error(w, req, response.StatusCodeInternalServerError)
+			return
+		}
+	}
+	h.Override("Content-Type", contentType)
+
+	// Range only applies to GET (RFC 9110 section 14.2)
+	rangeHeader, ok := req.Headers.Get("Range")
+	if ok && req.RequestLine.Method == "GET" && ifRangeMatches(req.Headers, etag, modTime) {
+		ranges, err := parseRange(rangeHeader, size)
+		switch {
+		case errors.Is(err, errUnsatisfiable):
+			writeUnsatisfiable(w, size)
+			return
+		// ranges adding up to more than the file are served whole rather
+		// than amplified into a larger multipart body
+		case err == nil && len(ranges) > 0 && totalLength(ranges) <= size:
+			if err := writeRanges(w, h, content, size, ranges); err != nil {
+				log.Printf("fileserver: sending %s: %v", name, err)
+			}
+			return
+		}
+	}
+
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	if _, err := w.WriteBodyFrom(content, size); err != nil {
+		log.Printf("fileserver: sending %s: %v", name, err)
+	}
+}
+
+// fileETag identifies a version of a file by its modification time and
+// size. It is strong: a rewrite that keeps both within the same nanosecond
+// is not a concern for static files.
+func fileETag(modTime time.Time, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// content is a file that can be read from any offset. Files from os.DirFS,
+// embed.FS and fstest.MapFS all are.
+type content interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// openContent returns f as content together with its size. Files that
+// cannot seek are read into memory.
+func openContent(f fs.File, info fs.FileInfo) (content, int64, error) {
+	if c, ok := f.(content); ok {
+		return c, info.Size(), nil
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+// sniffFile detects the type of c from its first bytes and rewinds it.
+func sniffFile(c content) (string, error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(c, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	if _, err := c.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return sniffContentType(buf[:n]), nil
+}
+
+// open opens the regular file name.
+func (s *FileServer) open(name string) (fs.File, fs.FileInfo, error) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, nil, errIsDir
+	}
+	return f, info, nil
+}
+
+func redirect(w *response.Writer, location string) {
+	body := []byte(fmt.Sprintf("Moved to %s\n", location))
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Location", location)
+	w.WriteStatusLine(response.StatusCodeMovedPermanently)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func writeFSError(w *response.Writer, req *request.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		problem.Error(w, req, response.StatusCodeNotFound)
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, errIsDir):
+		problem.Error(w, req, response.StatusCodeForbidden)
+	default:
+		log.Printf("fileserver: %v", err)
+		problem.Error(w, req, response.StatusCodeInternalServerError)
+	}
+}
@@ -0,0 +1,306 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, handle func(*response.Writer, *request.Request), raw string) (*client.Response, string) {
+	req, err := request.RequestFromReader(bytes.NewBufferString(raw))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	handle(response.NewWriter(&out), req)
+	res, err := client.ReadResponse(bufio.NewReader(&out), req.RequestLine.Method)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func get(target string, extra ...string) string {
+	raw := "GET " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+	for _, h := range extra {
+		raw += h + "\r\n"
+	}
+	return raw + "\r\n"
+}
+
+func TestFileServer(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html":      {Data: []byte("<h1>home</h1>"), ModTime: modTime},
+		"style.css":       {Data: []byte("body{}"), ModTime: modTime},
+		"noext":           {Data: []byte("\x89PNG\r\n\x1a\nrest"), ModTime: modTime},
+		"notes":           {Data: []byte("just some text\n"), ModTime: modTime},
+		"docs/a.txt":      {Data: []byte("a"), ModTime: modTime},
+		"docs/<b>.txt":    {Data: []byte("bb"), ModTime: modTime},
+		"docs/sub/c.txt":  {Data: []byte("c"), ModTime: modTime},
+		"private/x.txt":   {Data: []byte("x"), ModTime: modTime},
+		"private/y/z.txt": {Data: []byte("z"), ModTime: modTime},
+		"media/clip.mp4":  {Data: []byte("\x00\x00\x00\x18ftypmp42"), ModTime: modTime},
+	}
+	s := New(fsys)
+	s.Listing = true
+
+	// Test: Files are served with a type from their extension
+	res, body := serve(t, s.Handle, get("/style.css"))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	assert.Equal(t, "body{}", body)
+	contentType, _ := res.Headers.Get("Content-Type")
+	assert.Equal(t, "text/css; charset=utf-8", contentType)
+	contentLength, _ := res.Headers.Get("Content-Length")
+	assert.Equal(t, "6", contentLength)
+
+	// Test: Files without a known extension have their type sniffed
+	res, _ = serve(t, s.Handle, get("/noext"))
+	contentType, _ = res.Headers.Get("Content-Type")
+	assert.Equal(t, "image/png", contentType)
+	res, _ = serve(t, s.Handle, get("/notes"))
+	contentType, _ = res.Headers.Get("Content-Type")
+	assert.Equal(t, "text/plain; charset=utf-8", contentType)
+
+	// Test: The root serves index.html
+	res, body = serve(t, s.Handle, get("/"))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	assert.Equal(t, "<h1>home</h1>", body)
+
+	// Test: Directories without a trailing slash redirect
+	res, _ = serve(t, s.Handle, get("/docs"))
+	assert.Equal(t, response.StatusCodeMovedPermanently, res.StatusCode)
+	location, _ := res.Headers.Get("Location")
+	assert.Equal(t, "./docs/", location)
+	res, _ = serve(t, s.Handle, get("/docs?sort=name"))
+	location, _ = res.Headers.Get("Location")
+	assert.Equal(t, "./docs/?sort=name", location)
+
+	// Test: Redirects stay on this host whatever the path
+	res, _ = serve(t, s.Handle, get("//evil.com/.."))
+	assert.Equal(t, response.StatusCodeMovedPermanently, res.StatusCode)
+	location, _ = res.Headers.Get("Location")
+	assert.Equal(t, "./../", location)
+
+	// Test: Directories without an index are listed as escaped HTML
+	res, body = serve(t, s.Handle, get("/docs/"))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	assert.Contains(t, body, `<a href="./a.txt">a.txt</a>`)
+	assert.Contains(t, body, `<a href="./sub/">sub/</a>`)
+	assert.Contains(t, body, `&lt;b&gt;.txt`)
+	assert.NotContains(t, body, "<b>")
+
+	// Test: Listings are JSON when the client asks for it
+	res, body = serve(t, s.Handle, get("/docs/", "Accept: application/json"))
+	contentType, _ = res.Headers.Get("Content-Type")
+	assert.Equal(t, "application/json", contentType)
+	var l listing
+	require.NoError(t, json.Unmarshal([]byte(body), &l))
+	assert.Equal(t, "/docs/", l.Path)
+	require.Len(t, l.Entries, 3)
+	assert.Equal(t, listingEntry{Name: "a.txt", Size: 1, ModTime: modTime}, l.Entries[1])
+	assert.True(t, l.Entries[2].Dir)
+
+	// Test: Listings can be turned off
+	s.Listing = false
+	res, _ = serve(t, s.Handle, get("/private/"))
+	assert.Equal(t, response.StatusCodeForbidden, res.StatusCode)
+	s.Listing = true
+
+	// Test: Missing files are a 404, not a crash
+	res, _ = serve(t, s.Handle, get("/missing.txt"))
+	assert.Equal(t, response.StatusCodeNotFound, res.StatusCode)
+
+	// Test: Traversal never escapes the root
+	for _, target := range []string{"/../../etc/passwd", "/docs/../../../etc/passwd", "/%2e%2e/%2e%2e/etc/passwd"} {
+		res, _ = serve(t, s.Handle, get(target))
+		assert.Equal(t, response.StatusCodeNotFound, res.StatusCode, target)
+	}
+	res, body = serve(t, s.Handle, get("/docs/../private/x.txt"))
+	assert.Equal(t, "x", body)
+
+	// Test: Encoded NUL bytes and backslashes are rejected
+	res, _ = serve(t, s.Handle, get("/docs%00/a.txt"))
+	assert.Equal(t, response.StatusCodeBadRequest, res.StatusCode)
+	res, _ = serve(t, s.Handle, get("/docs%5C..%5Cprivate"))
+	assert.Equal(t, response.StatusCodeBadRequest, res.StatusCode)
+
+	// Test: HEAD sends headers only
+	res, body = serve(t, s.Handle, "HEAD /style.css HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	contentLength, _ = res.Headers.Get("Content-Length")
+	assert.Equal(t, "6", contentLength)
+	assert.Empty(t, body)
+
+	// Test: Other methods are not allowed
+	res, _ = serve(t, s.Handle, "DELETE /style.css HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, response.StatusCodeMethodNotAllowed, res.StatusCode)
+	allow, _ := res.Headers.Get("Allow")
+	assert.Equal(t, "GET, HEAD", allow)
+
+	// Test: StripPrefix maps a URL prefix onto the root
+	s.StripPrefix = "/assets"
+	res, body = serve(t, s.Handle, get("/assets/media/clip.mp4"))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	contentType, _ = res.Headers.Get("Content-Type")
+	assert.Equal(t, "video/mp4", contentType)
+	res, _ = serve(t, s.Handle, get("/elsewhere/style.css"))
+	assert.Equal(t, response.StatusCodeNotFound, res.StatusCode)
+	res, _ = serve(t, s.Handle, get("/assetsstyle.css"))
+	assert.Equal(t, response.StatusCodeNotFound, res.StatusCode)
+
+	// Test: ServeFile serves a fixed file whatever the path
+	res, body = serve(t, func(w *response.Writer, req *request.Request) {
+		s.ServeFile(w, req, "media/clip.mp4")
+	}, get("/video"))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	assert.Equal(t, "\x00\x00\x00\x18ftypmp42", body)
+}
+
+func TestFileServerStreaming(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 4<<20)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.bin"), data, 0o644))
+	s := Dir(dir)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					return
+				}
+				s.Handle(response.NewWriter(conn), req)
+			}()
+		}
+	}()
+	base := "http://" + listener.Addr().String()
+	c := client.New()
+
+	// Test: Files on disk are streamed over TCP with their full length
+	res, err := c.Get(context.Background(), base+"/big.bin")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	contentLength, _ := res.Headers.Get("Content-Length")
+	assert.Equal(t, strconv.Itoa(len(data)), contentLength)
+	assert.True(t, bytes.Equal(data, body))
+
+	// Test: A range in the middle of a file on disk is streamed from its offset
+	req, err := request.NewBuilder("GET", base+"/big.bin").Header("Range", "bytes=1000000-1000999").Build()
+	require.NoError(t, err)
+	res, err = c.Do(context.Background(), req)
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodePartialContent, res.StatusCode)
+	assert.True(t, bytes.Equal(data[1000000:1001000], body))
+}
+
+func TestFileServerConditional(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := New(fstest.MapFS{
+		"page.html": {Data: []byte("<p>hi</p>"), ModTime: modTime},
+	})
+
+	// Test: Files carry a strong ETag and Last-Modified
+	res, _ := serve(t, s.Handle, get("/page.html"))
+	etag, ok := res.Headers.Get("ETag")
+	require.True(t, ok)
+	assert.NotContains(t, etag, "W/")
+	lastModified, _ := res.Headers.Get("Last-Modified")
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", lastModified)
+
+	// Test: Matching validators give a 304 without a body
+	res, body := serve(t, s.Handle, get("/page.html", "If-None-Match: "+etag))
+	assert.Equal(t, response.StatusCodeNotModified, res.StatusCode)
+	assert.Empty(t, body)
+	v, _ := res.Headers.Get("ETag")
+	assert.Equal(t, etag, v)
+	res, _ = serve(t, s.Handle, get("/page.html", "If-Modified-Since: "+lastModified))
+	assert.Equal(t, response.StatusCodeNotModified, res.StatusCode)
+
+	// Test: Failed preconditions give a 412
+	res, _ = serve(t, s.Handle, get("/page.html", `If-Match: "other"`))
+	assert.Equal(t, response.StatusCodePreconditionFailed, res.StatusCode)
+	res, _ = serve(t, s.Handle, get("/page.html", "If-Unmodified-Since: Tue, 30 Apr 2024 12:00:00 GMT"))
+	assert.Equal(t, response.StatusCodePreconditionFailed, res.StatusCode)
+
+	// Test: If-Range accepts the current ETag
+	res, body = serve(t, s.Handle, get("/page.html", "Range: bytes=0-2", "If-Range: "+etag))
+	assert.Equal(t, response.StatusCodePartialContent, res.StatusCode)
+	assert.Equal(t, "<p>", body)
+}
+
+func TestFileServerPrecompressed(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("console.log('hi')"))
+	zw.Close()
+	s := New(fstest.MapFS{
+		"app.js":    {Data: []byte("console.log('hi')")},
+		"app.js.gz": {Data: gz.Bytes()},
+		"plain.css": {Data: []byte("a{}")},
+	})
+
+	// Test: Clients that accept gzip get the .gz sibling with the original type
+	res, body := serve(t, s.Handle, get("/app.js", "Accept-Encoding: gzip"))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	encoding, _ := res.Headers.Get("Content-Encoding")
+	assert.Equal(t, "gzip", encoding)
+	contentType, _ := res.Headers.Get("Content-Type")
+	assert.Equal(t, "text/javascript; charset=utf-8", contentType)
+	vary, _ := res.Headers.Get("Vary")
+	assert.Equal(t, "Accept-Encoding", vary)
+	assert.Equal(t, gz.String(), body)
+	gzETag, _ := res.Headers.Get("ETag")
+
+	// Test: Other clients get the original, which varies too
+	res, body = serve(t, s.Handle, get("/app.js"))
+	_, ok := res.Headers.Get("Content-Encoding")
+	assert.False(t, ok)
+	vary, _ = res.Headers.Get("Vary")
+	assert.Equal(t, "Accept-Encoding", vary)
+	assert.Equal(t, "console.log('hi')", body)
+	etag, _ := res.Headers.Get("ETag")
+	assert.NotEqual(t, gzETag, etag)
+
+	// Test: Files without a sibling do not vary
+	res, _ = serve(t, s.Handle, get("/plain.css", "Accept-Encoding: gzip"))
+	_, ok = res.Headers.Get("Vary")
+	assert.False(t, ok)
+
+	// Test: Precompressed files can be turned off
+	s.Precompressed = false
+	res, _ = serve(t, s.Handle, get("/app.js", "Accept-Encoding: gzip"))
+	_, ok = res.Headers.Get("Content-Encoding")
+	assert.False(t, ok)
+}
@@ -0,0 +1,105 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io/fs"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type listingEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Dir     bool      `json:"dir"`
+}
+
+type listing struct {
+	Path    string         `json:"path"`
+	Entries []listingEntry `json:"entries"`
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"href": func(e listingEntry) string {
+		name := (&url.URL{Path: e.Name}).EscapedPath()
+		if e.Dir {
+			name += "/"
+		}
+		// a name containing a colon would otherwise read as a URL scheme
+		return "./" + name
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <title>Index of {{.Path}}</title>
+  </head>
+  <body>
+    <h1>Index of {{.Path}}</h1>
+    <ul>
+{{- if ne .Path "/"}}
+      <li><a href="../">../</a></li>
+{{- end}}
+{{- range .Entries}}
+      <li><a href="{{href .}}">{{.Name}}{{if .Dir}}/{{end}}</a></li>
+{{- end}}
+    </ul>
+  </body>
+</html>
+`))
+
+// writeListing renders the directory entries as JSON when the client asks
+// for it and as HTML otherwise.
+func writeListing(w *response.Writer, req *request.Request, entries []fs.DirEntry) {
+	l := listing{
+		Path:    req.RequestLine.Path(),
+		Entries: make([]listingEntry, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// the entry was removed while listing
+			continue
+		}
+		l.Entries = append(l.Entries, listingEntry{
+			Name:    entry.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+			Dir:     entry.IsDir(),
+		})
+	}
+
+	var body bytes.Buffer
+	contentType := "text/html; charset=utf-8"
+	var err error
+	if wantsJSON(req) {
+		contentType = "application/json"
+		err = json.NewEncoder(&body).Encode(l)
+	} else {
+		err = listingTemplate.Execute(&body, l)
+	}
+	if err != nil {
+		log.Printf("fileserver: rendering listing of %s: %v", l.Path, err)
+		problem.Error(w, req, response.StatusCodeInternalServerError)
+		return
+	}
+
+	h := response.GetDefaultHeaders(body.Len())
+	h.Override("Content-Type", contentType)
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body.Bytes())
+	}
+}
+
+func wantsJSON(req *request.Request) bool {
+	accept, _ := req.Headers.Get("Accept")
+	return strings.Contains(accept, "application/json")
+}
@@ -0,0 +1,124 @@
+package fileserver
+
+import (
+	"bytes"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+const sniffLen = 512
+
+// mimeTypes covers common static and media files whose types the system
+// MIME tables do not always know.
+var mimeTypes = map[string]string{
+	".css":   "text/css; charset=utf-8",
+	".gif":   "image/gif",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/x-icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".m4a":   "audio/mp4",
+	".md":    "text/markdown; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".ogg":   "audio/ogg",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".txt":   "text/plain; charset=utf-8",
+	".wasm":  "application/wasm",
+	".webm":  "video/webm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "text/xml; charset=utf-8",
+}
+
+// typeByExtension returns the MIME type for name's extension, or "" if it
+// is unknown.
+func typeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := mimeTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+type signature struct {
+	offset      int
+	magic       []byte
+	contentType string
+}
+
+var signatures = []signature{
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{0, []byte("\xff\xd8\xff"), "image/jpeg"},
+	{0, []byte("GIF87a"), "image/gif"},
+	{0, []byte("GIF89a"), "image/gif"},
+	{8, []byte("WEBP"), "image/webp"},
+	{4, []byte("ftyp"), "video/mp4"},
+	{0, []byte("\x1a\x45\xdf\xa3"), "video/webm"},
+	{0, []byte("ID3"), "audio/mpeg"},
+	{0, []byte("OggS"), "application/ogg"},
+	{0, []byte("PK\x03\x04"), "application/zip"},
+	{0, []byte("\x1f\x8b\x08"), "application/gzip"},
+	{0, []byte("\x00asm"), "application/wasm"},
+}
+
+// sniffContentType guesses the type of data, the first bytes of a file,
+// from well-known magic numbers and falls back to text or binary.
+func sniffContentType(data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+
+	for _, sig := range signatures {
+		if len(data) >= sig.offset+len(sig.magic) && bytes.Equal(data[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.contentType
+		}
+	}
+
+	trimmed := bytes.ToLower(bytes.TrimLeft(data, " \t\r\n"))
+	for _, prefix := range []string{"<!doctype html", "<html", "<head", "<body"} {
+		if bytes.HasPrefix(trimmed, []byte(prefix)) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if bytes.HasPrefix(trimmed, []byte("<?xml")) {
+		return "text/xml; charset=utf-8"
+	}
+
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+func isText(data []byte) bool {
+	if !utf8.Valid(data) {
+		// the sniffed prefix may end in the middle of a multi-byte rune
+		valid := false
+		for cut := 1; cut < utf8.UTFMax && cut < len(data); cut++ {
+			if utf8.Valid(data[:len(data)-cut]) {
+				data, valid = data[:len(data)-cut], true
+				break
+			}
+		}
+		if !valid {
+			return false
+		}
+	}
+	for _, c := range data {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,203 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/response"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidRange  = errors.New("invalid range")
+	errUnsatisfiable = errors.New("range not satisfiable")
+)
+
+// byteRange is a resolved byte range within a representation.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange resolves a Range header against a representation of size
+// bytes (RFC 9110 section 14.1.2). Ranges that start past the end are
+// dropped; errUnsatisfiable is returned when none are left. A header that
+// does not parse returns errInvalidRange and should be ignored, as should
+// units other than bytes, for which nil ranges are returned.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(s), "bytes=")
+	if !ok {
+		if strings.Contains(s, "=") {
+			return nil, nil
+		}
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	seen := false
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		seen = true
+
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		if first == "" {
+			// suffix range: the final last bytes
+			n, err := parseOffset(last)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+
+		start, err := parseOffset(first)
+		if err != nil {
+			return nil, err
+		}
+		end := size - 1
+		if last != "" {
+			end, err = parseOffset(last)
+			if err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, errInvalidRange
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+
+	if !seen {
+		return nil, errInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	return ranges, nil
+}
+
+func parseOffset(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, errInvalidRange
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errInvalidRange
+	}
+	return n, nil
+}
+
+// totalLength sums the lengths of ranges.
+func totalLength(ranges []byteRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += r.length
+	}
+	return n
+}
+
+// ifRangeMatches reports whether the If-Range precondition in h, if any,
+// holds for the representation with the given validators (RFC 9110
+// section 13.1.5). Without it the Range header is honoured; when it does
+// not match the whole representation is sent instead.
+func ifRangeMatches(h headers.Headers, etag string, modTime time.Time) bool {
+	v, ok := h.Get("If-Range")
+	if !ok {
+		return true
+	}
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "W/") {
+		return response.ETagsMatch(v, etag, false)
+	}
+	t, err := headers.ParseTime(v)
+	if err != nil || modTime.IsZero() {
+		return false
+	}
+	return t.Equal(modTime.Truncate(time.Second))
+}
+
+// writeRanges sends a 206 with the requested ranges of c: a single range
+// as is, several as a multipart/byteranges body. h holds the headers of the
+// full response. The file is streamed, never read into memory.
+func writeRanges(w *response.Writer, h headers.Headers, c content, size int64, ranges []byteRange) error {
+	if len(ranges) == 1 {
+		r := ranges[0]
+		// seeking keeps the body an *os.File so it can still be sent with
+		// sendfile
+		if _, err := c.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+		h.Override("Content-Length", strconv.FormatInt(r.length, 10))
+		h.Override("Content-Range", r.contentRange(size))
+		w.WriteStatusLine(response.StatusCodePartialContent)
+		w.WriteHeaders(h)
+		_, err := w.WriteBodyFrom(c, r.length)
+		return err
+	}
+
+	contentType, _ := h.Get("Content-Type")
+	boundary := newBoundary()
+	var parts []io.Reader
+	var length int64
+	for i, r := range ranges {
+		var part strings.Builder
+		if i > 0 {
+			part.WriteString("\r\n")
+		}
+		fmt.Fprintf(&part, "--%s\r\n", boundary)
+		fmt.Fprintf(&part, "Content-Type: %s\r\n", contentType)
+		fmt.Fprintf(&part, "Content-Range: %s\r\n\r\n", r.contentRange(size))
+		parts = append(parts, strings.NewReader(part.String()), io.NewSectionReader(c, r.start, r.length))
+		length += int64(part.Len()) + r.length
+	}
+	closing := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	parts = append(parts, strings.NewReader(closing))
+	length += int64(len(closing))
+
+	h.Override("Content-Length", strconv.FormatInt(length, 10))
+	h.Override("Content-Type", "multipart/byteranges; boundary="+boundary)
+	w.WriteStatusLine(response.StatusCodePartialContent)
+	w.WriteHeaders(h)
+	_, err := w.WriteBodyFrom(io.MultiReader(parts...), length)
+	return err
+}
+
+func writeUnsatisfiable(w *response.Writer, size int64) {
+	body := []byte("416 Range Not Satisfiable\n")
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	w.WriteStatusLine(response.StatusCodeRangeNotSatisfiable)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func newBoundary() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,136 @@
+package fileserver
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/response"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	// Test: Single, open-ended and suffix ranges resolve against the size
+	ranges, err := parseRange("bytes=0-4", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 5}}, ranges)
+	ranges, err = parseRange("bytes=6-", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{6, 4}}, ranges)
+	ranges, err = parseRange("bytes=-3", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{7, 3}}, ranges)
+
+	// Test: Ends past the size are clamped, oversized suffixes cover everything
+	ranges, err = parseRange("bytes=5-100, -50", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{5, 5}, {0, 10}}, ranges)
+
+	// Test: Ranges starting past the end are dropped, none left is a 416
+	ranges, err = parseRange("bytes=2-3, 20-30", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{2, 2}}, ranges)
+	_, err = parseRange("bytes=10-", 10)
+	assert.ErrorIs(t, err, errUnsatisfiable)
+	_, err = parseRange("bytes=-0", 10)
+	assert.ErrorIs(t, err, errUnsatisfiable)
+	_, err = parseRange("bytes=0-", 0)
+	assert.ErrorIs(t, err, errUnsatisfiable)
+
+	// Test: Malformed ranges are invalid
+	for _, s := range []string{"bytes=", "bytes=5-2", "bytes=a-b", "bytes=1", "bytes=-", "bytes=+1-2", "0-1"} {
+		_, err = parseRange(s, 10)
+		assert.ErrorIs(t, err, errInvalidRange, s)
+	}
+
+	// Test: Other units are ignored
+	ranges, err = parseRange("items=0-1", 10)
+	require.NoError(t, err)
+	assert.Nil(t, ranges)
+}
+
+func TestFileServerRanges(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := New(fstest.MapFS{
+		"digits.txt": {Data: []byte("0123456789"), ModTime: modTime},
+	})
+
+	// Test: Full responses advertise range support
+	res, body := serve(t, s.Handle, get("/digits.txt"))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	acceptRanges, _ := res.Headers.Get("Accept-Ranges")
+	assert.Equal(t, "bytes", acceptRanges)
+	lastModified, _ := res.Headers.Get("Last-Modified")
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", lastModified)
+	assert.Equal(t, "0123456789", body)
+
+	// Test: A single range is a 206 with Content-Range
+	res, body = serve(t, s.Handle, get("/digits.txt", "Range: bytes=2-5"))
+	assert.Equal(t, response.StatusCodePartialContent, res.StatusCode)
+	contentRange, _ := res.Headers.Get("Content-Range")
+	assert.Equal(t, "bytes 2-5/10", contentRange)
+	contentLength, _ := res.Headers.Get("Content-Length")
+	assert.Equal(t, "4", contentLength)
+	assert.Equal(t, "2345", body)
+
+	// Test: Suffix ranges serve the end of the file
+	res, body = serve(t, s.Handle, get("/digits.txt", "Range: bytes=-3"))
+	assert.Equal(t, response.StatusCodePartialContent, res.StatusCode)
+	assert.Equal(t, "789", body)
+
+	// Test: Several ranges are sent as multipart/byteranges
+	res, body = serve(t, s.Handle, get("/digits.txt", "Range: bytes=0-1, 8-"))
+	assert.Equal(t, response.StatusCodePartialContent, res.StatusCode)
+	contentType, _ := res.Headers.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	for _, want := range []struct{ contentRange, data string }{
+		{"bytes 0-1/10", "01"},
+		{"bytes 8-9/10", "89"},
+	} {
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, want.contentRange, part.Header.Get("Content-Range"))
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, want.data, string(data))
+	}
+	_, err = mr.NextPart()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Unsatisfiable ranges are a 416 with the size
+	res, _ = serve(t, s.Handle, get("/digits.txt", "Range: bytes=10-20"))
+	assert.Equal(t, response.StatusCodeRangeNotSatisfiable, res.StatusCode)
+	contentRange, _ = res.Headers.Get("Content-Range")
+	assert.Equal(t, "bytes */10", contentRange)
+
+	// Test: Malformed ranges and ranges larger than the file get the whole file
+	for _, r := range []string{"Range: bytes=5-1", "Range: bytes=0-9, 0-9"} {
+		res, body = serve(t, s.Handle, get("/digits.txt", r))
+		assert.Equal(t, response.StatusCodeSuccess, res.StatusCode, r)
+		assert.Equal(t, "0123456789", body, r)
+	}
+
+	// Test: If-Range honours the range only for an unchanged file
+	res, body = serve(t, s.Handle, get("/digits.txt", "Range: bytes=0-0", "If-Range: "+headers.FormatTime(modTime)))
+	assert.Equal(t, response.StatusCodePartialContent, res.StatusCode)
+	assert.Equal(t, "0", body)
+	res, body = serve(t, s.Handle, get("/digits.txt", "Range: bytes=0-0", "If-Range: "+headers.FormatTime(modTime.Add(-time.Hour))))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	assert.Equal(t, "0123456789", body)
+	res, _ = serve(t, s.Handle, get("/digits.txt", "Range: bytes=0-0", `If-Range: "abc"`))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+
+	// Test: Range is ignored for HEAD
+	res, _ = serve(t, s.Handle, "HEAD /digits.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-1\r\n\r\n")
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+}
@@ -0,0 +1,327 @@
+package form
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"os"
+)
+
+var (
+	ErrUnsupportedType = errors.New("form: unsupported content type")
+	ErrTooLarge        = errors.New("form: body too large")
+	ErrPartTooLarge    = errors.New("form: part too large")
+	ErrTooManyParts    = errors.New("form: too many parts")
+)
+
+// Limits bounds the forms Parse accepts. Zero fields take their value
+// from DefaultLimits.
+type Limits struct {
+	// MaxMemory is how many bytes of uploaded files are kept in memory.
+	// Files that do not fit are written to temporary files, which only
+	// saves memory when the body is streamed through the request's
+	// BodyReader (see server.WithStreamedBodies).
+	MaxMemory int64
+	// MaxPartSize bounds each field value and each file.
+	MaxPartSize int64
+	// MaxTotalSize bounds the whole encoded body.
+	MaxTotalSize int64
+	// MaxParts bounds the number of multipart parts.
+	MaxParts int
+}
+
+var DefaultLimits = Limits{
+	MaxMemory:    1 << 20,
+	MaxPartSize:  32 << 20,
+	MaxTotalSize: 64 << 20,
+	MaxParts:     1000,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxMemory == 0 {
+		l.MaxMemory = DefaultLimits.MaxMemory
+	}
+	if l.MaxPartSize == 0 {
+		l.MaxPartSize = DefaultLimits.MaxPartSize
+	}
+	if l.MaxTotalSize == 0 {
+		l.MaxTotalSize = DefaultLimits.MaxTotalSize
+	}
+	if l.MaxParts == 0 {
+		l.MaxParts = DefaultLimits.MaxParts
+	}
+	return l
+}
+
+// Form is a parsed form body. Query parameters of the request target are
+// not included.
+type Form struct {
+	Values url.Values
+	Files  map[string][]*File
+}
+
+// Value returns the first value of the field name, or "".
+func (f *Form) Value(name string) string {
+	return f.Values.Get(name)
+}
+
+// File returns the first file uploaded as name, or nil.
+func (f *Form) File(name string) *File {
+	if files := f.Files[name]; len(files) > 0 {
+		return files[0]
+	}
+	return nil
+}
+
+// RemoveAll deletes the temporary files of the form's uploads. Handlers
+// that parse multipart forms should defer it.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if file.tmpFile == "" {
+				continue
+			}
+			if err := os.Remove(file.tmpFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// File is an uploaded file part.
+type File struct {
+	// Name is the name of the form field.
+	Name     string
+	Filename string
+	Headers  headers.Headers
+	Size     int64
+
+	content []byte
+	tmpFile string
+}
+
+// Open returns the file's content.
+func (f *File) Open() (io.ReadSeekCloser, error) {
+	if f.tmpFile != "" {
+		return os.Open(f.tmpFile)
+	}
+	return nopCloser{bytes.NewReader(f.content)}, nil
+}
+
+// OnDisk reports whether the upload was too large to keep in memory.
+func (f *File) OnDisk() bool {
+	return f.tmpFile != ""
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// Parse parses an application/x-www-form-urlencoded or multipart/form-data
+// request body. Any other content type is ErrUnsupportedType.
+func Parse(req *request.Request, limits Limits) (*Form, error) {
+	contentType, _ := req.Headers.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedType, contentType)
+	}
+
+	limits = limits.withDefaults()
+	var body io.Reader = bytes.NewReader(req.Body)
+	if req.BodyReader != nil {
+		body = req.BodyReader
+	}
+	lr := &limitedReader{r: body, n: limits.MaxTotalSize}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return parseURLEncoded(lr)
+	case "multipart/form-data":
+		if params["boundary"] == "" {
+			return nil, errors.New("form: multipart body without a boundary")
+		}
+		return parseMultipart(lr, params["boundary"], limits)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, mediaType)
+}
+
+// StatusCode is the status to answer a request whose form failed to parse
+// with err.
+func StatusCode(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, ErrUnsupportedType):
+		return response.StatusCodeUnsupportedMediaType
+	case errors.Is(err, ErrTooLarge), errors.Is(err, ErrPartTooLarge), errors.Is(err, ErrTooManyParts):
+		return response.StatusCodeContentTooLarge
+	}
+	return response.StatusCodeBadRequest
+}
+
+func parseURLEncoded(r io.Reader) (*Form, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("form: %w", err)
+	}
+	return &Form{Values: values, Files: map[string][]*File{}}, nil
+}
+
+func parseMultipart(lr *limitedReader, boundary string, limits Limits) (_ *Form, err error) {
+	f := &Form{Values: url.Values{}, Files: map[string][]*File{}}
+	defer func() {
+		if err != nil {
+			f.RemoveAll()
+		}
+	}()
+
+	mr := multipart.NewReader(lr, boundary)
+	memory := limits.MaxMemory
+	for parts := 0; ; parts++ {
+		p, err := mr.NextPart()
+		// only a bare io.EOF marks the final boundary, a body cut short
+		// wraps it
+		if err == io.EOF {
+			return f, nil
+		}
+		if err != nil {
+			return nil, lr.wrap(err)
+		}
+		if parts >= limits.MaxParts {
+			return nil, ErrTooManyParts
+		}
+
+		name := p.FormName()
+		if name == "" {
+			continue
+		}
+		filename := p.FileName()
+		if filename == "" {
+			value, err := readPart(p, limits.MaxPartSize)
+			if err != nil {
+				return nil, lr.wrap(err)
+			}
+			f.Values.Add(name, string(value))
+			continue
+		}
+
+		file, err := readFile(p, min(memory, limits.MaxPartSize), limits.MaxPartSize)
+		if file != nil && file.tmpFile != "" {
+			// registered before checking err so a failed upload is removed
+			f.Files[name] = append(f.Files[name], file)
+		}
+		if err != nil {
+			return nil, lr.wrap(err)
+		}
+		if file.tmpFile == "" {
+			memory -= file.Size
+			f.Files[name] = append(f.Files[name], file)
+		}
+	}
+}
+
+// readPart reads a part of at most max bytes.
+func readPart(r io.Reader, max int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, ErrPartTooLarge
+	}
+	return data, nil
+}
+
+// readFile keeps a file part of up to memory bytes in memory and streams a
+// larger one to a temporary file.
+func readFile(p *multipart.Part, memory, max int64) (*File, error) {
+	file := &File{
+		Name:     p.FormName(),
+		Filename: p.FileName(),
+		Headers:  headers.NewHeaders(),
+	}
+	for key, values := range p.Header {
+		for _, v := range values {
+			file.Headers.Set(key, v)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(p, memory+1))
+	if err != nil {
+		return nil, err
+	}
+	if n <= memory {
+		file.content = buf.Bytes()
+		file.Size = n
+		return file, nil
+	}
+
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+	file.tmpFile = tmp.Name()
+
+	// reading one byte past the limit tells a file that is exactly max
+	// apart from one that is larger
+	n, err = io.Copy(tmp, io.MultiReader(&buf, io.LimitReader(p, max+1-n)))
+	file.Size = n
+	if err != nil {
+		return file, err
+	}
+	if n > max {
+		return file, ErrPartTooLarge
+	}
+	return file, tmp.Close()
+}
+
+// limitedReader fails with ErrTooLarge once more than n bytes are read.
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		l.exceeded = true
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		l.exceeded = true
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// wrap reports ErrTooLarge for errors caused by the body limit, which the
+// multipart reader does not always pass through unchanged.
+func (l *limitedReader) wrap(err error) error {
+	if l.exceeded {
+		return ErrTooLarge
+	}
+	if errors.Is(err, ErrPartTooLarge) || errors.Is(err, ErrTooManyParts) {
+		return err
+	}
+	return fmt.Errorf("form: %w", err)
+}
@@ -0,0 +1,174 @@
+package form
+
+import (
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(t *testing.T, contentType, body string) *request.Request {
+	req, err := request.NewBuilder("POST", "/upload").
+		Header("Content-Type", contentType).
+		Body([]byte(body)).
+		Build()
+	require.NoError(t, err)
+	return req
+}
+
+// multipartBody encodes fields and, for keys starting with "@", files.
+func multipartBody(t *testing.T, parts ...[2]string) (string, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		var w io.Writer
+		var err error
+		if name, ok := strings.CutPrefix(p[0], "@"); ok {
+			w, err = mw.CreateFormFile(name, name+".txt")
+		} else {
+			w, err = mw.CreateFormField(p[0])
+		}
+		require.NoError(t, err)
+		_, err = io.WriteString(w, p[1])
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+	return mw.FormDataContentType(), buf.String()
+}
+
+func contents(t *testing.T, f *File) string {
+	r, err := f.Open()
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseURLEncoded(t *testing.T) {
+	// Test: Fields are decoded, repeated names keep every value
+	f, err := Parse(newRequest(t, "application/x-www-form-urlencoded", "name=Ada+Lovelace&tag=a&tag=b%26c"), Limits{})
+	require.NoError(t, err)
+	assert.Equal(t, "Ada Lovelace", f.Value("name"))
+	assert.Equal(t, []string{"a", "b&c"}, f.Values["tag"])
+	assert.Empty(t, f.Value("missing"))
+
+	// Test: Parameters on the content type are allowed
+	f, err = Parse(newRequest(t, "application/x-www-form-urlencoded; charset=utf-8", "a=1"), Limits{})
+	require.NoError(t, err)
+	assert.Equal(t, "1", f.Value("a"))
+
+	// Test: Malformed escapes are a 400
+	_, err = Parse(newRequest(t, "application/x-www-form-urlencoded", "a=%zz"), Limits{})
+	assert.Error(t, err)
+	assert.Equal(t, response.StatusCodeBadRequest, StatusCode(err))
+
+	// Test: Bodies over the total limit are a 413
+	_, err = Parse(newRequest(t, "application/x-www-form-urlencoded", "a="+strings.Repeat("x", 100)), Limits{MaxTotalSize: 50})
+	assert.ErrorIs(t, err, ErrTooLarge)
+	assert.Equal(t, response.StatusCodeContentTooLarge, StatusCode(err))
+
+	// Test: Other content types are a 415
+	_, err = Parse(newRequest(t, "application/json", "{}"), Limits{})
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+	assert.Equal(t, response.StatusCodeUnsupportedMediaType, StatusCode(err))
+}
+
+func TestParseMultipart(t *testing.T) {
+	big := strings.Repeat("0123456789", 100)
+	contentType, body := multipartBody(t,
+		[2]string{"title", "holiday"},
+		[2]string{"@small", "tiny file"},
+		[2]string{"@big", big},
+		[2]string{"title", "second"},
+	)
+
+	// Test: Fields and files are exposed, large files spill to disk
+	f, err := Parse(newRequest(t, contentType, body), Limits{MaxMemory: 100})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"holiday", "second"}, f.Values["title"])
+
+	small := f.File("small")
+	require.NotNil(t, small)
+	assert.Equal(t, "small", small.Name)
+	assert.Equal(t, "small.txt", small.Filename)
+	assert.Equal(t, int64(9), small.Size)
+	assert.False(t, small.OnDisk())
+	contentType, _ = small.Headers.Get("Content-Type")
+	assert.Equal(t, "application/octet-stream", contentType)
+	assert.Equal(t, "tiny file", contents(t, small))
+
+	large := f.File("big")
+	require.NotNil(t, large)
+	assert.Equal(t, int64(len(big)), large.Size)
+	assert.True(t, large.OnDisk())
+	assert.Equal(t, big, contents(t, large))
+
+	// Test: RemoveAll deletes the temporary files
+	require.NoError(t, f.RemoveAll())
+	_, err = os.Stat(large.tmpFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Nil(t, f.File("missing"))
+
+	// Test: A streamed body is parsed as it is read
+	contentType, body = multipartBody(t, [2]string{"@big", big})
+	req, err := request.NewBuilder("POST", "/upload").
+		Header("Content-Type", contentType).
+		BodyReader(iotest.OneByteReader(strings.NewReader(body))).
+		Build()
+	require.NoError(t, err)
+	f, err = Parse(req, Limits{MaxMemory: 100})
+	require.NoError(t, err)
+	assert.True(t, f.File("big").OnDisk())
+	assert.Equal(t, big, contents(t, f.File("big")))
+	f.RemoveAll()
+
+	// Test: Parts over the per-part limit are a 413, and leave no files behind
+	before, _ := os.ReadDir(os.TempDir())
+	contentType, body = multipartBody(t, [2]string{"@big", big})
+	_, err = Parse(newRequest(t, contentType, body), Limits{MaxMemory: 10, MaxPartSize: 500})
+	assert.ErrorIs(t, err, ErrPartTooLarge)
+	after, _ := os.ReadDir(os.TempDir())
+	assert.Equal(t, len(before), len(after))
+	contentType, body = multipartBody(t, [2]string{"note", big})
+	_, err = Parse(newRequest(t, contentType, body), Limits{MaxPartSize: 500})
+	assert.ErrorIs(t, err, ErrPartTooLarge)
+
+	// Test: A file of exactly the part limit is accepted
+	contentType, body = multipartBody(t, [2]string{"@big", big})
+	f, err = Parse(newRequest(t, contentType, body), Limits{MaxMemory: 10, MaxPartSize: int64(len(big))})
+	require.NoError(t, err)
+	assert.Equal(t, big, contents(t, f.File("big")))
+	f.RemoveAll()
+
+	// Test: The total limit covers every part together
+	contentType, body = multipartBody(t, [2]string{"a", big}, [2]string{"b", big})
+	_, err = Parse(newRequest(t, contentType, body), Limits{MaxTotalSize: 1500})
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	// Test: Too many parts
+	var parts [][2]string
+	for i := range 5 {
+		parts = append(parts, [2]string{fmt.Sprint("f", i), "x"})
+	}
+	contentType, body = multipartBody(t, parts...)
+	_, err = Parse(newRequest(t, contentType, body), Limits{MaxParts: 4})
+	assert.ErrorIs(t, err, ErrTooManyParts)
+	assert.Equal(t, response.StatusCodeContentTooLarge, StatusCode(err))
+
+	// Test: Missing boundaries and truncated bodies are a 400
+	_, err = Parse(newRequest(t, "multipart/form-data", body), Limits{})
+	assert.Equal(t, response.StatusCodeBadRequest, StatusCode(err))
+	_, err = Parse(newRequest(t, contentType, body[:len(body)-10]), Limits{})
+	assert.Error(t, err)
+	assert.Equal(t, response.StatusCodeBadRequest, StatusCode(err))
+}
@@ -0,0 +1,101 @@
+package headers
+
+import (
+	"strings"
+)
+
+// MediaRange is one element of an Accept header, such as "text/*;q=0.5".
+type MediaRange struct {
+	Type    string
+	Subtype string
+	Params  map[string]string
+	Q       float64
+}
+
+// ParseAccept parses an Accept header value (RFC 9110 section 12.5.1).
+// Elements that are not a type/subtype pair are skipped, except for a
+// bare "*", which some clients send for "*/*".
+func ParseAccept(v string) []MediaRange {
+	var ranges []MediaRange
+	for _, qv := range ParseQualityList(v) {
+		if qv.Value == "*" {
+			qv.Value = "*/*"
+		}
+		typ, subtype, ok := strings.Cut(qv.Value, "/")
+		typ, subtype = strings.TrimSpace(typ), strings.TrimSpace(subtype)
+		if !ok || typ == "" || subtype == "" || (typ == "*" && subtype != "*") {
+			continue
+		}
+		ranges = append(ranges, MediaRange{
+			Type:    typ,
+			Subtype: subtype,
+			Params:  qv.Params,
+			Q:       qv.Q,
+		})
+	}
+	return ranges
+}
+
+// specificity ranks how closely the range matches mediaType, or returns -1
+// when it does not match at all. "type/subtype;param" beats
+// "type/subtype", which beats "type/*", which beats "*/*".
+func (m MediaRange) specificity(mediaType string, params map[string]string) int {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	switch {
+	case m.Type == "*":
+		return 0
+	case m.Type != typ:
+		return -1
+	case m.Subtype == "*":
+		return 1
+	case m.Subtype != subtype:
+		return -1
+	}
+	for k, v := range m.Params {
+		if params[k] != v {
+			return -1
+		}
+	}
+	return 2 + len(m.Params)
+}
+
+// NegotiateMediaType picks the offer the Accept header in h rates highest.
+// Each offer gets the weight of the most specific range that matches it,
+// and ties go to the earlier offer. Without an Accept header the first
+// offer is picked; when nothing is acceptable it returns "".
+func NegotiateMediaType(h Headers, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	v, ok := h.Get("Accept")
+	if !ok {
+		return offers[0]
+	}
+	ranges := ParseAccept(v)
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		mediaType, params := splitMediaType(offer)
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			if s := r.specificity(mediaType, params); s > specificity {
+				q, specificity = r.Q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+func splitMediaType(v string) (string, map[string]string) {
+	parts := strings.Split(v, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+	params := map[string]string{}
+	for _, param := range parts[1:] {
+		name, val, _ := strings.Cut(param, "=")
+		params[strings.ToLower(strings.TrimSpace(name))] = strings.ToLower(strings.Trim(strings.TrimSpace(val), `"`))
+	}
+	return mediaType, params
+}
@@ -29,6 +29,9 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	}
 
 	parts := bytes.SplitN(data[:idx], []byte(":"), 2)
+	if len(parts) != 2 {
+		return 0, false, fmt.Errorf("malformed header line: %q", data[:idx])
+	}
 	key := strings.ToLower(string(parts[0]))
 
 	if key != strings.TrimRight(key, " ") {
@@ -72,6 +75,19 @@ func (h Headers) Remove(key string) {
 	delete(h, key)
 }
 
+func (h Headers) Clone() Headers {
+	c := NewHeaders()
+	for k, v := range h {
+		c[k] = v
+	}
+	return c
+}
+
+// ValidName reports whether name is a valid header field name
+func ValidName(name string) bool {
+	return name != "" && validTokens([]byte(name))
+}
+
 var tokenChars = []byte{'!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~'}
 
 // validTokens checks if the data contains only valid tokens
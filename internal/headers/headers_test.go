@@ -75,3 +75,79 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 }
+
+func TestParseQualityList(t *testing.T) {
+	// Test: Weights default to 1 and values are lowercased
+	list := ParseQualityList("GZIP, deflate;q=0.5, br ; q=0")
+	assert.Equal(t, []QualityValue{
+		{Value: "gzip", Q: 1},
+		{Value: "deflate", Q: 0.5},
+		{Value: "br", Q: 0},
+	}, list)
+
+	// Test: Parameters before the weight are kept, extensions after it are not
+	list = ParseQualityList(`text/html;level=1;q=0.7;ext=x, */*;q=0.1`)
+	require.Len(t, list, 2)
+	assert.Equal(t, map[string]string{"level": "1"}, list[0].Params)
+	assert.Equal(t, 0.7, list[0].Q)
+	assert.Equal(t, 0.1, list[1].Q)
+
+	// Test: Invalid weights count as 0 and empty elements are skipped
+	list = ParseQualityList("a;q=2, , b;q=x, c;q=0.0001")
+	require.Len(t, list, 3)
+	assert.Equal(t, 0.0, list[0].Q)
+	assert.Equal(t, 0.0, list[1].Q)
+	assert.Equal(t, 0.0, list[2].Q)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	negotiate := func(acceptEncoding string) string {
+		h := NewHeaders()
+		h.Set("Accept-Encoding", acceptEncoding)
+		return NegotiateEncoding(h, "gzip", "deflate")
+	}
+
+	// Test: No Accept-Encoding means no encoding
+	assert.Equal(t, "", NegotiateEncoding(NewHeaders(), "gzip"))
+
+	// Test: The highest weight wins, ties go to the server's preference
+	assert.Equal(t, "gzip", negotiate("deflate, gzip"))
+	assert.Equal(t, "deflate", negotiate("gzip;q=0.5, deflate"))
+	assert.Equal(t, "gzip", negotiate("x-gzip"))
+
+	// Test: The wildcard covers unlisted codings, q=0 refuses them
+	assert.Equal(t, "deflate", negotiate("gzip;q=0, *"))
+	assert.Equal(t, "", negotiate("*;q=0"))
+	assert.Equal(t, "", negotiate("br, identity"))
+	assert.Equal(t, "", negotiate(""))
+}
+
+func TestNegotiateMediaType(t *testing.T) {
+	negotiate := func(accept string, offers ...string) string {
+		h := NewHeaders()
+		h.Set("Accept", accept)
+		return NegotiateMediaType(h, offers...)
+	}
+
+	// Test: Ranges are parsed with their parameters and weights
+	ranges := ParseAccept("text/html;level=1, text/*;q=0.3, *;q=0.1, bogus, */html")
+	require.Len(t, ranges, 3)
+	assert.Equal(t, MediaRange{Type: "text", Subtype: "html", Params: map[string]string{"level": "1"}, Q: 1}, ranges[0])
+	assert.Equal(t, MediaRange{Type: "*", Subtype: "*", Q: 0.1}, ranges[2])
+
+	// Test: Without Accept the first offer wins
+	assert.Equal(t, "text/html", NegotiateMediaType(NewHeaders(), "text/html", "application/json"))
+
+	// Test: The highest weight wins, ties go to the earlier offer
+	assert.Equal(t, "application/json", negotiate("text/html;q=0.5, application/json", "text/html", "application/json"))
+	assert.Equal(t, "text/html", negotiate("*/*", "text/html", "application/json"))
+
+	// Test: The most specific matching range sets an offer's weight
+	assert.Equal(t, "text/plain", negotiate("text/*;q=0.9, text/html;q=0.1", "text/html", "text/plain"))
+	assert.Equal(t, "application/json", negotiate("*/*;q=0.5, application/json, text/html;q=0", "text/html", "application/json"))
+	assert.Equal(t, "", negotiate("text/html;q=0", "text/html"))
+	assert.Equal(t, "", negotiate("image/png", "text/html", "application/json"))
+
+	// Test: Range parameters must match the offer's
+	assert.Equal(t, "text/html;level=1", negotiate("text/html;level=1, text/html;q=0.2", "text/html", "text/html;level=1"))
+}
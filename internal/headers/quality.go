@@ -0,0 +1,90 @@
+package headers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// QualityValue is one element of a weighted list such as Accept or
+// Accept-Encoding.
+type QualityValue struct {
+	Value string
+	// Params holds the parameters before the weight, lowercased.
+	Params map[string]string
+	Q      float64
+}
+
+// ParseQualityList parses a comma-separated list of values with optional
+// ";q=" weights (RFC 9110 section 12.4.2). Values are lowercased. Values
+// without a weight get 1; an invalid weight counts as 0.
+func ParseQualityList(v string) []QualityValue {
+	var list []QualityValue
+	for _, element := range strings.Split(v, ",") {
+		parts := strings.Split(element, ";")
+		value := strings.ToLower(strings.TrimSpace(parts[0]))
+		if value == "" {
+			continue
+		}
+		qv := QualityValue{Value: value, Q: 1}
+		for _, param := range parts[1:] {
+			name, val, _ := strings.Cut(param, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			val = strings.Trim(strings.TrimSpace(val), `"`)
+			if name == "q" {
+				qv.Q = parseQuality(val)
+				// anything after the weight is an extension, not a parameter
+				break
+			}
+			if name == "" {
+				continue
+			}
+			if qv.Params == nil {
+				qv.Params = map[string]string{}
+			}
+			qv.Params[name] = strings.ToLower(val)
+		}
+		list = append(list, qv)
+	}
+	return list
+}
+
+func parseQuality(s string) float64 {
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 || len(s) > 5 {
+		return 0
+	}
+	return q
+}
+
+// NegotiateEncoding picks the content coding from supported, listed in
+// order of preference, that the Accept-Encoding header in h rates highest.
+// It returns "" when the client sent no Accept-Encoding or none of the
+// supported codings is acceptable, meaning the body is sent as is.
+func NegotiateEncoding(h Headers, supported ...string) string {
+	v, ok := h.Get("Accept-Encoding")
+	if !ok {
+		return ""
+	}
+
+	accepted := map[string]float64{}
+	for _, qv := range ParseQualityList(v) {
+		coding := qv.Value
+		// x-gzip is an alias from before gzip was registered
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		accepted[coding] = qv.Q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range supported {
+		q, ok := accepted[coding]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
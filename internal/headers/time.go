@@ -0,0 +1,34 @@
+package headers
+
+import (
+	"time"
+)
+
+// TimeFormat is the IMF-fixdate format used for HTTP dates such as Date,
+// Expires and Last-Modified.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsolete date formats that recipients must still accept (RFC 9110 section 5.6.7)
+var obsoleteTimeFormats = []string{
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// ParseTime parses an HTTP date in any of the formats allowed by RFC 9110.
+func ParseTime(value string) (time.Time, error) {
+	t, err := time.Parse(TimeFormat, value)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range obsoleteTimeFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// FormatTime formats t as an HTTP date.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
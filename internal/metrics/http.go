@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"strconv"
+	"time"
+)
+
+// sizeBuckets are bucket bounds for body sizes in bytes, from 100B to 10MB.
+var sizeBuckets = ExponentialBuckets(100, 10, 6)
+
+// HTTP are the metrics of an HTTP server: what the Middleware sees of
+// requests, what the server reports as its server.Observer, and upstream
+// exchanges reported through ObserveUpstream.
+type HTTP struct {
+	requests         *Counter
+	duration         *Histogram
+	requestSize      *Histogram
+	responseSize     *Histogram
+	activeConns      *Gauge
+	parseErrors      *Counter
+	upstreamDuration *Histogram
+}
+
+// NewHTTP registers the HTTP server metrics in r.
+func NewHTTP(r *Registry) *HTTP {
+	return &HTTP{
+		requests: r.Counter("http_requests_total",
+			"Requests handled, by method, route and status.", "method", "route", "status"),
+		duration: r.Histogram("http_request_duration_seconds",
+			"Time taken to handle requests.", DefaultBuckets, "method", "route"),
+		requestSize: r.Histogram("http_request_size_bytes",
+			"Size of request bodies.", sizeBuckets, "method", "route"),
+		responseSize: r.Histogram("http_response_size_bytes",
+			"Size of response bodies as sent, after compression.", sizeBuckets, "method", "route"),
+		activeConns: r.Gauge("http_active_connections",
+			"Connections being served."),
+		parseErrors: r.Counter("http_parse_errors_total",
+			"Requests that could not be read, by what went wrong.", "type"),
+		upstreamDuration: r.Histogram("http_upstream_duration_seconds",
+			"Time taken by proxied upstreams to send response headers, by upstream and status, 0 when there was no response.",
+			DefaultBuckets, "upstream", "status"),
+	}
+}
+
+// Middleware records every request under the route that route names it,
+// which has to be one of a few fixed names, never the raw path, or every
+// distinct URL would make a new series. It should come before middleware
+// that change the response, such as compression, so that the sizes are
+// those sent.
+func (m *HTTP) Middleware(route func(req *request.Request) string) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+
+			method := methodLabel(req.RequestLine.Method)
+			r := route(req)
+			status := strconv.Itoa(int(w.StatusCode()))
+			m.requests.Inc(method, r, status)
+			m.duration.Observe(time.Since(start).Seconds(), method, r)
+			size := int64(len(req.Body))
+			if req.BodyReader != nil {
+				// a streamed body is not held, so go by what was announced
+				size, _ = req.ContentLength()
+			}
+			m.requestSize.Observe(float64(size), method, r)
+			m.responseSize.Observe(float64(w.BodyBytes()), method, r)
+		}
+	}
+}
+
+// methodLabel bounds the method label to the methods of RFC 9110, since
+// any token parses as a method.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "PATCH", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+	return "other"
+}
+
+func (m *HTTP) ConnOpened() { m.activeConns.Inc() }
+
+func (m *HTTP) ConnClosed() { m.activeConns.Dec() }
+
+func (m *HTTP) ParseError(kind string) { m.parseErrors.Inc(kind) }
+
+// ObserveUpstream can be set as a proxy.ReverseProxy's ObserveUpstream.
+func (m *HTTP) ObserveUpstream(upstream string, status int, d time.Duration) {
+	m.upstreamDuration.Observe(d.Seconds(), upstream, strconv.Itoa(status))
+}
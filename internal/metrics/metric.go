@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets for latencies in seconds, from 5ms
+// to 10s.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns n buckets, the first of which is start and
+// each following one factor times the last.
+func ExponentialBuckets(start, factor float64, n int) []float64 {
+	buckets := make([]float64, n)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// metric is one named family of series, each told apart by label values.
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	// buckets are the upper bounds of a histogram's buckets
+	buckets []float64
+
+	mu sync.Mutex
+	// series are keyed by their label values, joined with labelSep
+	series map[string]*series
+}
+
+// labelSep cannot appear in label values worth keeping, so it separates
+// them in series keys.
+const labelSep = "\xff"
+
+type series struct {
+	labelValues []string
+	// value is the count or gauge, or the sum of a histogram
+	value float64
+	// counts of a histogram, one per bucket then +Inf, not cumulative
+	counts []uint64
+}
+
+func newMetric(name, help, kind string, labels []string, buckets []float64) *metric {
+	return &metric{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*series{}}
+}
+
+// get returns the series of labelValues, creating it. It must be called
+// with m.mu held.
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelSep)
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct {
+	m *metric
+}
+
+// Inc adds one to the series of labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the series of labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.get(labelValues).value += v
+}
+
+// Gauge is a value that goes up and down, such as open connections.
+type Gauge struct {
+	m *metric
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(labelValues).value = v
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(labelValues).value += v
+}
+
+func (g *Gauge) Inc(labelValues ...string) { g.Add(1, labelValues...) }
+
+func (g *Gauge) Dec(labelValues ...string) { g.Add(-1, labelValues...) }
+
+// Histogram counts observations, such as latencies, into buckets.
+type Histogram struct {
+	m *metric
+}
+
+// Observe records v in the series of labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	i, _ := slices.BinarySearch(h.m.buckets, v)
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.m.buckets)+1)
+	}
+	s.counts[i]++
+	s.value += v
+}
+
+// write writes m in the Prometheus text exposition format, its series
+// sorted by label values.
+func (m *metric) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", m.name, m.kind)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range slices.Sorted(maps.Keys(m.series)) {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			writeSample(b, m.name, m.labels, s.labelValues, "", "", s.value)
+			continue
+		}
+		var total uint64
+		for i, n := range s.counts {
+			total += n
+			le := "+Inf"
+			if i < len(m.buckets) {
+				le = formatFloat(m.buckets[i])
+			}
+			writeSample(b, m.name+"_bucket", m.labels, s.labelValues, "le", le, float64(total))
+		}
+		writeSample(b, m.name+"_sum", m.labels, s.labelValues, "", "", s.value)
+		writeSample(b, m.name+"_count", m.labels, s.labelValues, "", "", float64(total))
+	}
+}
+
+// writeSample writes one line, such as `name{a="1",le="0.5"} 3`. extra is
+// a label added after the others when not empty.
+func writeSample(b *strings.Builder, name string, labels, values []string, extra, extraValue string, v float64) {
+	b.WriteString(name)
+	if len(labels) > 0 || extra != "" {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extra != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", extra, extraValue)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(v))
+	b.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests.\nAll of them.", "method", "path")
+	conns := r.Gauge("conns", "Open connections.")
+	latency := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1}, "method")
+
+	// Test: Metrics are written in registration order, series sorted
+	requests.Inc("POST", "/")
+	requests.Add(2, "GET", `/a"b\`)
+	conns.Inc()
+	conns.Inc()
+	conns.Dec()
+	latency.Observe(0.05, "GET")
+	latency.Observe(0.1, "GET")
+	latency.Observe(3, "GET")
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, `# HELP requests_total Requests.\nAll of them.
+# TYPE requests_total counter
+requests_total{method="GET",path="/a\"b\\"} 2
+requests_total{method="POST",path="/"} 1
+# HELP conns Open connections.
+# TYPE conns gauge
+conns 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="GET",le="0.1"} 2
+latency_seconds_bucket{method="GET",le="1"} 2
+latency_seconds_bucket{method="GET",le="+Inf"} 3
+latency_seconds_sum{method="GET"} 3.15
+latency_seconds_count{method="GET"} 3
+`, b.String())
+
+	// Test: Mistakes in using metrics panic
+	assert.Panics(t, func() { r.Counter("conns", "Again.") })
+	assert.Panics(t, func() { requests.Inc("GET") })
+	assert.Panics(t, func() { requests.Add(-1, "GET", "/") })
+	assert.Panics(t, func() { r.Histogram("unsorted", "Unsorted.", []float64{1, 0.1}) })
+
+	// Test: The handler serves the exposition format
+	rec := response.NewRecorder()
+	req, err := request.RequestFromReader(strings.NewReader("GET /metrics HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	r.Handle(rec.Writer, req)
+	assert.Equal(t, response.StatusCodeSuccess, rec.StatusCode)
+	assert.Equal(t, ContentType, rec.Headers["content-type"])
+	assert.Equal(t, b.String(), rec.Body.String())
+}
+
+func TestHTTP(t *testing.T) {
+	r := NewRegistry()
+	m := NewHTTP(r)
+	handler := m.Middleware(func(req *request.Request) string {
+		if strings.HasPrefix(req.RequestLine.Path(), "/items/") {
+			return "/items/"
+		}
+		return "other"
+	})(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(5))
+		w.WriteBody([]byte("hello"))
+	})
+	for _, raw := range []string{
+		"GET /items/1 HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"GET /items/2 HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"POST /other HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc",
+		"BREW /items/3 HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"PROPFIND /items/4 HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	} {
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		handler(response.NewRecorder().Writer, req)
+	}
+	m.ConnOpened()
+	m.ConnOpened()
+	m.ConnClosed()
+	m.ParseError(request.ParseErrorHeaders)
+	m.ObserveUpstream("origin:80", 200, 30*time.Millisecond)
+	m.ObserveUpstream("origin:80", 0, 2*time.Second)
+
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	out := b.String()
+
+	// Test: Requests are counted by method, route and status
+	assert.Contains(t, out, `http_requests_total{method="GET",route="/items/",status="200"} 2`+"\n")
+	assert.Contains(t, out, `http_requests_total{method="POST",route="other",status="200"} 1`+"\n")
+	assert.Contains(t, out, `http_request_duration_seconds_count{method="GET",route="/items/"} 2`+"\n")
+
+	// Test: Unknown methods share one label
+	assert.Contains(t, out, `http_requests_total{method="other",route="/items/",status="200"} 2`+"\n")
+	assert.NotContains(t, out, `method="BREW"`)
+
+	// Test: Body sizes are observed
+	assert.Contains(t, out, `http_request_size_bytes_sum{method="POST",route="other"} 3`+"\n")
+	assert.Contains(t, out, `http_response_size_bytes_bucket{method="GET",route="/items/",le="100"} 2`+"\n")
+	assert.Contains(t, out, `http_response_size_bytes_sum{method="GET",route="/items/"} 10`+"\n")
+
+	// Test: Connections, parse errors and upstreams are reported
+	assert.Contains(t, out, "http_active_connections 1\n")
+	assert.Contains(t, out, `http_parse_errors_total{type="headers"} 1`+"\n")
+	assert.Contains(t, out, `http_upstream_duration_seconds_bucket{upstream="origin:80",status="200",le="0.05"} 1`+"\n")
+	assert.Contains(t, out, `http_upstream_duration_seconds_bucket{upstream="origin:80",status="0",le="1"} 0`+"\n")
+	assert.Contains(t, out, `http_upstream_duration_seconds_count{upstream="origin:80",status="0"} 1`+"\n")
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds metrics and exposes them to Prometheus.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter registers a counter whose series are told apart by labels.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(newMetric(name, help, "counter", labels, nil))}
+}
+
+// Gauge registers a gauge whose series are told apart by labels.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(newMetric(name, help, "gauge", labels, nil))}
+}
+
+// Histogram registers a histogram with the given bucket upper bounds, in
+// increasing order, whose series are told apart by labels.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !slices.IsSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %s are not in increasing order", name))
+	}
+	return &Histogram{r.register(newMetric(name, help, "histogram", labels, buckets))}
+}
+
+func (r *Registry) register(m *metric) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.metrics {
+		if other.name == m.name {
+			panic(fmt.Sprintf("metrics: %s registered twice", m.name))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// WriteTo writes every metric, in the order they were registered, in the
+// Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	var b strings.Builder
+	for _, m := range metrics {
+		m.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Handle is a server.Handler that serves the metrics for scraping.
+func (r *Registry) Handle(w *response.Writer, req *request.Request) {
+	var b strings.Builder
+	r.WriteTo(&b)
+
+	h := response.GetDefaultHeaders(b.Len())
+	h.Override("Content-Type", ContentType)
+	h.Override("Cache-Control", "no-store")
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	if _, err := w.WriteBody([]byte(b.String())); err != nil {
+		log.Printf("metrics: error writing metrics: %v", err)
+	}
+}
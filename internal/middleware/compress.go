@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/cache"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressMinSize is the smallest body worth compressing; below it
+// the gzip framing costs more than it saves.
+const DefaultCompressMinSize = 1024
+
+// compressibleTypes are the media types, besides text/*, whose bodies
+// shrink when compressed. Images, audio and video are compressed already.
+var compressibleTypes = []string{
+	"application/javascript",
+	"application/json",
+	"application/wasm",
+	"application/xhtml+xml",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Compress encodes response bodies with gzip or deflate, whichever the
+// client's Accept-Encoding prefers, when their type is compressible and
+// they are at least minSize bytes or of unknown length. Compressed
+// responses are sent chunked.
+func Compress(minSize int) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			encoding := ""
+			// a HEAD response has no body to encode, so its headers could
+			// not match those of the GET it stands for
+			if req.RequestLine.Method != "HEAD" {
+				encoding = headers.NegotiateEncoding(req.Headers, "gzip", "deflate")
+			}
+
+			w.OnWriteHeaders(func(statusCode response.StatusCode, h headers.Headers) {
+				if !compressible(statusCode, h, minSize) {
+					return
+				}
+				addVary(h, "Accept-Encoding")
+				if encoding == "" {
+					return
+				}
+
+				h.Remove("Content-Length")
+				h.Override("Transfer-Encoding", "chunked")
+				h.Override("Content-Encoding", encoding)
+				// the encoded bytes differ from those the tag was made for
+				if etag, ok := h.Get("ETag"); ok {
+					h.Override("ETag", response.WeakETag(etag))
+				}
+				w.EncodeBody(func(dst io.Writer) io.WriteCloser {
+					if encoding == "gzip" {
+						return gzip.NewWriter(dst)
+					}
+					// the deflate content coding is the zlib format (RFC 9110
+					// section 8.4.1.2), not a raw deflate stream
+					return zlib.NewWriter(dst)
+				})
+			})
+
+			next(w, req)
+			if err := w.Finish(); err != nil {
+				log.Printf("middleware: compressing response for %s: %v", req.RequestLine.RequestTarget, err)
+			}
+		}
+	}
+}
+
+func compressible(statusCode response.StatusCode, h headers.Headers, minSize int) bool {
+	switch {
+	case statusCode < 200, statusCode == 204, statusCode == 206, statusCode == 304:
+		return false
+	}
+	if v, ok := h.Get("Content-Encoding"); ok && !strings.EqualFold(v, "identity") {
+		return false
+	}
+	if _, ok := h.Get("Content-Range"); ok {
+		return false
+	}
+	if cache.ParseCacheControl(h).Has("no-transform") {
+		return false
+	}
+	if v, ok := h.Get("Content-Length"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minSize {
+			return false
+		}
+	}
+
+	contentType, _ := h.Get("Content-Type")
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		slices.Contains(compressibleTypes, mediaType)
+}
+
+// addVary adds name to the Vary header unless it is already listed.
+func addVary(h headers.Headers, name string) {
+	v, _ := h.Get("Vary")
+	for _, listed := range strings.Split(v, ",") {
+		listed = strings.TrimSpace(listed)
+		if listed == "*" || strings.EqualFold(listed, name) {
+			return
+		}
+	}
+	h.Set("Vary", name)
+}
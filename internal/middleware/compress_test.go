@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func typed(contentType, body string) server.Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := response.GetDefaultHeaders(len(body))
+		h.Override("Content-Type", contentType)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestCompress(t *testing.T) {
+	text := strings.Repeat("all work and no play makes jack a dull boy\n", 100)
+	handler := server.Chain(typed("text/html", text), Compress(DefaultCompressMinSize))
+
+	// Test: Compressible bodies are gzipped and sent chunked
+	res, body := serve(t, handler, get("/", "Accept-Encoding: gzip, deflate"))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	encoding, _ := res.Headers.Get("Content-Encoding")
+	assert.Equal(t, "gzip", encoding)
+	vary, _ := res.Headers.Get("Vary")
+	assert.Equal(t, "Accept-Encoding", vary)
+	_, ok := res.Headers.Get("Content-Length")
+	assert.False(t, ok)
+	transferEncoding, _ := res.Headers.Get("Transfer-Encoding")
+	assert.Equal(t, "chunked", transferEncoding)
+	assert.Less(t, len(body), len(text))
+	zr, err := gzip.NewReader(strings.NewReader(body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(decoded))
+
+	// Test: Deflate is the zlib format
+	res, body = serve(t, handler, get("/", "Accept-Encoding: gzip;q=0.2, deflate"))
+	encoding, _ = res.Headers.Get("Content-Encoding")
+	assert.Equal(t, "deflate", encoding)
+	zlr, err := zlib.NewReader(strings.NewReader(body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zlr)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(decoded))
+
+	// Test: Clients that do not accept an encoding get the body as is, with Vary
+	res, body = serve(t, handler, get("/"))
+	_, ok = res.Headers.Get("Content-Encoding")
+	assert.False(t, ok)
+	vary, _ = res.Headers.Get("Vary")
+	assert.Equal(t, "Accept-Encoding", vary)
+	assert.Equal(t, text, body)
+
+	// Test: Small bodies, media and encoded bodies are left alone
+	for _, h := range []server.Handler{
+		typed("text/html", "tiny"),
+		typed("video/mp4", text),
+		func(w *response.Writer, _ *request.Request) {
+			w.WriteStatusLine(response.StatusCodeSuccess)
+			h := response.GetDefaultHeaders(len(text))
+			h.Set("Content-Encoding", "br")
+			w.WriteHeaders(h)
+			w.WriteBody([]byte(text))
+		},
+	} {
+		res, _ = serve(t, server.Chain(h, Compress(DefaultCompressMinSize)), get("/", "Accept-Encoding: gzip"))
+		_, ok = res.Headers.Get("Transfer-Encoding")
+		assert.False(t, ok)
+		encoding, _ = res.Headers.Get("Content-Encoding")
+		assert.NotEqual(t, "gzip", encoding)
+	}
+
+	// Test: Chunked bodies of unknown length are compressed as they stream
+	res, body = serve(t, server.Chain(func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := response.GetDefaultHeaders(0)
+		h.Remove("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.NewHeaders())
+	}, Compress(DefaultCompressMinSize)), get("/", "Accept-Encoding: gzip"))
+	zr, err = gzip.NewReader(strings.NewReader(body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(decoded))
+
+	// Test: ETags of compressed responses become weak and still revalidate
+	handler = server.Chain(typed("application/json", text), Compress(DefaultCompressMinSize), ETag(false))
+	res, body = serve(t, handler, get("/", "Accept-Encoding: gzip"))
+	etag, _ := res.Headers.Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, "W/"), etag)
+	zr, err = gzip.NewReader(strings.NewReader(body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(decoded))
+	res, _ = serve(t, handler, get("/", "Accept-Encoding: gzip", "If-None-Match: "+etag))
+	assert.Equal(t, response.StatusCodeNotModified, res.StatusCode)
+
+	// Test: HEAD responses are not encoded
+	res, _ = serve(t, server.Chain(typed("text/html", text), Compress(DefaultCompressMinSize)),
+		"HEAD / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+	_, ok = res.Headers.Get("Content-Encoding")
+	assert.False(t, ok)
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxDecodedSize bounds decoded request bodies unless DecodeBody is
+// given another limit.
+const DefaultMaxDecodedSize = 10 << 20
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errDecodedTooLarge     = errors.New("decoded body too large")
+)
+
+// DecodeBody transparently decompresses request bodies sent with a gzip or
+// deflate Content-Encoding. The handler sees the decoded body with
+// Content-Encoding removed and Content-Length updated; the original coding
+// is kept in Request.ContentEncoding. A body that would decode to more
+// than maxSize bytes is refused with 413, so a small compressed upload
+// cannot expand without bound, and an unknown coding with 415. Streamed
+// bodies, read from Request.BodyReader, are passed on as they came.
+func DecodeBody(maxSize int64) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			encoding, ok := req.Headers.Get("Content-Encoding")
+			if !ok || strings.TrimSpace(encoding) == "" || req.BodyReader != nil {
+				next(w, req)
+				return
+			}
+
+			body, err := decode(req.Body, encoding, maxSize)
+			switch {
+			case errors.Is(err, errUnsupportedEncoding):
+				// tells the client which codings it can use instead (RFC 9110
+				// section 12.5.3)
+				w.OnWriteHeaders(func(_ response.StatusCode, h headers.Headers) {
+					h.Set("Accept-Encoding", "gzip, deflate")
+				})
+				problem.Error(w, req, response.StatusCodeUnsupportedMediaType)
+				return
+			case errors.Is(err, errDecodedTooLarge):
+				problem.Error(w, req, response.StatusCodeContentTooLarge)
+				return
+			case err != nil:
+				log.Printf("middleware: decoding %s request body: %v", encoding, err)
+				problem.Error(w, req, response.StatusCodeBadRequest)
+				return
+			}
+
+			decoded := req.Clone()
+			decoded.Body = body
+			decoded.ContentEncoding = encoding
+			decoded.Headers.Remove("Content-Encoding")
+			decoded.Headers.Override("Content-Length", strconv.Itoa(len(body)))
+			next(w, decoded)
+		}
+	}
+}
+
+// decode undoes the codings listed in a Content-Encoding value, which were
+// applied in order, so the last one is removed first.
+func decode(body []byte, encoding string, maxSize int64) ([]byte, error) {
+	codings := strings.Split(encoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		var r io.ReadCloser
+		var err error
+		switch coding {
+		case "identity", "":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			r, err = newDeflateReader(body)
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, coding)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// reading one byte past the limit tells a body that is exactly
+		// maxSize apart from one that is larger
+		decoded, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(decoded)) > maxSize {
+			return nil, errDecodedTooLarge
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+// newDeflateReader reads the zlib format that the deflate coding names,
+// falling back to a raw deflate stream, which some clients send instead.
+func newDeflateReader(body []byte) (io.ReadCloser, error) {
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	if errors.Is(err, zlib.ErrHeader) {
+		return flate.NewReader(bytes.NewReader(body)), nil
+	}
+	return r, err
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compressed(t *testing.T, newWriter func(io.Writer) io.WriteCloser, data string) string {
+	var buf bytes.Buffer
+	zw := newWriter(&buf)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.String()
+}
+
+func post(encoding, body string) string {
+	raw := "POST /telemetry HTTP/1.1\r\nHost: localhost\r\n"
+	if encoding != "" {
+		raw += "Content-Encoding: " + encoding + "\r\n"
+	}
+	return raw + fmt.Sprintf("Content-Length: %d\r\n\r\n", len(body)) + body
+}
+
+func TestDecodeBody(t *testing.T) {
+	var got *request.Request
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		got = req
+		typed("text/plain", "ok")(w, req)
+	}, DecodeBody(1024))
+	gzipWriter := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+	zlibWriter := func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+	flateWriter := func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	}
+	payload := `{"cpu":0.5,"mem":1024}`
+
+	// Test: Gzip bodies reach the handler decoded, with the original coding kept
+	res, _ := serve(t, handler, post("gzip", compressed(t, gzipWriter, payload)))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	assert.Equal(t, payload, string(got.Body))
+	assert.Equal(t, "gzip", got.ContentEncoding)
+	_, ok := got.Headers.Get("Content-Encoding")
+	assert.False(t, ok)
+	contentLength, _ := got.Headers.Get("Content-Length")
+	assert.Equal(t, fmt.Sprint(len(payload)), contentLength)
+
+	// Test: Deflate accepts both zlib and raw deflate streams
+	for _, body := range []string{compressed(t, zlibWriter, payload), compressed(t, flateWriter, payload)} {
+		got = nil
+		res, _ = serve(t, handler, post("deflate", body))
+		assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+		require.NotNil(t, got)
+		assert.Equal(t, payload, string(got.Body))
+	}
+
+	// Test: Stacked codings are removed last first
+	res, _ = serve(t, handler, post("deflate, gzip", compressed(t, gzipWriter, compressed(t, zlibWriter, payload))))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	assert.Equal(t, payload, string(got.Body))
+
+	// Test: Unencoded bodies pass through untouched
+	res, _ = serve(t, handler, post("", payload))
+	assert.Equal(t, payload, string(got.Body))
+	assert.Empty(t, got.ContentEncoding)
+
+	// Test: Bodies that expand past the limit are refused
+	got = nil
+	bomb := compressed(t, gzipWriter, strings.Repeat("0", 1<<20))
+	assert.Less(t, len(bomb), 4096)
+	res, _ = serve(t, handler, post("gzip", bomb))
+	assert.Equal(t, response.StatusCodeContentTooLarge, res.StatusCode)
+	assert.Nil(t, got)
+	res, _ = serve(t, handler, post("gzip", compressed(t, gzipWriter, strings.Repeat("0", 1024))))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+
+	// Test: Unknown codings are a 415 naming the supported ones
+	res, _ = serve(t, handler, post("br", "whatever"))
+	assert.Equal(t, response.StatusCodeUnsupportedMediaType, res.StatusCode)
+	acceptEncoding, _ := res.Headers.Get("Accept-Encoding")
+	assert.Equal(t, "gzip, deflate", acceptEncoding)
+
+	// Test: Corrupt bodies are a 400
+	res, _ = serve(t, handler, post("gzip", "not gzip at all"))
+	assert.Equal(t, response.StatusCodeBadRequest, res.StatusCode)
+}
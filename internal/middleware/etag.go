@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"log"
+	"time"
+)
+
+// ETag buffers the response to each GET request, gives a successful one
+// without a validator an entity tag hashed from its body, and answers
+// conditional requests with 304 Not Modified or 412 Precondition Failed.
+// With weak set the tags are weak, for responses that are only
+// semantically equivalent between requests. It must only wrap handlers
+// whose responses fit in memory.
+func ETag(weak bool) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.Method != "GET" {
+				next(w, req)
+				return
+			}
+
+			rec := response.NewRecorder()
+			next(rec.Writer, req)
+			if !rec.Written() {
+				return
+			}
+
+			etag, ok := rec.Headers.Get("ETag")
+			if !ok && rec.StatusCode == response.StatusCodeSuccess {
+				etag = response.StrongETag(rec.Body.Bytes())
+				if weak {
+					etag = response.WeakETag(etag)
+				}
+				rec.Headers.Override("ETag", etag)
+			}
+
+			if rec.StatusCode == response.StatusCodeSuccess {
+				switch response.CheckPreconditions(req, etag, lastModified(rec.Headers)) {
+				case response.StatusCodeNotModified:
+					w.WriteStatusLine(response.StatusCodeNotModified)
+					w.WriteHeaders(response.NotModifiedHeaders(rec.Headers))
+					return
+				case response.StatusCodePreconditionFailed:
+					problem.Error(w, req, response.StatusCodePreconditionFailed)
+					return
+				}
+			}
+
+			if err := rec.WriteTo(w); err != nil {
+				log.Printf("middleware: writing response for %s: %v", req.RequestLine.RequestTarget, err)
+			}
+		}
+	}
+}
+
+func lastModified(h headers.Headers) time.Time {
+	v, ok := h.Get("Last-Modified")
+	if !ok {
+		return time.Time{}
+	}
+	t, _ := headers.ParseTime(v)
+	return t
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, handle server.Handler, raw string) (*client.Response, string) {
+	req, err := request.RequestFromReader(bytes.NewBufferString(raw))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	handle(response.NewWriter(&out), req)
+	res, err := client.ReadResponse(bufio.NewReader(&out), req.RequestLine.Method)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func get(target string, extra ...string) string {
+	raw := "GET " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+	for _, h := range extra {
+		raw += h + "\r\n"
+	}
+	return raw + "\r\n"
+}
+
+func page(body string) server.Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := response.GetDefaultHeaders(len(body))
+		h.Override("Cache-Control", "no-cache")
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestETag(t *testing.T) {
+	handler := server.Chain(page("hello"), ETag(false))
+
+	// Test: Successful responses get a strong tag hashed from the body
+	res, body := serve(t, handler, get("/"))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	assert.Equal(t, "hello", body)
+	etag, ok := res.Headers.Get("ETag")
+	require.True(t, ok)
+	assert.Equal(t, response.StrongETag([]byte("hello")), etag)
+
+	// Test: A matching If-None-Match is answered with a bodiless 304
+	res, body = serve(t, handler, get("/", "If-None-Match: "+etag))
+	assert.Equal(t, response.StatusCodeNotModified, res.StatusCode)
+	assert.Empty(t, body)
+	v, _ := res.Headers.Get("ETag")
+	assert.Equal(t, etag, v)
+	v, _ = res.Headers.Get("Cache-Control")
+	assert.Equal(t, "no-cache", v)
+
+	// Test: Another body means another tag
+	res, body = serve(t, server.Chain(page("changed"), ETag(false)), get("/", "If-None-Match: "+etag))
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	assert.Equal(t, "changed", body)
+
+	// Test: A failed If-Match is a 412
+	res, _ = serve(t, handler, get("/", `If-Match: "stale"`))
+	assert.Equal(t, response.StatusCodePreconditionFailed, res.StatusCode)
+
+	// Test: Weak tags still match If-None-Match
+	weak := server.Chain(page("hello"), ETag(true))
+	res, _ = serve(t, weak, get("/"))
+	etag, _ = res.Headers.Get("ETag")
+	assert.Equal(t, response.WeakETag(response.StrongETag([]byte("hello"))), etag)
+	res, _ = serve(t, weak, get("/", "If-None-Match: "+etag))
+	assert.Equal(t, response.StatusCodeNotModified, res.StatusCode)
+
+	// Test: Tags set by the handler are kept
+	res, _ = serve(t, server.Chain(func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := response.GetDefaultHeaders(2)
+		h.Set("ETag", `"v7"`)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("ok"))
+	}, ETag(false)), get("/", `If-None-Match: "v7"`))
+	assert.Equal(t, response.StatusCodeNotModified, res.StatusCode)
+
+	// Test: Error responses and chunked bodies pass through unchanged
+	res, body = serve(t, server.Chain(func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := response.GetDefaultHeaders(0)
+		h.Remove("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Done")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("ab"))
+		w.WriteChunkedBody([]byte("cd"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Done", "yes")
+		w.WriteTrailers(trailers)
+	}, ETag(false)), get("/"))
+	assert.Equal(t, "abcd", body)
+	done, _ := res.Trailers.Get("X-Done")
+	assert.Equal(t, "yes", done)
+	_, ok = res.Headers.Get("ETag")
+	assert.True(t, ok)
+	res, _ = serve(t, server.Chain(func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeInternalServerError)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}, ETag(false)), get("/", `If-None-Match: *`))
+	assert.Equal(t, response.StatusCodeInternalServerError, res.StatusCode)
+	_, ok = res.Headers.Get("ETag")
+	assert.False(t, ok)
+}
@@ -0,0 +1,266 @@
+package middleware
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// forwardingHeaders are the headers through which proxies describe the
+// client. Only trusted proxies may set them.
+var forwardingHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host"}
+
+// hop is what one proxy reported about the peer it received the request
+// from.
+type hop struct {
+	// addr is invalid when the proxy hid or did not know the address
+	addr  netip.Addr
+	proto string
+	host  string
+}
+
+// Forwarded resolves the client behind trusted proxies from the
+// Forwarded header (RFC 7239), or X-Forwarded-For, -Proto and -Host when
+// there is none. The hops are walked from the nearest: each trusted
+// proxy's report is believed, and the first address that is not trusted
+// is the client. The result replaces the request's ClientIP, Scheme and
+// Host; when the client's address is hidden or unknown, ClientIP stays
+// that of the proxy that reported it. Malformed headers from a trusted
+// proxy are refused with 400. Requests from untrusted peers have these
+// headers removed, so nothing further down, the proxy included, can be
+// fooled by them.
+func Forwarded(trusted []netip.Prefix) server.Middleware {
+	isTrusted := func(addr netip.Addr) bool {
+		addr = addr.Unmap()
+		for _, p := range trusted {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			peer, err := netip.ParseAddr(req.ClientIP)
+			if err != nil || !isTrusted(peer) {
+				if hasForwardingHeaders(req.Headers) {
+					req = req.Clone()
+					for _, name := range forwardingHeaders {
+						req.Headers.Remove(name)
+					}
+				}
+				next(w, req)
+				return
+			}
+
+			hops, ok := forwardedHops(req.Headers)
+			if !ok {
+				problem.Error(w, req, response.StatusCodeBadRequest)
+				return
+			}
+			// walk from the nearest hop until one is not a trusted proxy
+			i := len(hops) - 1
+			for i > 0 && hops[i].addr.IsValid() && isTrusted(hops[i].addr) {
+				i--
+			}
+			if i < 0 {
+				next(w, req)
+				return
+			}
+			client := hops[i]
+
+			req = req.Clone()
+			if client.addr.IsValid() {
+				req.ClientIP = client.addr.Unmap().String()
+			} else if i+1 < len(hops) {
+				req.ClientIP = hops[i+1].addr.Unmap().String()
+			}
+			if client.proto != "" {
+				req.Scheme = strings.ToLower(client.proto)
+			}
+			if client.host != "" {
+				req.Host = client.host
+			}
+			next(w, req)
+		}
+	}
+}
+
+func hasForwardingHeaders(h headers.Headers) bool {
+	for _, name := range forwardingHeaders {
+		if _, ok := h.Get(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHops returns the hops listed in the Forwarded header, or else
+// in X-Forwarded-*, from the farthest to the nearest. ok is false when a
+// header is malformed.
+func forwardedHops(h headers.Headers) (hops []hop, ok bool) {
+	if v, found := h.Get("Forwarded"); found {
+		return parseForwarded(v)
+	}
+
+	v, found := h.Get("X-Forwarded-For")
+	if !found {
+		return nil, true
+	}
+	for _, s := range splitList(v) {
+		addr, err := parseNode(s)
+		if err != nil {
+			return nil, false
+		}
+		hops = append(hops, hop{addr: addr})
+	}
+	// each proxy appends the scheme and host it saw, so they line up with
+	// the addresses from the right; a single value set by the edge proxy
+	// applies to all of them
+	protos := splitList(h["x-forwarded-proto"])
+	hosts := splitList(h["x-forwarded-host"])
+	for i := range hops {
+		hops[i].proto = alignedFromRight(protos, len(hops), i)
+		hops[i].host = alignedFromRight(hosts, len(hops), i)
+	}
+	for _, hop := range hops {
+		if !validHop(hop) {
+			return nil, false
+		}
+	}
+	return hops, true
+}
+
+// alignedFromRight returns the entry of values matching entry i of a list
+// of n, counting from the right, or the first one when values is shorter.
+func alignedFromRight(values []string, n, i int) string {
+	j := len(values) - (n - i)
+	if j < 0 {
+		j = 0
+	}
+	if j >= len(values) {
+		return ""
+	}
+	return values[j]
+}
+
+// parseForwarded parses a Forwarded header, such as
+// `for=192.0.2.43;proto=https, for="[2001:db8::17]:4711";host=example.com`.
+func parseForwarded(v string) ([]hop, bool) {
+	var hops []hop
+	for _, element := range splitQuoted(v, ',') {
+		var hop hop
+		for _, pair := range splitQuoted(element, ';') {
+			if pair == "" {
+				continue
+			}
+			name, value, found := strings.Cut(pair, "=")
+			if !found {
+				return nil, false
+			}
+			value, ok := unquote(value)
+			if !ok {
+				return nil, false
+			}
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "for":
+				addr, err := parseNode(value)
+				if err != nil {
+					return nil, false
+				}
+				hop.addr = addr
+			case "proto":
+				hop.proto = value
+			case "host":
+				hop.host = value
+			}
+		}
+		if !validHop(hop) {
+			return nil, false
+		}
+		hops = append(hops, hop)
+	}
+	return hops, true
+}
+
+// parseNode parses a node (RFC 7239 section 6): an address with an
+// optional port, with IPv6 in brackets, or an obfuscated or unknown
+// identifier, which gives an invalid address.
+func parseNode(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if s == "unknown" || strings.HasPrefix(s, "_") {
+		return netip.Addr{}, nil
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	} else {
+		s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	}
+	return netip.ParseAddr(s)
+}
+
+// validHop checks the scheme and host a proxy reported, which end up in
+// URLs and links built from the request.
+func validHop(h hop) bool {
+	proto := strings.ToLower(h.proto)
+	if proto != "" && proto != "http" && proto != "https" {
+		return false
+	}
+	return !strings.ContainsAny(h.host, " \t\r\n/\\@?#\"")
+}
+
+// splitList splits a comma-separated header value, dropping empty
+// entries.
+func splitList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// splitQuoted splits s on sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// unquote returns a token as is, and a quoted string without its quotes
+// and escapes.
+func unquote(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, `"`) {
+		return s, !strings.ContainsAny(s, `" `)
+	}
+	if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), true
+}
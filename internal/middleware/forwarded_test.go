@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parse reads a raw request as the server would, coming from peer.
+func parse(t *testing.T, raw, peer string) *request.Request {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.ClientIP, req.Scheme = peer, "http"
+	req.Host, _ = req.Headers.Get("Host")
+	return req
+}
+
+func TestForwarded(t *testing.T) {
+	var got *request.Request
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		got = req
+		typed("text/plain", "ok")(w, req)
+	}, Forwarded([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ffff::/48"),
+	}))
+	// resolve sends a request from peer with the given headers, and returns
+	// the client the handler saw
+	resolve := func(peer string, hdrs ...string) (string, string, string) {
+		raw := "GET / HTTP/1.1\r\nHost: internal:8080\r\n"
+		for _, h := range hdrs {
+			raw += h + "\r\n"
+		}
+		req := parse(t, raw+"\r\n", peer)
+		got = nil
+		res := response.NewRecorder()
+		handler(res.Writer, req)
+		require.NotNil(t, got, "request refused: %d", res.StatusCode)
+		return got.ClientIP, got.Scheme, got.Host
+	}
+
+	// Test: Without forwarding headers the peer is the client
+	ip, scheme, host := resolve("10.0.0.1")
+	assert.Equal(t, []string{"10.0.0.1", "http", "internal:8080"}, []string{ip, scheme, host})
+
+	// Test: X-Forwarded-* from a trusted proxy are believed
+	ip, scheme, host = resolve("10.0.0.1", "X-Forwarded-For: 203.0.113.7", "X-Forwarded-Proto: https", "X-Forwarded-Host: example.com")
+	assert.Equal(t, []string{"203.0.113.7", "https", "example.com"}, []string{ip, scheme, host})
+
+	// Test: The chain is walked from the right up to the first untrusted hop
+	ip, _, _ = resolve("10.0.0.1", "X-Forwarded-For: 198.51.100.1, 203.0.113.7, 10.0.0.2")
+	assert.Equal(t, "203.0.113.7", ip)
+	ip, _, _ = resolve("10.0.0.1", "X-Forwarded-For: 10.0.0.3, 10.0.0.2")
+	assert.Equal(t, "10.0.0.3", ip)
+	ip, scheme, _ = resolve("10.0.0.1", "X-Forwarded-For: 198.51.100.1, 203.0.113.7, 10.0.0.2", "X-Forwarded-Proto: http, https, http")
+	assert.Equal(t, "203.0.113.7", ip)
+	assert.Equal(t, "https", scheme)
+
+	// Test: Forwarded takes precedence, with its quoting and IPv6 brackets
+	ip, scheme, host = resolve("10.0.0.1",
+		`Forwarded: for=198.51.100.1, for="[2001:db8:cafe::17]:4711";proto=HTTPS;host="example.com", for=10.0.0.2`,
+		"X-Forwarded-For: 192.0.2.1")
+	assert.Equal(t, []string{"2001:db8:cafe::17", "https", "example.com"}, []string{ip, scheme, host})
+	ip, _, _ = resolve("2001:db8:ffff::1", `Forwarded: for=192.0.2.60;by=203.0.113.43, for="[2001:db8:ffff::2]"`)
+	assert.Equal(t, "192.0.2.60", ip)
+
+	// Test: A hidden client leaves the proxy that hid it
+	ip, _, _ = resolve("10.0.0.1", "Forwarded: for=_hidden, for=10.0.0.2")
+	assert.Equal(t, "10.0.0.2", ip)
+	ip, _, _ = resolve("10.0.0.1", "Forwarded: for=unknown")
+	assert.Equal(t, "10.0.0.1", ip)
+
+	// Test: Untrusted peers cannot spoof the client, and lose the headers
+	ip, scheme, host = resolve("203.0.113.9", "X-Forwarded-For: 10.0.0.1", "X-Forwarded-Proto: https", "Forwarded: for=1.2.3.4")
+	assert.Equal(t, []string{"203.0.113.9", "http", "internal:8080"}, []string{ip, scheme, host})
+	for _, name := range []string{"forwarded", "x-forwarded-for", "x-forwarded-proto"} {
+		assert.NotContains(t, got.Headers, name)
+	}
+
+	// Test: Malformed headers from trusted proxies are refused
+	for _, bad := range []string{
+		"X-Forwarded-For: 203.0.113.7, not-an-ip",
+		"X-Forwarded-For: 203.0.113.7\r\nX-Forwarded-Proto: gopher",
+		"X-Forwarded-For: 203.0.113.7\r\nX-Forwarded-Host: evil.com/path",
+		`Forwarded: for="203.0.113.7`,
+		"Forwarded: for",
+	} {
+		req := parse(t, "GET / HTTP/1.1\r\nHost: internal\r\n"+bad+"\r\n\r\n", "10.0.0.1")
+		got = nil
+		res := response.NewRecorder()
+		handler(res.Writer, req)
+		assert.Nil(t, got, bad)
+		assert.Equal(t, response.StatusCodeBadRequest, res.StatusCode, bad)
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// maxRequestIDLength bounds the X-Request-ID accepted from clients, which
+// ends up in logs and upstream requests.
+const maxRequestIDLength = 128
+
+// RequestID makes sure every request has an X-Request-ID: the client's is
+// kept when it is a plausible identifier, otherwise a random one replaces
+// it. The ID is left on the request, where the access log and the proxy
+// pick it up, and echoed in the response. It should come first in the
+// chain, after Forwarded, so everything else sees the same ID.
+func RequestID() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id, ok := req.Headers.Get("X-Request-ID")
+			if !ok || !validRequestID(id) {
+				id = newRequestID()
+				req = req.Clone()
+				req.Headers.Override("X-Request-ID", id)
+			}
+			w.OnWriteHeaders(func(_ response.StatusCode, h headers.Headers) {
+				h.Override("X-Request-ID", id)
+			})
+			next(w, req)
+		}
+	}
+}
+
+// validRequestID allows the characters of UUIDs, hex and base64 IDs, and
+// nothing that could break a log line or a header.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':' || c == '+' || c == '/' || c == '=') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
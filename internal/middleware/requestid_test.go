@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	var got string
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		got, _ = req.Headers.Get("X-Request-ID")
+		typed("text/plain", "ok")(w, req)
+	}, RequestID())
+	// send returns the ID the handler saw and the one the client got back
+	send := func(hdrs ...string) (string, string) {
+		raw := "GET / HTTP/1.1\r\nHost: localhost\r\n" + strings.Join(hdrs, "\r\n")
+		if len(hdrs) > 0 {
+			raw += "\r\n"
+		}
+		req := parse(t, raw+"\r\n", "203.0.113.7")
+		res := response.NewRecorder()
+		handler(res.Writer, req)
+		return got, res.Headers["x-request-id"]
+	}
+
+	// Test: A missing ID is generated and echoed
+	seen, echoed := send()
+	assert.Regexp(t, regexp.MustCompile("^[0-9a-f]{32}$"), seen)
+	assert.Equal(t, seen, echoed)
+	other, _ := send()
+	assert.NotEqual(t, seen, other)
+
+	// Test: The client's ID is kept
+	seen, echoed = send("X-Request-ID: 3f2504e0-4f89-11d3-9a0c-0305e82c3301")
+	assert.Equal(t, "3f2504e0-4f89-11d3-9a0c-0305e82c3301", seen)
+	assert.Equal(t, seen, echoed)
+
+	// Test: Implausible IDs are replaced
+	for _, bad := range []string{"X-Request-ID: has space", "X-Request-ID: a\"b", "X-Request-ID: " + strings.Repeat("a", 129)} {
+		seen, echoed = send(bad)
+		assert.Regexp(t, regexp.MustCompile("^[0-9a-f]{32}$"), seen, bad)
+		assert.Equal(t, seen, echoed, bad)
+	}
+}
@@ -0,0 +1,111 @@
+package problem
+
+import (
+	htmltemplate "html/template"
+	"httpfromtcp/internal/response"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+const defaultHTML = `<html>
+<head>
+<title>{{.Status}} {{.Reason}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{- with .Detail}}
+<p>{{.}}</p>
+{{- end}}
+</body>
+</html>
+`
+
+const defaultText = `{{.Status}} {{.Title}}
+{{with .Detail}}{{.}}
+{{end}}`
+
+// Pages renders problems from templates: a template named after the status
+// code, such as "404", if there is one, and "error" otherwise.
+type Pages struct {
+	htmlTemplates map[string]*htmltemplate.Template
+	textTemplates map[string]*texttemplate.Template
+}
+
+// NewPages returns Pages using the built-in templates.
+func NewPages() *Pages {
+	return &Pages{
+		htmlTemplates: map[string]*htmltemplate.Template{
+			"error": htmltemplate.Must(htmltemplate.New("error").Parse(defaultHTML)),
+		},
+		textTemplates: map[string]*texttemplate.Template{
+			"error": texttemplate.Must(texttemplate.New("error").Parse(defaultText)),
+		},
+	}
+}
+
+// LoadPages returns Pages that use the templates in dir in place of the
+// built-in ones. Templates are named "error.html", "error.txt", or after a
+// status code, like "404.html"; other files are ignored. They are executed
+// with the Problem fields, plus Reason, the standard reason phrase.
+func LoadPages(dir string) (*Pages, error) {
+	pages := NewPages()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if !validTemplateName(name) || (ext != ".html" && ext != ".txt") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if ext == ".html" {
+			t, err := htmltemplate.New(name).Parse(string(data))
+			if err != nil {
+				return nil, err
+			}
+			pages.htmlTemplates[name] = t
+		} else {
+			t, err := texttemplate.New(name).Parse(string(data))
+			if err != nil {
+				return nil, err
+			}
+			pages.textTemplates[name] = t
+		}
+	}
+	return pages, nil
+}
+
+func validTemplateName(name string) bool {
+	if name == "error" {
+		return true
+	}
+	code, err := strconv.Atoi(name)
+	return err == nil && len(name) == 3 && code >= 100
+}
+
+func (pages *Pages) html(statusCode response.StatusCode) *htmltemplate.Template {
+	if t, ok := pages.htmlTemplates[strconv.Itoa(int(statusCode))]; ok {
+		return t
+	}
+	return pages.htmlTemplates["error"]
+}
+
+func (pages *Pages) text(statusCode response.StatusCode) *texttemplate.Template {
+	if t, ok := pages.textTemplates[strconv.Itoa(int(statusCode))]; ok {
+		return t
+	}
+	return pages.textTemplates["error"]
+}
@@ -0,0 +1,128 @@
+package problem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"log"
+)
+
+// Problem describes an error in the shape of RFC 9457 problem details.
+type Problem struct {
+	// Type is a URI identifying the kind of problem; "about:blank" when
+	// empty, meaning the status code says it all.
+	Type   string              `json:"type"`
+	Title  string              `json:"title"`
+	Status response.StatusCode `json:"status"`
+	Detail string              `json:"detail,omitempty"`
+	// Instance is a URI identifying this occurrence of the problem.
+	Instance string `json:"instance,omitempty"`
+}
+
+// New returns a Problem for statusCode. Unless Title is set it is rendered
+// with the reason phrase as its title.
+func New(statusCode response.StatusCode, detail string) *Problem {
+	return &Problem{
+		Status: statusCode,
+		Detail: detail,
+	}
+}
+
+// Renderer writes a Problem as a complete response.
+type Renderer interface {
+	Render(w *response.Writer, req *request.Request, p *Problem)
+}
+
+// Default renders with the built-in templates.
+var Default Renderer = NewPages()
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying r, which Error then renders
+// with. The server does this for every request with its error renderer.
+func NewContext(ctx context.Context, r Renderer) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// Error answers req with the problem of statusCode, rendered by the
+// renderer in the request's context, or Default if there is none. Headers
+// such as Allow can be added from an OnWriteHeaders hook.
+func Error(w *response.Writer, req *request.Request, statusCode response.StatusCode) {
+	r, ok := req.Context().Value(contextKey{}).(Renderer)
+	if !ok {
+		r = Default
+	}
+	r.Render(w, req, New(statusCode, ""))
+}
+
+// mediaTypes are the formats Pages can render, in order of preference for
+// clients that accept any.
+var mediaTypes = []string{
+	"text/html",
+	"application/problem+json",
+	"application/json",
+	"text/plain",
+}
+
+// Render writes p as HTML, JSON or plain text, whichever req accepts. req
+// may be nil when the request could not be parsed, and then plain text is
+// sent. Problems are errors, so p.Status is a 4xx or 5xx code.
+func (pages *Pages) Render(w *response.Writer, req *request.Request, p *Problem) {
+	data := *p
+	if data.Type == "" {
+		data.Type = "about:blank"
+	}
+	if data.Title == "" {
+		data.Title = response.ReasonPhrase(data.Status)
+	}
+
+	mediaType := "text/plain"
+	if req != nil {
+		mediaType = headers.NegotiateMediaType(req.Headers, mediaTypes...)
+	}
+
+	var body bytes.Buffer
+	var err error
+	contentType := mediaType
+	switch mediaType {
+	case "text/html":
+		contentType = "text/html; charset=utf-8"
+		err = pages.html(data.Status).Execute(&body, pageData{data, response.ReasonPhrase(data.Status)})
+	case "application/problem+json", "application/json":
+		contentType = "application/problem+json"
+		err = json.NewEncoder(&body).Encode(data)
+	default:
+		// a client that accepts none of the formats still gets to know
+		// what went wrong rather than a 406
+		contentType = "text/plain; charset=utf-8"
+		err = pages.text(data.Status).Execute(&body, pageData{data, response.ReasonPhrase(data.Status)})
+	}
+	if err != nil {
+		log.Printf("problem: rendering %d as %s: %v", data.Status, mediaType, err)
+		body.Reset()
+		body.WriteString(data.Title + "\n")
+		contentType = "text/plain; charset=utf-8"
+	}
+
+	h := response.GetDefaultHeaders(body.Len())
+	h.Override("Content-Type", contentType)
+	if req != nil {
+		h.Set("Vary", "Accept")
+	}
+	w.WriteStatusLine(data.Status)
+	w.WriteHeaders(h)
+	if req == nil || req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body.Bytes())
+	}
+}
+
+// pageData is what the templates are executed with.
+type pageData struct {
+	Problem
+	// Reason is the standard reason phrase of the status code, which Title
+	// may replace with something friendlier.
+	Reason string
+}
@@ -0,0 +1,119 @@
+package problem
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func render(t *testing.T, r Renderer, p *Problem, accept string) (*client.Response, string) {
+	var req *request.Request
+	if accept != "-" {
+		raw := "GET /thing HTTP/1.1\r\nHost: localhost\r\n"
+		if accept != "" {
+			raw += "Accept: " + accept + "\r\n"
+		}
+		var err error
+		req, err = request.RequestFromReader(bytes.NewBufferString(raw + "\r\n"))
+		require.NoError(t, err)
+	}
+
+	var out bytes.Buffer
+	r.Render(response.NewWriter(&out), req, p)
+	res, err := client.ReadResponse(bufio.NewReader(&out), "GET")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestRender(t *testing.T) {
+	p := New(response.StatusCodeNotFound, "No <thing> here.")
+
+	// Test: Browsers get escaped HTML
+	res, body := render(t, Default, p, "text/html,application/xhtml+xml,*/*;q=0.8")
+	assert.Equal(t, response.StatusCodeNotFound, res.StatusCode)
+	contentType, _ := res.Headers.Get("Content-Type")
+	assert.Equal(t, "text/html; charset=utf-8", contentType)
+	vary, _ := res.Headers.Get("Vary")
+	assert.Equal(t, "Accept", vary)
+	assert.Contains(t, body, "<title>404 Not Found</title>")
+	assert.Contains(t, body, "<p>No &lt;thing&gt; here.</p>")
+
+	// Test: API clients get RFC 9457 problem details
+	res, body = render(t, Default, p, "application/json")
+	contentType, _ = res.Headers.Get("Content-Type")
+	assert.Equal(t, "application/problem+json", contentType)
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &decoded))
+	assert.Equal(t, map[string]any{
+		"type":   "about:blank",
+		"title":  "Not Found",
+		"status": float64(404),
+		"detail": "No <thing> here.",
+	}, decoded)
+
+	// Test: Plain text for clients that ask for it, or accept nothing offered
+	res, body = render(t, Default, p, "text/plain")
+	assert.Equal(t, "404 Not Found\nNo <thing> here.\n", body)
+	res, body = render(t, Default, p, "image/png")
+	contentType, _ = res.Headers.Get("Content-Type")
+	assert.Equal(t, "text/plain; charset=utf-8", contentType)
+
+	// Test: Without a request there is nothing to negotiate
+	res, body = render(t, Default, New(response.StatusCodeBadRequest, "bad request line"), "-")
+	assert.Equal(t, response.StatusCodeBadRequest, res.StatusCode)
+	assert.Equal(t, "400 Bad Request\nbad request line\n", body)
+	_, ok := res.Headers.Get("Vary")
+	assert.False(t, ok)
+}
+
+func TestLoadPages(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	write("error.html", "<h1>{{.Status}} {{.Reason}}: {{.Detail}}</h1>")
+	write("404.html", "<h1>lost: {{.Detail}}</h1>")
+	write("error.txt", "oops {{.Status}}\n")
+	write("notes.html", "ignored")
+
+	pages, err := LoadPages(dir)
+	require.NoError(t, err)
+
+	// Test: Status templates take precedence over the error template
+	_, body := render(t, pages, New(response.StatusCodeNotFound, "<x>"), "text/html")
+	assert.Equal(t, "<h1>lost: &lt;x&gt;</h1>", body)
+	_, body = render(t, pages, New(response.StatusCodeInternalServerError, "db down"), "text/html")
+	assert.Equal(t, "<h1>500 Internal Server Error: db down</h1>", body)
+	_, body = render(t, pages, New(response.StatusCodeInternalServerError, "db down"), "text/plain")
+	assert.Equal(t, "oops 500\n", body)
+
+	// Test: Error renders with the renderer in the request's context
+	req, err := request.RequestFromReader(bytes.NewBufferString("GET / HTTP/1.1\r\nHost: localhost\r\nAccept: text/plain\r\n\r\n"))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	Error(response.NewWriter(&out), req.WithContext(NewContext(context.Background(), pages)), response.StatusCodeForbidden)
+	assert.True(t, bytes.HasSuffix(out.Bytes(), []byte("\r\n\r\noops 403\n")), out.String())
+	out.Reset()
+	Error(response.NewWriter(&out), req, response.StatusCodeForbidden)
+	assert.True(t, bytes.HasSuffix(out.Bytes(), []byte("\r\n\r\n403 Forbidden\n")), out.String())
+
+	// Test: Broken templates fail to load
+	write("500.html", "{{.Nope")
+	_, err = LoadPages(dir)
+	assert.Error(t, err)
+	_, err = LoadPages(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
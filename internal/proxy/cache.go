@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"httpfromtcp/internal/cache"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+// serveCached answers a cacheable request from p.Cache when it can, and
+// otherwise fetches from the upstream and stores the response on the way
+// through.
+func (p *ReverseProxy) serveCached(ctx context.Context, w *response.Writer, req *request.Request) {
+	entry, status := p.Cache.Lookup(req, time.Now())
+	switch status {
+	case cache.Fresh:
+		writeEntry(w, req, entry, "HIT")
+		return
+	case cache.StaleWhileRevalidate:
+		writeEntry(w, req, entry, "STALE")
+		p.revalidateInBackground(req, entry)
+		return
+	case cache.Stale:
+		p.revalidate(ctx, w, req, entry)
+		return
+	}
+
+	requestTime := time.Now()
+	res, release, err := p.roundTrip(ctx, req)
+	if err != nil {
+		writeUpstreamError(w, req, err)
+		return
+	}
+	defer release()
+	p.copyAndStore(w, req, res, requestTime)
+}
+
+// revalidate asks the upstream whether a stale entry can still be used.
+func (p *ReverseProxy) revalidate(ctx context.Context, w *response.Writer, req *request.Request, entry *cache.Entry) {
+	requestTime := time.Now()
+	res, release, err := p.roundTrip(ctx, revalidationRequest(req, entry))
+	if err != nil {
+		if entry.MustRevalidate() {
+			problem.Error(w, req, response.StatusCodeGatewayTimeout)
+			return
+		}
+		writeEntry(w, req, entry, "STALE")
+		return
+	}
+	defer release()
+
+	if res.StatusCode == 304 && entry.HasValidators() {
+		updated := entry.Revalidated(res.Headers, requestTime, time.Now())
+		p.Cache.Put(req, updated)
+		writeEntry(w, req, updated, "REVALIDATED")
+		return
+	}
+	p.copyAndStore(w, req, res, requestTime)
+}
+
+// revalidateInBackground refreshes an entry served under
+// stale-while-revalidate. Only one refresh per resource runs at a time.
+func (p *ReverseProxy) revalidateInBackground(req *request.Request, entry *cache.Entry) {
+	key := cache.Key(req)
+	if _, running := p.revalidating.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	req = revalidationRequest(req, entry)
+
+	go func() {
+		defer p.revalidating.Delete(key)
+		// the refresh outlives the request that triggered it
+		ctx, cancel := p.context(context.WithoutCancel(req.Context()))
+		defer cancel()
+
+		requestTime := time.Now()
+		res, release, err := p.roundTrip(ctx, req)
+		if err != nil {
+			return
+		}
+		defer release()
+
+		if res.StatusCode == 304 && entry.HasValidators() {
+			p.Cache.Put(req, entry.Revalidated(res.Headers, requestTime, time.Now()))
+			return
+		}
+		if !cache.Storable(req, res.StatusCode, res.Headers) {
+			p.Cache.Invalidate(req)
+			return
+		}
+		capture := newCaptureBuffer(p.Cache.MaxEntrySize)
+		if _, err := io.Copy(capture, res.Body); err != nil || capture.overflow {
+			return
+		}
+		p.Cache.Put(req, newEntry(res, capture.Bytes(), requestTime))
+	}()
+}
+
+func (p *ReverseProxy) copyAndStore(w *response.Writer, req *request.Request, res *client.Response, requestTime time.Time) {
+	responseTime := time.Now()
+	var capture *captureBuffer
+	var stored *cache.Entry
+	if cache.Storable(req, res.StatusCode, res.Headers) {
+		capture = newCaptureBuffer(p.Cache.MaxEntrySize)
+		stored = newEntry(res, nil, requestTime)
+		stored.ResponseTime = responseTime
+	}
+
+	res.Headers.Override("X-Cache", "MISS")
+	if err := copyResponse(w, req, res, capture); err != nil {
+		log.Printf("proxy: copying response for %s: %v", req.RequestLine.RequestTarget, err)
+		return
+	}
+	if stored != nil && !capture.overflow {
+		stored.Body = capture.Bytes()
+		p.Cache.Put(req, stored)
+	}
+}
+
+// revalidationRequest replaces any conditional headers from the client with
+// the validators of the stored entry.
+func revalidationRequest(req *request.Request, entry *cache.Entry) *request.Request {
+	out := req.Clone()
+	out.Headers.Remove("If-None-Match")
+	out.Headers.Remove("If-Modified-Since")
+	for k, v := range entry.Validators() {
+		out.Headers.Override(k, v)
+	}
+	return out
+}
+
+func newEntry(res *client.Response, body []byte, requestTime time.Time) *cache.Entry {
+	h := res.Headers.Clone()
+	removeHopHeaders(h)
+	h.Remove("Content-Length")
+	h.Remove("Trailer")
+	return &cache.Entry{
+		StatusCode:   res.StatusCode,
+		Headers:      h,
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: time.Now(),
+	}
+}
+
+func writeEntry(w *response.Writer, req *request.Request, entry *cache.Entry, cacheStatus string) {
+	h := entry.Headers.Clone()
+	h.Override("Age", strconv.FormatInt(int64(entry.Age(time.Now())/time.Second), 10))
+	h.Set("Via", "1.1 "+viaPseudonym)
+	h.Override("X-Cache", cacheStatus)
+	h.Override("Connection", "close")
+
+	w.WriteStatusLine(entry.StatusCode)
+	if !hasBody(req.RequestLine.Method, entry.StatusCode) {
+		w.WriteHeaders(h)
+		return
+	}
+	h.Override("Content-Length", strconv.Itoa(len(entry.Body)))
+	w.WriteHeaders(h)
+	w.WriteBody(entry.Body)
+}
+
+// captureBuffer collects a copy of a response body, giving up once it
+// grows past limit.
+type captureBuffer struct {
+	bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func newCaptureBuffer(limit int64) *captureBuffer {
+	return &captureBuffer{limit: limit}
+}
+
+func (c *captureBuffer) Write(p []byte) (int, error) {
+	if c.overflow {
+		return len(p), nil
+	}
+	if c.limit > 0 && int64(c.Len()+len(p)) > c.limit {
+		c.overflow = true
+		c.Reset()
+		return len(p), nil
+	}
+	return c.Buffer.Write(p)
+}
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/trace"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDialTimeout = 10 * time.Second
+
+var errDestinationNotAllowed = errors.New("destination not allowed")
+
+// ForwardProxy lets clients use the server as an HTTP proxy: absolute-form
+// requests are forwarded to the origin they name, and CONNECT opens a raw
+// TCP tunnel.
+type ForwardProxy struct {
+	// AllowedHosts restricts destinations to these hostnames or IPs. A
+	// leading "*." matches any subdomain. Empty allows every host.
+	AllowedHosts []string
+	// AllowedPorts restricts destination ports. Empty allows every port.
+	AllowedPorts []int
+	// Credentials maps usernames to passwords for Proxy-Authorization basic
+	// auth. Nil disables authentication.
+	Credentials map[string]string
+	Realm       string
+
+	Timeout time.Duration
+	Dialer  *net.Dialer
+	Client  *client.Client
+}
+
+func NewForwardProxy() *ForwardProxy {
+	c := client.New()
+	// redirects are for the client to follow, not the proxy
+	c.MaxRedirects = 0
+	return &ForwardProxy{
+		Realm:   "httpfromtcp",
+		Timeout: defaultTimeout,
+		Dialer:  &net.Dialer{Timeout: defaultDialTimeout},
+		Client:  c,
+	}
+}
+
+// Handle is a server.Handler.
+func (p *ForwardProxy) Handle(w *response.Writer, req *request.Request) {
+	if !p.authorized(req) {
+		body := []byte("407 Proxy Authentication Required\n")
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Proxy-Authenticate", fmt.Sprintf("Basic realm=%q", p.Realm))
+		w.WriteStatusLine(response.StatusCodeProxyAuthRequired)
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+		return
+	}
+
+	switch req.RequestLine.Form() {
+	case request.AuthorityForm:
+		p.tunnel(w, req)
+	case request.AbsoluteForm:
+		p.forward(w, req)
+	default:
+		problem.Error(w, req, response.StatusCodeBadRequest)
+	}
+}
+
+func (p *ForwardProxy) forward(w *response.Writer, req *request.Request) {
+	u, err := req.RequestLine.URL()
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		problem.Error(w, req, response.StatusCodeBadRequest)
+		return
+	}
+	if err := p.allowed(u.Hostname(), u.Port(), u.Scheme); err != nil {
+		log.Printf("proxy: %v", err)
+		problem.Error(w, req, response.StatusCodeForbidden)
+		return
+	}
+
+	out := req.Clone()
+	removeHopHeaders(out.Headers)
+	out.Headers.Override("Host", u.Host)
+	if ip := clientIP(req.RemoteAddr); ip != "" {
+		out.Headers.Set("X-Forwarded-For", ip)
+	}
+	out.Headers.Set("Via", "1.1 "+viaPseudonym)
+
+	ctx, cancel := context.WithTimeout(req.Context(), p.Timeout)
+	defer cancel()
+	ctx, span := trace.Start(ctx, "upstream", trace.KindClient)
+	defer span.Finish()
+	span.SetAttr("upstream", u.Host)
+	trace.Inject(out, span)
+	res, err := p.Client.Do(ctx, out)
+	if err != nil {
+		span.SetError(err)
+		log.Printf("proxy: forwarding to %s failed: %v", u, err)
+		writeUpstreamError(w, req, err)
+		return
+	}
+	defer res.Body.Close()
+	span.SetAttr("http.status_code", strconv.Itoa(int(res.StatusCode)))
+
+	if err := copyResponse(w, req, res, nil); err != nil {
+		log.Printf("proxy: copying response from %s: %v", u, err)
+	}
+}
+
+// tunnel implements CONNECT: once the destination is dialed the client gets
+// a 200 and bytes are copied both ways until either side closes.
+func (p *ForwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	host, port, _ := net.SplitHostPort(req.RequestLine.RequestTarget)
+	if err := p.allowed(host, port, ""); err != nil {
+		log.Printf("proxy: %v", err)
+		problem.Error(w, req, response.StatusCodeForbidden)
+		return
+	}
+
+	// the dial gives up when the client does, or after the timeout
+	ctx, cancel := context.WithTimeout(req.Context(), p.Timeout)
+	defer cancel()
+	dst, err := p.Dialer.DialContext(ctx, "tcp", req.RequestLine.RequestTarget)
+	if err != nil {
+		log.Printf("proxy: CONNECT to %s failed: %v", req.RequestLine.RequestTarget, err)
+		writeUpstreamError(w, req, err)
+		return
+	}
+	defer dst.Close()
+
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(headers.NewHeaders())
+	src, err := w.Hijack()
+	if err != nil {
+		log.Printf("proxy: CONNECT to %s: %v", req.RequestLine.RequestTarget, err)
+		return
+	}
+	defer src.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go pipe(&wg, dst, src)
+	go pipe(&wg, src, dst)
+	wg.Wait()
+}
+
+// pipe copies src to dst and then half-closes dst, so the peer sees EOF
+// while the other direction keeps flowing.
+func pipe(wg *sync.WaitGroup, dst, src net.Conn) {
+	defer wg.Done()
+	io.Copy(dst, src)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	dst.Close()
+}
+
+func (p *ForwardProxy) authorized(req *request.Request) bool {
+	if p.Credentials == nil {
+		return true
+	}
+	auth, ok := req.Headers.Get("Proxy-Authorization")
+	if !ok {
+		return false
+	}
+	scheme, encoded, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+	want, ok := p.Credentials[user]
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+func (p *ForwardProxy) allowed(host, port, scheme string) error {
+	if port == "" {
+		port = "80"
+		if scheme == "https" {
+			port = "443"
+		}
+	}
+	if len(p.AllowedPorts) > 0 {
+		n, err := strconv.Atoi(port)
+		if err != nil || !slices.Contains(p.AllowedPorts, n) {
+			return fmt.Errorf("%w: port %s", errDestinationNotAllowed, port)
+		}
+	}
+	if len(p.AllowedHosts) > 0 && !slices.ContainsFunc(p.AllowedHosts, func(pattern string) bool {
+		return matchHost(pattern, host)
+	}) {
+		return fmt.Errorf("%w: host %s", errDestinationNotAllowed, host)
+	}
+	return nil
+}
+
+func matchHost(pattern, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
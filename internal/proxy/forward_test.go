@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestForwardProxy(t *testing.T) {
+	// Test: Absolute-form requests go to the named origin
+	received := make(chan *request.Request, 1)
+	origin := upstream(t, "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\norigin", received)
+	p := NewForwardProxy()
+	res := proxyRequest(t, p.Handle, "GET "+origin+"/page?q=1 HTTP/1.1\r\n"+
+		"Host: ignored.example\r\n"+
+		"Proxy-Connection: keep-alive\r\n"+
+		"\r\n")
+	up := <-received
+	assert.Equal(t, "/page?q=1", up.RequestLine.RequestTarget)
+	assert.Equal(t, strings.TrimPrefix(origin, "http://"), up.Headers["host"])
+	assert.NotContains(t, up.Headers, "proxy-connection")
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "origin", string(body))
+
+	// Test: Origin-form requests are rejected
+	res = proxyRequest(t, p.Handle, "GET /page HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+	assert.Equal(t, response.StatusCodeBadRequest, res.StatusCode)
+
+	// Test: Destinations outside the allow-list are forbidden
+	p.AllowedHosts = []string{"*.example.com"}
+	res = proxyRequest(t, p.Handle, "GET "+origin+"/ HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, response.StatusCodeForbidden, res.StatusCode)
+	assert.True(t, matchHost("*.example.com", "api.example.com"))
+	assert.False(t, matchHost("*.example.com", "example.com"))
+	p.AllowedHosts = nil
+
+	// Test: Basic auth is required when credentials are configured
+	p.Credentials = map[string]string{"dev": "secret"}
+	res = proxyRequest(t, p.Handle, "GET "+origin+"/ HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, response.StatusCodeProxyAuthRequired, res.StatusCode)
+	assert.Equal(t, `Basic realm="httpfromtcp"`, res.Headers["proxy-authenticate"])
+	wrong := base64.StdEncoding.EncodeToString([]byte("dev:nope"))
+	res = proxyRequest(t, p.Handle, "GET "+origin+"/ HTTP/1.1\r\nHost: x\r\nProxy-Authorization: Basic "+wrong+"\r\n\r\n")
+	assert.Equal(t, response.StatusCodeProxyAuthRequired, res.StatusCode)
+	right := base64.StdEncoding.EncodeToString([]byte("dev:secret"))
+	res = proxyRequest(t, p.Handle, "GET "+origin+"/ HTTP/1.1\r\nHost: x\r\nProxy-Authorization: Basic "+right+"\r\n\r\n")
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	<-received
+}
+
+func TestForwardProxyConnect(t *testing.T) {
+	echo := echoServer(t)
+	p := NewForwardProxy()
+
+	// Test: CONNECT opens a tunnel that copies bytes both ways
+	clientConn, serverConn := net.Pipe()
+	req, err := request.RequestFromReader(strings.NewReader("CONNECT " + echo + " HTTP/1.1\r\nHost: " + echo + "\r\n\r\n"))
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		p.Handle(response.NewWriter(serverConn), req)
+		close(done)
+	}()
+
+	br := bufio.NewReader(clientConn)
+	res, err := client.ReadResponse(br, "CONNECT")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+
+	_, err = clientConn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(br, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	clientConn.Close()
+	<-done
+
+	// Test: A CONNECT dial that takes too long is a 504
+	p.Timeout = 20 * time.Millisecond
+	p.Dialer = &net.Dialer{ControlContext: func(ctx context.Context, _, _ string, _ syscall.RawConn) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	start := time.Now()
+	res = proxyRequest(t, p.Handle, "CONNECT "+echo+" HTTP/1.1\r\nHost: "+echo+"\r\n\r\n")
+	assert.Equal(t, response.StatusCodeGatewayTimeout, res.StatusCode)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Test: CONNECT to a disallowed port is forbidden
+	p.AllowedPorts = []int{443}
+	res = proxyRequest(t, p.Handle, "CONNECT "+echo+" HTTP/1.1\r\nHost: "+echo+"\r\n\r\n")
+	assert.Equal(t, response.StatusCodeForbidden, res.StatusCode)
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"httpfromtcp/internal/headers"
+	"net"
+	"strings"
+)
+
+// hopHeaders only apply to a single connection and are never forwarded
+// (RFC 9110 section 7.6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders deletes the hop-by-hop headers from h, including any
+// extra ones the sender listed in its Connection header.
+func removeHopHeaders(h headers.Headers) {
+	if v, ok := h.Get("Connection"); ok {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Remove(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Remove(name)
+	}
+}
+
+// clientIP returns the host part of a RemoteAddr, or remoteAddr itself
+// when it has no port, as a bare address does; it is "" only when
+// remoteAddr is.
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
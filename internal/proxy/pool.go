@@ -0,0 +1,371 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/request"
+	"log"
+	"math/rand/v2"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxFails            = 3
+	defaultEjectDuration       = 30 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	hashReplicas               = 100
+)
+
+var ErrNoHealthyBackend = errors.New("no healthy upstream backend")
+
+type Strategy int
+
+const (
+	RoundRobin Strategy = iota
+	LeastConnections
+	// ConsistentHash maps the key returned by Pool.HashKey onto a hash ring,
+	// so the same key keeps reaching the same backend while it is healthy.
+	ConsistentHash
+)
+
+// Backend is a single upstream origin in a Pool.
+type Backend struct {
+	URL    *url.URL
+	active atomic.Int64
+
+	mu        sync.Mutex
+	healthy   bool
+	fails     int
+	ejectedAt time.Time
+	// admittedAt is when the backend last became healthy, for slow start
+	admittedAt time.Time
+}
+
+func (b *Backend) Healthy() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.healthy
+}
+
+// ActiveConnections returns the number of requests currently in flight.
+func (b *Backend) ActiveConnections() int64 {
+	return b.active.Load()
+}
+
+// Pool spreads requests over several backends and keeps track of their
+// health, both actively by polling HealthCheckPath and passively by counting
+// consecutive failed requests.
+type Pool struct {
+	Strategy Strategy
+	// HashKey picks the key used by ConsistentHash. Defaults to HashByClientIP.
+	HashKey func(req *request.Request) string
+
+	// MaxFails consecutive failures eject a backend from rotation. Without
+	// active health checks it is re-admitted after EjectDuration.
+	MaxFails      int
+	EjectDuration time.Duration
+
+	// HealthCheckPath enables active health checks when set; a backend is
+	// healthy while GET HealthCheckPath answers with a 2xx or 3xx status.
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	// SlowStart ramps a re-admitted backend's share of traffic from zero to
+	// its full weight over this duration. It does not apply to ConsistentHash.
+	SlowStart time.Duration
+
+	Client *client.Client
+
+	backends []*Backend
+	ring     []ringPoint
+	next     atomic.Uint64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *Backend
+}
+
+func NewPool(strategy Strategy, upstreams ...string) (*Pool, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("pool needs at least one upstream")
+	}
+
+	p := &Pool{
+		Strategy:            strategy,
+		HashKey:             HashByClientIP,
+		MaxFails:            defaultMaxFails,
+		EjectDuration:       defaultEjectDuration,
+		HealthCheckInterval: defaultHealthCheckInterval,
+		HealthCheckTimeout:  defaultHealthCheckTimeout,
+		Client:              client.New(),
+		stop:                make(chan struct{}),
+	}
+	for _, upstream := range upstreams {
+		u, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		p.backends = append(p.backends, &Backend{URL: u, healthy: true})
+	}
+
+	for _, b := range p.backends {
+		for i := 0; i < hashReplicas; i++ {
+			p.ring = append(p.ring, ringPoint{hash: hashKey(b.URL.String() + "#" + strconv.Itoa(i)), backend: b})
+		}
+	}
+	slices.SortFunc(p.ring, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	return p, nil
+}
+
+func (p *Pool) Backends() []*Backend {
+	return p.backends
+}
+
+// Start runs active health checks in the background until Close is called.
+// It does nothing when HealthCheckPath is empty.
+func (p *Pool) Start() {
+	if p.HealthCheckPath == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			p.CheckHealth()
+			select {
+			case <-ticker.C:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (p *Pool) Close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// CheckHealth probes every backend once.
+func (p *Pool) CheckHealth() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.setHealth(b, p.probe(b))
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) probe(b *Backend) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.HealthCheckTimeout)
+	defer cancel()
+
+	target := b.URL.JoinPath(p.HealthCheckPath)
+	res, err := p.Client.Get(ctx, target.String())
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode >= 200 && res.StatusCode < 400
+}
+
+func (p *Pool) setHealth(b *Backend, healthy bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if healthy && !b.healthy {
+		log.Printf("proxy: backend %s is healthy again", b.URL)
+		b.admittedAt = time.Now()
+		b.fails = 0
+	}
+	if !healthy && b.healthy {
+		log.Printf("proxy: backend %s failed its health check", b.URL)
+		b.ejectedAt = time.Now()
+	}
+	b.healthy = healthy
+}
+
+// ReportSuccess resets the passive failure count of b.
+func (p *Pool) ReportSuccess(b *Backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails = 0
+}
+
+// ReportFailure records a failed request to b and ejects it once MaxFails
+// consecutive failures have been seen.
+func (p *Pool) ReportFailure(b *Backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails++
+	if b.healthy && p.MaxFails > 0 && b.fails >= p.MaxFails {
+		log.Printf("proxy: ejecting backend %s after %d consecutive failures", b.URL, b.fails)
+		b.healthy = false
+		b.ejectedAt = time.Now()
+	}
+}
+
+// Pick chooses a backend for req according to the pool's strategy.
+func (p *Pool) Pick(req *request.Request) (*Backend, error) {
+	now := time.Now()
+	if p.HealthCheckPath == "" {
+		p.readmitEjected(now)
+	}
+
+	switch p.Strategy {
+	case LeastConnections:
+		return p.pickLeastConnections(now)
+	case ConsistentHash:
+		return p.pickConsistentHash(req)
+	default:
+		return p.pickRoundRobin(now)
+	}
+}
+
+// Acquire counts a request as in flight on b until the returned func runs.
+func (p *Pool) Acquire(b *Backend) func() {
+	b.active.Add(1)
+	return func() { b.active.Add(-1) }
+}
+
+// readmitEjected gives passively ejected backends another chance once
+// EjectDuration has passed, since no health check will do it for them.
+func (p *Pool) readmitEjected(now time.Time) {
+	for _, b := range p.backends {
+		b.mu.Lock()
+		if !b.healthy && now.Sub(b.ejectedAt) >= p.EjectDuration {
+			b.healthy = true
+			b.fails = 0
+			b.admittedAt = now
+		}
+		b.mu.Unlock()
+	}
+}
+
+// weight is the share of traffic b should get, between 0 and 1, while it is
+// ramping up after being re-admitted.
+func (p *Pool) weight(b *Backend, now time.Time) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.healthy {
+		return 0
+	}
+	if p.SlowStart <= 0 || b.admittedAt.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(b.admittedAt)
+	if elapsed >= p.SlowStart {
+		return 1
+	}
+	// never drop all the way to zero so a lone backend still gets traffic
+	return max(float64(elapsed)/float64(p.SlowStart), 0.01)
+}
+
+func (p *Pool) pickRoundRobin(now time.Time) (*Backend, error) {
+	n := len(p.backends)
+	start := int(p.next.Add(1)-1) % n
+
+	var fallback *Backend
+	for i := 0; i < n; i++ {
+		b := p.backends[(start+i)%n]
+		w := p.weight(b, now)
+		if w == 0 {
+			continue
+		}
+		if fallback == nil {
+			fallback = b
+		}
+		if w >= 1 || rand.Float64() < w {
+			return b, nil
+		}
+	}
+	if fallback == nil {
+		return nil, ErrNoHealthyBackend
+	}
+	return fallback, nil
+}
+
+func (p *Pool) pickLeastConnections(now time.Time) (*Backend, error) {
+	var best *Backend
+	bestScore := 0.0
+	for _, b := range p.backends {
+		w := p.weight(b, now)
+		if w == 0 {
+			continue
+		}
+		score := float64(b.active.Load()+1) / w
+		if best == nil || score < bestScore {
+			best, bestScore = b, score
+		}
+	}
+	if best == nil {
+		return nil, ErrNoHealthyBackend
+	}
+	return best, nil
+}
+
+func (p *Pool) pickConsistentHash(req *request.Request) (*Backend, error) {
+	h := hashKey(p.HashKey(req))
+	start, _ := slices.BinarySearchFunc(p.ring, h, func(pt ringPoint, target uint32) int {
+		return cmp.Compare(pt.hash, target)
+	})
+
+	for i := 0; i < len(p.ring); i++ {
+		b := p.ring[(start+i)%len(p.ring)].backend
+		if b.Healthy() {
+			return b, nil
+		}
+	}
+	return nil, ErrNoHealthyBackend
+}
+
+// HashByHeader keys ConsistentHash on the value of the named request header.
+func HashByHeader(name string) func(req *request.Request) string {
+	return func(req *request.Request) string {
+		v, _ := req.Headers.Get(name)
+		return v
+	}
+}
+
+// HashByClientIP keys ConsistentHash on the client's IP address, as
+// resolved behind trusted proxies.
+func HashByClientIP(req *request.Request) string {
+	if req.ClientIP != "" {
+		return req.ClientIP
+	}
+	return clientIP(req.RemoteAddr)
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+func parseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", upstream, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("upstream must be an absolute http(s) URL, got %q", upstream)
+	}
+	return u, nil
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"httpfromtcp/internal/request"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backend is a stand-in origin whose health endpoint can be switched off.
+func backend(t *testing.T, name string, healthy *atomic.Bool) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					return
+				}
+				if req.RequestLine.RequestTarget == "/health" && !healthy.Load() {
+					io.WriteString(conn, "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n")
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: "+
+					strconv.Itoa(len(name))+"\r\n\r\n"+name)
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String()
+}
+
+func requestWithHeader(key, value string) *request.Request {
+	req, _ := request.NewBuilder("GET", "/").Header(key, value).Build()
+	req.RemoteAddr = "198.51.100.1:4000"
+	return req
+}
+
+func TestPoolStrategies(t *testing.T) {
+	// Test: Round robin cycles through every backend
+	pool, err := NewPool(RoundRobin, "http://a.test", "http://b.test", "http://c.test")
+	require.NoError(t, err)
+	seen := map[string]int{}
+	for i := 0; i < 9; i++ {
+		b, err := pool.Pick(requestWithHeader("X-User", "1"))
+		require.NoError(t, err)
+		seen[b.URL.Host]++
+	}
+	assert.Equal(t, map[string]int{"a.test": 3, "b.test": 3, "c.test": 3}, seen)
+
+	// Test: Least connections prefers the idle backend
+	pool, err = NewPool(LeastConnections, "http://a.test", "http://b.test")
+	require.NoError(t, err)
+	busy := pool.Backends()[0]
+	release := pool.Acquire(busy)
+	b, err := pool.Pick(requestWithHeader("X-User", "1"))
+	require.NoError(t, err)
+	assert.Equal(t, "b.test", b.URL.Host)
+	release()
+	assert.Equal(t, int64(0), busy.ActiveConnections())
+
+	// Test: Consistent hash is sticky per key and only remaps the lost backend's keys
+	pool, err = NewPool(ConsistentHash, "http://a.test", "http://b.test", "http://c.test")
+	require.NoError(t, err)
+	pool.HashKey = HashByHeader("X-User")
+	before := map[string]*Backend{}
+	for _, user := range []string{"alice", "bob", "carol", "dave", "erin", "frank"} {
+		b1, err := pool.Pick(requestWithHeader("X-User", user))
+		require.NoError(t, err)
+		b2, err := pool.Pick(requestWithHeader("X-User", user))
+		require.NoError(t, err)
+		assert.Same(t, b1, b2)
+		before[user] = b1
+	}
+	lost := before["alice"]
+	pool.setHealth(lost, false)
+	for user, prev := range before {
+		b, err := pool.Pick(requestWithHeader("X-User", user))
+		require.NoError(t, err)
+		assert.NotSame(t, lost, b)
+		if prev != lost {
+			assert.Same(t, prev, b)
+		}
+	}
+
+	// Test: Hashing by client IP
+	pool.HashKey = HashByClientIP
+	b1, err := pool.Pick(requestWithHeader("X-User", "x"))
+	require.NoError(t, err)
+	b2, err := pool.Pick(requestWithHeader("X-User", "y"))
+	require.NoError(t, err)
+	assert.Same(t, b1, b2)
+}
+
+func TestPoolHealth(t *testing.T) {
+	// Test: Passive ejection after consecutive failures and re-admission
+	pool, err := NewPool(RoundRobin, "http://a.test", "http://b.test")
+	require.NoError(t, err)
+	pool.MaxFails = 2
+	pool.EjectDuration = 50 * time.Millisecond
+	a := pool.Backends()[0]
+	pool.ReportFailure(a)
+	assert.True(t, a.Healthy())
+	pool.ReportFailure(a)
+	assert.False(t, a.Healthy())
+	for i := 0; i < 4; i++ {
+		b, err := pool.Pick(requestWithHeader("X-User", "1"))
+		require.NoError(t, err)
+		assert.Equal(t, "b.test", b.URL.Host)
+	}
+	time.Sleep(60 * time.Millisecond)
+	pool.Pick(requestWithHeader("X-User", "1"))
+	assert.True(t, a.Healthy())
+
+	// Test: A success resets the failure count
+	pool.ReportFailure(a)
+	pool.ReportSuccess(a)
+	pool.ReportFailure(a)
+	assert.True(t, a.Healthy())
+
+	// Test: Active health checks eject and re-admit with slow start
+	var aHealthy, bHealthy atomic.Bool
+	aHealthy.Store(true)
+	bHealthy.Store(true)
+	pool, err = NewPool(RoundRobin, backend(t, "a", &aHealthy), backend(t, "b", &bHealthy))
+	require.NoError(t, err)
+	pool.HealthCheckPath = "/health"
+	pool.SlowStart = time.Hour
+	a = pool.Backends()[0]
+	aHealthy.Store(false)
+	pool.CheckHealth()
+	assert.False(t, a.Healthy())
+	assert.Equal(t, 0.0, pool.weight(a, time.Now()))
+
+	aHealthy.Store(true)
+	pool.CheckHealth()
+	assert.True(t, a.Healthy())
+	assert.Less(t, pool.weight(a, time.Now()), 0.1)
+
+	// Test: No healthy backend
+	aHealthy.Store(false)
+	bHealthy.Store(false)
+	pool.CheckHealth()
+	_, err = pool.Pick(requestWithHeader("X-User", "1"))
+	require.ErrorIs(t, err, ErrNoHealthyBackend)
+}
+
+func TestPoolProxy(t *testing.T) {
+	// Test: Requests through the proxy are spread over the pool
+	var healthy atomic.Bool
+	healthy.Store(true)
+	pool, err := NewPool(RoundRobin, backend(t, "a", &healthy), backend(t, "b", &healthy))
+	require.NoError(t, err)
+	p := NewPoolProxy(pool)
+
+	bodies := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		res := proxyRequest(t, p.Handle, "GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		bodies[string(body)] = true
+	}
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, bodies)
+
+	// Test: An unreachable backend is passively ejected
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := "http://" + listener.Addr().String()
+	listener.Close()
+	pool, err = NewPool(RoundRobin, closed, backend(t, "b", &healthy))
+	require.NoError(t, err)
+	pool.MaxFails = 1
+	p = NewPoolProxy(pool)
+	res := proxyRequest(t, p.Handle, "GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+	assert.Equal(t, 502, int(res.StatusCode))
+	assert.False(t, pool.Backends()[0].Healthy())
+	res = proxyRequest(t, p.Handle, "GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+	assert.Equal(t, 200, int(res.StatusCode))
+}
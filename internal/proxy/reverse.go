@@ -0,0 +1,314 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/cache"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/trace"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	viaPseudonym   = "httpfromtcp"
+)
+
+var errInvalidTarget = errors.New("invalid request target")
+
+// ReverseProxy forwards requests to an upstream origin, or to a backend
+// picked from Pool, and streams the response back to the client.
+type ReverseProxy struct {
+	Upstream *url.URL
+	Pool     *Pool
+	// Cache, when set, stores upstream responses and serves them again
+	// while they are fresh.
+	Cache *cache.Cache
+	// StripPrefix is removed from the request path before it is appended to
+	// the upstream path.
+	StripPrefix string
+	// Timeout bounds the whole upstream exchange; when it is hit before the
+	// response headers arrive the client gets a 504.
+	Timeout time.Duration
+	Client  *client.Client
+	// ObserveUpstream, when set, is called after every upstream exchange
+	// with the upstream's host, the response status, or 0 when there was
+	// no response, and how long the response headers took to arrive.
+	ObserveUpstream func(upstream string, status int, d time.Duration)
+
+	revalidating sync.Map
+}
+
+func NewReverseProxy(upstream string) (*ReverseProxy, error) {
+	u, err := parseUpstream(upstream)
+	if err != nil {
+		return nil, err
+	}
+	return &ReverseProxy{
+		Upstream: u,
+		Timeout:  defaultTimeout,
+		Client:   client.New(),
+	}, nil
+}
+
+// NewPoolProxy returns a ReverseProxy that balances requests over pool.
+func NewPoolProxy(pool *Pool) *ReverseProxy {
+	return &ReverseProxy{
+		Pool:    pool,
+		Timeout: defaultTimeout,
+		Client:  client.New(),
+	}
+}
+
+// Handle is a server.Handler.
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	ctx, cancel := p.context(req.Context())
+	defer cancel()
+
+	if p.Cache != nil && cache.Cacheable(req) {
+		p.serveCached(ctx, w, req)
+		return
+	}
+
+	res, release, err := p.roundTrip(ctx, req)
+	if err != nil {
+		writeUpstreamError(w, req, err)
+		return
+	}
+	defer release()
+
+	if p.Cache != nil && !isSafe(req.RequestLine.Method) && res.StatusCode < 400 {
+		p.Cache.Invalidate(req)
+	}
+	if err := copyResponse(w, req, res, nil); err != nil {
+		log.Printf("proxy: copying response for %s: %v", req.RequestLine.RequestTarget, err)
+	}
+}
+
+// context bounds an upstream exchange by Timeout, and by parent, so it is
+// abandoned when the client goes away.
+func (p *ReverseProxy) context(parent context.Context) (context.Context, context.CancelFunc) {
+	if p.Timeout > 0 {
+		return context.WithTimeout(parent, p.Timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// roundTrip sends req to the upstream, or to a backend picked from Pool.
+// The returned release func closes the response body and must be called
+// once the response has been copied.
+func (p *ReverseProxy) roundTrip(ctx context.Context, req *request.Request) (*client.Response, func(), error) {
+	upstream := p.Upstream
+	var backend *Backend
+	release := func() {}
+	if p.Pool != nil {
+		var err error
+		backend, err = p.Pool.Pick(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		release = p.Pool.Acquire(backend)
+		upstream = backend.URL
+	}
+
+	target, err := p.targetURL(upstream, req.RequestLine.RequestTarget)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	ctx, span := trace.Start(ctx, "upstream", trace.KindClient)
+	span.SetAttr("upstream", upstream.Host)
+	out := outboundRequest(req, target)
+	trace.Inject(out, span)
+	start := time.Now()
+	res, err := p.Client.Do(ctx, out)
+	if p.ObserveUpstream != nil {
+		status := 0
+		if err == nil {
+			status = int(res.StatusCode)
+		}
+		p.ObserveUpstream(upstream.Host, status, time.Since(start))
+	}
+	if backend != nil {
+		if err != nil || res.StatusCode >= 502 && res.StatusCode <= 504 {
+			p.Pool.ReportFailure(backend)
+		} else {
+			p.Pool.ReportSuccess(backend)
+		}
+	}
+	if err != nil {
+		release()
+		span.SetError(err)
+		span.Finish()
+		log.Printf("proxy: upstream request to %s failed: %v", target, err)
+		return nil, nil, err
+	}
+	span.SetAttr("http.status_code", strconv.Itoa(int(res.StatusCode)))
+
+	// the span lasts until the body has been copied
+	return res, func() {
+		res.Body.Close()
+		release()
+		span.Finish()
+	}, nil
+}
+
+func (p *ReverseProxy) targetURL(upstream *url.URL, requestTarget string) (*url.URL, error) {
+	in, err := url.ParseRequestURI(requestTarget)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", errInvalidTarget, requestTarget)
+	}
+
+	path := strings.TrimPrefix(in.Path, p.StripPrefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	out := *upstream
+	out.Path = strings.TrimSuffix(upstream.Path, "/") + path
+	out.RawPath = ""
+	out.RawQuery = in.RawQuery
+	if upstream.RawQuery != "" && in.RawQuery != "" {
+		out.RawQuery = upstream.RawQuery + "&" + in.RawQuery
+	} else if upstream.RawQuery != "" {
+		out.RawQuery = upstream.RawQuery
+	}
+	return &out, nil
+}
+
+// outboundRequest copies req for the upstream, dropping hop-by-hop headers
+// and recording this hop in the X-Forwarded-* and Via headers.
+func outboundRequest(req *request.Request, target *url.URL) *request.Request {
+	out := req.Clone()
+	out.RequestLine.RequestTarget = target.String()
+	removeHopHeaders(out.Headers)
+
+	// the host and scheme are those the client used, as resolved by
+	// middleware.Forwarded behind trusted proxies
+	host := req.Host
+	if host == "" {
+		host, _ = req.Headers.Get("Host")
+	}
+	if host != "" {
+		out.Headers.Override("X-Forwarded-Host", host)
+	}
+	out.Headers.Override("Host", target.Host)
+	if ip := clientIP(req.RemoteAddr); ip != "" {
+		out.Headers.Set("X-Forwarded-For", ip)
+	}
+	proto := req.Scheme
+	if proto == "" {
+		proto = "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+	}
+	out.Headers.Override("X-Forwarded-Proto", proto)
+	out.Headers.Set("Via", "1.1 "+viaPseudonym)
+	return out
+}
+
+// copyResponse streams res to w. The body is always re-framed as chunked so
+// it never has to be buffered, and upstream trailers are passed along. When
+// capture is set the body is also copied into it.
+func copyResponse(w *response.Writer, req *request.Request, res *client.Response, capture *captureBuffer) error {
+	h := res.Headers.Clone()
+	removeHopHeaders(h)
+	h.Set("Via", "1.1 "+viaPseudonym)
+	h.Override("Connection", "close")
+	// cookies go out a line each, as they came
+	h.Remove("Set-Cookie")
+	for _, v := range res.SetCookies {
+		c, err := cookie.ParseSetCookie(v)
+		if err == nil {
+			err = w.SetCookie(c)
+		}
+		if err != nil {
+			log.Printf("proxy: dropping Set-Cookie from upstream: %v", err)
+		}
+	}
+
+	if err := w.WriteStatusLine(res.StatusCode); err != nil {
+		return err
+	}
+
+	if !hasBody(req.RequestLine.Method, res.StatusCode) {
+		return w.WriteHeaders(h)
+	}
+
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.WriteChunkedBody(buf[:n]); werr != nil {
+				return werr
+			}
+			if capture != nil {
+				capture.Write(buf[:n])
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return w.WriteTrailers(res.Trailers)
+}
+
+func hasBody(method string, status response.StatusCode) bool {
+	if method == "HEAD" {
+		return false
+	}
+	return !(status >= 100 && status < 200) && status != 204 && status != 304
+}
+
+func writeUpstreamError(w *response.Writer, req *request.Request, err error) {
+	switch {
+	case errors.Is(err, ErrNoHealthyBackend):
+		problem.Error(w, req, response.StatusCodeServiceUnavailable)
+	case errors.Is(err, errInvalidTarget):
+		problem.Error(w, req, response.StatusCodeBadRequest)
+	case errors.Is(err, context.DeadlineExceeded) || isTimeout(err):
+		problem.Error(w, req, response.StatusCodeGatewayTimeout)
+	default:
+		problem.Error(w, req, response.StatusCodeBadGateway)
+	}
+}
+
+func isSafe(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
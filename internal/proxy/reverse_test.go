@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"httpfromtcp/internal/cache"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/trace"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upstream is a stand-in origin that records the request it received and
+// replies with a canned response.
+func upstream(t *testing.T, reply string, received chan<- *request.Request) string {
+	return origin(t, func(req *request.Request) string {
+		if received != nil {
+			received <- req
+		}
+		return reply
+	})
+}
+
+// origin is a stand-in origin that answers each request with respond.
+func origin(t *testing.T, respond func(req *request.Request) string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					return
+				}
+				io.WriteString(conn, respond(req))
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String()
+}
+
+func proxyRequest(t *testing.T, handle func(*response.Writer, *request.Request), raw string) *client.Response {
+	req, err := request.RequestFromReader(bytes.NewBufferString(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "203.0.113.7:51234"
+
+	var out bytes.Buffer
+	handle(response.NewWriter(&out), req)
+	res, err := client.ReadResponse(bufio.NewReader(&out), req.RequestLine.Method)
+	require.NoError(t, err)
+	return res
+}
+
+func TestReverseProxy(t *testing.T) {
+	// Test: Method, headers and body are forwarded, hop-by-hop headers are not
+	received := make(chan *request.Request, 1)
+	origin := upstream(t, "HTTP/1.1 201 Created\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Content-Length: 11\r\n"+
+		"Keep-Alive: timeout=5\r\n"+
+		"X-Upstream: yes\r\n"+
+		"\r\n"+
+		`{"ok":true}`, received)
+	p, err := NewReverseProxy(origin + "/api")
+	require.NoError(t, err)
+	p.StripPrefix = "/httpbin"
+
+	res := proxyRequest(t, p.Handle, "POST /httpbin/items?x=1 HTTP/1.1\r\n"+
+		"Host: proxy.local\r\n"+
+		"Connection: close, X-Secret\r\n"+
+		"X-Secret: hop\r\n"+
+		"X-Custom: kept\r\n"+
+		"Content-Length: 5\r\n"+
+		"\r\n"+
+		"hello")
+
+	up := <-received
+	assert.Equal(t, "POST", up.RequestLine.Method)
+	assert.Equal(t, "/api/items?x=1", up.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(up.Body))
+	assert.Equal(t, "kept", up.Headers["x-custom"])
+	assert.NotContains(t, up.Headers, "x-secret")
+	assert.NotContains(t, up.Headers, "connection")
+	assert.Equal(t, "203.0.113.7", up.Headers["x-forwarded-for"])
+	assert.Equal(t, "proxy.local", up.Headers["x-forwarded-host"])
+	assert.Equal(t, "http", up.Headers["x-forwarded-proto"])
+	assert.Equal(t, "1.1 httpfromtcp", up.Headers["via"])
+
+	assert.Equal(t, 201, int(res.StatusCode))
+	assert.Equal(t, "application/json", res.Headers["content-type"])
+	assert.Equal(t, "yes", res.Headers["x-upstream"])
+	assert.NotContains(t, res.Headers, "keep-alive")
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"ok":true}`, string(body))
+
+	// Test: Each upstream cookie is sent on its own Set-Cookie line
+	p, err = NewReverseProxy(upstream(t, "HTTP/1.1 200 OK\r\n"+
+		"Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\n"+
+		"Set-Cookie: b=2; Path=/; HttpOnly\r\n"+
+		"Content-Length: 0\r\n"+
+		"\r\n", nil))
+	require.NoError(t, err)
+	res = proxyRequest(t, p.Handle, "GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2; Path=/; HttpOnly"}, res.SetCookies)
+
+	// Test: Requests that came over TLS are marked as such
+	tlsReq := up.Clone()
+	tlsReq.TLS = &tls.ConnectionState{}
+	target, _ := url.Parse(origin)
+	assert.Equal(t, "https", outboundRequest(tlsReq, target).Headers["x-forwarded-proto"])
+
+	// Test: Unreachable upstream is a 502
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := "http://" + listener.Addr().String()
+	listener.Close()
+	p, err = NewReverseProxy(closed)
+	require.NoError(t, err)
+	res = proxyRequest(t, p.Handle, "GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+	assert.Equal(t, response.StatusCodeBadGateway, res.StatusCode)
+
+	// Test: Slow upstream is a 504
+	// nobody receives from stall, so the origin never answers
+	stall := make(chan *request.Request)
+	p, err = NewReverseProxy(upstream(t, "", stall))
+	require.NoError(t, err)
+	p.Timeout = 50 * time.Millisecond
+	res = proxyRequest(t, p.Handle, "GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+	assert.Equal(t, response.StatusCodeGatewayTimeout, res.StatusCode)
+
+	// Test: The upstream request is abandoned once the client has gone
+	p.Timeout = 5 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := request.RequestFromReader(bytes.NewBufferString("GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n"))
+	require.NoError(t, err)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	p.Handle(response.NewWriter(io.Discard), req.WithContext(ctx))
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Test: Upstream exchanges are observed, failed ones with status 0
+	type observation struct {
+		host   string
+		status int
+	}
+	var observed []observation
+	observe := func(host string, status int, d time.Duration) {
+		assert.Positive(t, d)
+		observed = append(observed, observation{host, status})
+	}
+	p, err = NewReverseProxy(origin)
+	require.NoError(t, err)
+	p.ObserveUpstream = observe
+	proxyRequest(t, p.Handle, "GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+	<-received
+	p, err = NewReverseProxy(closed)
+	require.NoError(t, err)
+	p.ObserveUpstream = observe
+	proxyRequest(t, p.Handle, "GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+	assert.Equal(t, []observation{{target.Host, 201}, {strings.TrimPrefix(closed, "http://"), 0}}, observed)
+
+	// Test: The trace and request ID are carried to the upstream
+	var spans bytes.Buffer
+	tracer := trace.NewTracer(trace.NewFileExporter(&spans))
+	p, err = NewReverseProxy(origin)
+	require.NoError(t, err)
+	handler := tracer.Middleware()(p.Handle)
+	proxyRequest(t, handler, "GET / HTTP/1.1\r\nHost: proxy.local\r\nX-Request-ID: abc\r\n"+
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\ntracestate: rojo=1\r\n\r\n")
+	up = <-received
+	assert.Equal(t, "abc", up.Headers["x-request-id"])
+	assert.Equal(t, "rojo=1", up.Headers["tracestate"])
+	sc, err := trace.ParseTraceparent(up.Headers["traceparent"])
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Contains(t, spans.String(), `"span_id":"`+sc.SpanID.String()+`","parent_id":`)
+	assert.Contains(t, spans.String(), `"name":"upstream","kind":"client"`)
+
+	// Test: Invalid upstream configuration
+	_, err = NewReverseProxy("httpbin.org")
+	require.Error(t, err)
+}
+
+func TestReverseProxyCache(t *testing.T) {
+	var hits atomic.Int32
+	var conditional atomic.Int32
+	p, err := NewReverseProxy(origin(t, func(req *request.Request) string {
+		hits.Add(1)
+		if req.Headers["if-none-match"] == `"v1"` {
+			conditional.Add(1)
+			return "HTTP/1.1 304 Not Modified\r\nETag: \"v1\"\r\nCache-Control: max-age=0\r\n\r\n"
+		}
+		switch req.RequestLine.RequestTarget {
+		case "/fresh":
+			return "HTTP/1.1 200 OK\r\nCache-Control: max-age=60\r\nContent-Length: 5\r\n\r\nfresh"
+		case "/stale":
+			return "HTTP/1.1 200 OK\r\nCache-Control: max-age=0\r\nETag: \"v1\"\r\nContent-Length: 5\r\n\r\nstale"
+		}
+		return "HTTP/1.1 200 OK\r\nCache-Control: no-store\r\nContent-Length: 7\r\n\r\nprivate"
+	}))
+	require.NoError(t, err)
+	p.Cache = cache.New(cache.NewMemoryStore(1 << 20))
+
+	get := func(path string) (*client.Response, string) {
+		res := proxyRequest(t, p.Handle, "GET "+path+" HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(body)
+	}
+
+	// Test: A fresh response is served from the cache
+	res, body := get("/fresh")
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+	assert.Equal(t, "fresh", body)
+	res, body = get("/fresh")
+	assert.Equal(t, "HIT", res.Headers["x-cache"])
+	assert.Equal(t, "fresh", body)
+	assert.Contains(t, res.Headers, "age")
+	assert.Equal(t, int32(1), hits.Load())
+
+	// Test: A stale response is revalidated with its ETag
+	get("/stale")
+	res, body = get("/stale")
+	assert.Equal(t, "REVALIDATED", res.Headers["x-cache"])
+	assert.Equal(t, "stale", body)
+	assert.Equal(t, int32(1), conditional.Load())
+
+	// Test: no-store responses always go upstream
+	hits.Store(0)
+	get("/private")
+	res, _ = get("/private")
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+	assert.Equal(t, int32(2), hits.Load())
+
+	// Test: An unsafe request invalidates the stored response
+	proxyRequest(t, p.Handle, "POST /fresh HTTP/1.1\r\nHost: proxy.local\r\nContent-Length: 0\r\n\r\n")
+	res, _ = get("/fresh")
+	assert.Equal(t, "MISS", res.Headers["x-cache"])
+
+	// Test: Stale responses are served when the upstream is down, unless
+	// they must be revalidated
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := "http://" + listener.Addr().String()
+	listener.Close()
+	p, err = NewReverseProxy(closed)
+	require.NoError(t, err)
+	p.Cache = cache.New(cache.NewMemoryStore(1 << 20))
+	past := time.Now().Add(-time.Minute)
+	for directive, want := range map[string]response.StatusCode{
+		"":                 response.StatusCodeSuccess,
+		"must-revalidate":  response.StatusCodeGatewayTimeout,
+		"proxy-revalidate": response.StatusCodeGatewayTimeout,
+		"no-cache":         response.StatusCodeGatewayTimeout,
+		"s-maxage=1":       response.StatusCodeGatewayTimeout,
+	} {
+		path := "/" + directive
+		req, err := request.RequestFromReader(strings.NewReader("GET " + path + " HTTP/1.1\r\nHost: proxy.local\r\n\r\n"))
+		require.NoError(t, err)
+		h := map[string]string{"cache-control": strings.TrimSuffix("max-age=1, "+directive, ", "), "etag": `"v1"`}
+		p.Cache.Put(req, &cache.Entry{StatusCode: 200, Headers: h, Body: []byte("old"), RequestTime: past, ResponseTime: past})
+		res, _ = get(path)
+		assert.Equal(t, want, res.StatusCode, directive)
+	}
+}
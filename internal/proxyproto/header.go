@@ -0,0 +1,321 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Command tells whether a header relays a client connection, or was sent
+// by the balancer itself, as its health checks are.
+type Command byte
+
+const (
+	CommandLocal Command = 0x0
+	CommandProxy Command = 0x1
+)
+
+// TLV types (PROXY protocol specification section 2.2.7).
+const (
+	TypeALPN      byte = 0x01
+	TypeAuthority byte = 0x02
+	TypeCRC32C    byte = 0x03
+	TypeNoop      byte = 0x04
+	TypeUniqueID  byte = 0x05
+	TypeSSL       byte = 0x20
+	TypeNetNS     byte = 0x30
+
+	// sub-types found inside the TypeSSL extension
+	TypeSSLVersion byte = 0x21
+	TypeSSLCN      byte = 0x22
+	TypeSSLCipher  byte = 0x23
+	TypeSSLSigAlg  byte = 0x24
+	TypeSSLKeyAlg  byte = 0x25
+)
+
+var (
+	ErrNoHeader      = errors.New("proxyproto: connection did not start with a PROXY header")
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY header")
+)
+
+// v2Signature starts every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Length is the longest version 1 header, CRLF included.
+const maxV1Length = 107
+
+// Header is a PROXY protocol header, which a load balancer sends at the
+// start of a connection to pass on the addresses of the client's.
+type Header struct {
+	Version int
+	Command Command
+	// Source and Destination are the client's address and the address
+	// it connected to. They are nil for local connections and for
+	// protocols the header does not describe.
+	Source      net.Addr
+	Destination net.Addr
+	// TLVs are the extensions of a version 2 header, in order.
+	TLVs []TLV
+}
+
+// TLV is a type-length-value extension of a version 2 header.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// TLV returns the value of the first extension of type typ.
+func (h *Header) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// SSL describes the TLS connection a balancer terminated for the client.
+type SSL struct {
+	// ClientSSL is set when the client connected over TLS, ClientCert
+	// when it presented a certificate, and Verified when the balancer
+	// verified that certificate.
+	ClientSSL  bool
+	ClientCert bool
+	Verified   bool
+	// TLVs hold details such as TypeSSLVersion and TypeSSLCipher.
+	TLVs []TLV
+}
+
+// SSL returns the contents of the TypeSSL extension.
+func (h *Header) SSL() (*SSL, bool) {
+	v, ok := h.TLV(TypeSSL)
+	if !ok || len(v) < 5 {
+		return nil, false
+	}
+	ssl := &SSL{
+		ClientSSL:  v[0]&0x01 != 0,
+		ClientCert: v[0]&0x06 != 0,
+		// zero means verified
+		Verified: binary.BigEndian.Uint32(v[1:5]) == 0,
+	}
+	var sub Header
+	if parseTLVs(&sub, v[5:]) != nil {
+		return nil, false
+	}
+	ssl.TLVs = sub.TLVs
+	return ssl, true
+}
+
+// TLV returns the value of the first sub-extension of type typ.
+func (s *SSL) TLV(typ byte) ([]byte, bool) {
+	h := Header{TLVs: s.TLVs}
+	return h.TLV(typ)
+}
+
+// ReadHeader reads a version 1 or 2 header from the start of r. It
+// returns ErrNoHeader when r starts with something else, in which case
+// nothing is consumed.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	// peek a byte at a time, so data that cannot be a header is told
+	// apart without waiting for more of it
+	v1Prefix := []byte("PROXY ")
+	for n := 1; n <= len(v2Signature); n++ {
+		start, err := r.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case bytes.Equal(start, v2Signature):
+			return readV2(r)
+		case bytes.Equal(start, v1Prefix):
+			return readV1(r)
+		case !bytes.HasPrefix(v2Signature, start) && !bytes.HasPrefix(v1Prefix, start):
+			return nil, ErrNoHeader
+		}
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 reads a header such as
+// "PROXY TCP4 203.0.113.7 192.0.2.1 51234 443\r\n".
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < maxV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, fmt.Errorf("%w: line not ended by CRLF", ErrInvalidHeader)
+	}
+
+	h := &Header{Version: 1, Command: CommandProxy}
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		// the balancer could not tell, and the rest of the line is ignored
+		return h, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, text)
+	}
+	src, err := parseV1Addr(fields[2], fields[4], fields[1] == "TCP6")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5], fields[1] == "TCP6")
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseV1Addr(ip, port string, v6 bool) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is6() != v6 || addr.Zone() != "" {
+		return nil, fmt.Errorf("%w: address %q", ErrInvalidHeader, ip)
+	}
+	// ports are plain decimal, without sign or leading zeros
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || port != strconv.FormatUint(n, 10) {
+		return nil, fmt.Errorf("%w: port %q", ErrInvalidHeader, port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(n))), nil
+}
+
+// Address families and transports of version 2 headers.
+const (
+	familyUnspec = 0x0
+	familyInet   = 0x1
+	familyInet6  = 0x2
+	familyUnix   = 0x3
+
+	transportStream = 0x1
+	transportDgram  = 0x2
+)
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidHeader, fixed[12]>>4)
+	}
+	h := &Header{Version: 2, Command: Command(fixed[12] & 0x0f)}
+	if h.Command != CommandLocal && h.Command != CommandProxy {
+		return nil, fmt.Errorf("%w: command %d", ErrInvalidHeader, h.Command)
+	}
+	family, transport := fixed[13]>>4, fixed[13]&0x0f
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	addrLen := 0
+	switch family {
+	case familyInet:
+		addrLen = 12
+	case familyInet6:
+		addrLen = 36
+	case familyUnix:
+		addrLen = 216
+	}
+	if len(payload) < addrLen {
+		return nil, fmt.Errorf("%w: addresses truncated", ErrInvalidHeader)
+	}
+	if err := parseTLVs(h, payload[addrLen:]); err != nil {
+		return nil, err
+	}
+	if err := checkCRC(h, fixed[:], payload); err != nil {
+		return nil, err
+	}
+	// a local connection's addresses, if any, are to be ignored
+	if h.Command == CommandLocal {
+		return h, nil
+	}
+
+	addrs := payload[:addrLen]
+	switch family {
+	case familyInet, familyInet6:
+		ipLen := addrLen/2 - 2
+		src, _ := netip.AddrFromSlice(addrs[:ipLen])
+		dst, _ := netip.AddrFromSlice(addrs[ipLen : 2*ipLen])
+		srcPort := binary.BigEndian.Uint16(addrs[2*ipLen:])
+		dstPort := binary.BigEndian.Uint16(addrs[2*ipLen+2:])
+		h.Source, h.Destination = inetAddr(transport, src, srcPort), inetAddr(transport, dst, dstPort)
+	case familyUnix:
+		network := "unix"
+		if transport == transportDgram {
+			network = "unixgram"
+		}
+		h.Source = &net.UnixAddr{Name: unixPath(addrs[:108]), Net: network}
+		h.Destination = &net.UnixAddr{Name: unixPath(addrs[108:]), Net: network}
+	}
+	return h, nil
+}
+
+func inetAddr(transport byte, ip netip.Addr, port uint16) net.Addr {
+	ap := netip.AddrPortFrom(ip.Unmap(), port)
+	if transport == transportDgram {
+		return net.UDPAddrFromAddrPort(ap)
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func parseTLVs(h *Header, b []byte) error {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return fmt.Errorf("%w: TLV truncated", ErrInvalidHeader)
+		}
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return fmt.Errorf("%w: TLV %#x truncated", ErrInvalidHeader, b[0])
+		}
+		h.TLVs = append(h.TLVs, TLV{Type: b[0], Value: b[3 : 3+n]})
+		b = b[3+n:]
+	}
+	return nil
+}
+
+// checkCRC verifies the CRC32C extension, which covers the whole header
+// with the checksum itself zeroed.
+func checkCRC(h *Header, fixed, payload []byte) error {
+	want, ok := h.TLV(TypeCRC32C)
+	if !ok {
+		return nil
+	}
+	if len(want) != 4 {
+		return fmt.Errorf("%w: CRC32C of %d bytes", ErrInvalidHeader, len(want))
+	}
+	sum := binary.BigEndian.Uint32(want)
+	clear(want)
+	table := crc32.MakeTable(crc32.Castagnoli)
+	got := crc32.Update(crc32.Checksum(fixed, table), table, payload)
+	binary.BigEndian.PutUint32(want, sum)
+	if got != sum {
+		return fmt.Errorf("%w: CRC32C mismatch", ErrInvalidHeader)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package proxyproto
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout bounds how long a balancer may take to send the
+// header once it connected.
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Listener accepts connections from load balancers that start with a
+// PROXY header, and reports the client address the header carries as the
+// connection's RemoteAddr.
+type Listener struct {
+	net.Listener
+	// Trusted lists the networks of the balancers. Connections from them
+	// must start with a header, and those from anywhere else are served
+	// as they are, so clients cannot claim to be someone else by sending
+	// a header of their own.
+	Trusted []netip.Prefix
+	// HeaderTimeout is DefaultHeaderTimeout unless set.
+	HeaderTimeout time.Duration
+}
+
+func NewListener(l net.Listener, trusted []netip.Prefix) *Listener {
+	return &Listener{Listener: l, Trusted: trusted, HeaderTimeout: DefaultHeaderTimeout}
+}
+
+// Accept returns the next connection, as a *Conn when it comes from a
+// trusted balancer. The header is read on first use, so a slow balancer
+// does not hold up other connections.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	return &Conn{Conn: c, br: bufio.NewReader(c), timeout: l.HeaderTimeout}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcpAddr.AddrPort().Addr().Unmap()
+	for _, p := range l.Trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a balancer, read past its PROXY header.
+type Conn struct {
+	net.Conn
+	br      *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// Header returns the header the connection started with, reading it if
+// that has not happened yet. It fails with ErrNoHeader if there was none.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.header, c.err = ReadHeader(c.br)
+	})
+	return c.header, c.err
+}
+
+// Read reads what the client sent after the header. Every read fails if
+// the header was missing or invalid.
+func (c *Conn) Read(p []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.br.Read(p)
+}
+
+// RemoteAddr returns the client's address from the header, or the
+// balancer's address when the header has none, as for health checks.
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ReadFrom keeps the kernel copy (sendfile) of the underlying connection
+// available.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(c.Conn, r)
+}
+
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
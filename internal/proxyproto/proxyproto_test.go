@@ -0,0 +1,182 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// v2 encodes a version 2 header around addrs and tlvs. A CRC32C
+// extension, if present, is filled in.
+func v2(command, family byte, addrs []byte, tlvs ...TLV) []byte {
+	var payload []byte
+	payload = append(payload, addrs...)
+	crcAt := -1
+	for _, tlv := range tlvs {
+		payload = append(payload, tlv.Type)
+		payload = binary.BigEndian.AppendUint16(payload, uint16(len(tlv.Value)))
+		if tlv.Type == TypeCRC32C {
+			crcAt = len(payload)
+		}
+		payload = append(payload, tlv.Value...)
+	}
+	b := append([]byte(nil), v2Signature...)
+	b = append(b, 0x20|command, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	b = append(b, payload...)
+	if crcAt >= 0 {
+		at := len(b) - len(payload) + crcAt
+		binary.BigEndian.PutUint32(b[at:], crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli)))
+	}
+	return b
+}
+
+func read(raw string) (*Header, string, error) {
+	br := bufio.NewReader(strings.NewReader(raw))
+	h, err := ReadHeader(br)
+	rest, _ := io.ReadAll(br)
+	return h, string(rest), err
+}
+
+func TestReadHeader(t *testing.T) {
+	// Test: Version 1 headers
+	h, rest, err := read("PROXY TCP4 203.0.113.7 192.0.2.1 51234 443\r\nGET / HTTP/1.1\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 1, h.Version)
+	assert.Equal(t, "203.0.113.7:51234", h.Source.String())
+	assert.Equal(t, "192.0.2.1:443", h.Destination.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+	h, _, err = read("PROXY TCP6 2001:db8::1 2001:db8::2 1 65535\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:1", h.Source.String())
+
+	h, _, err = read("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")
+	require.NoError(t, err)
+	assert.Nil(t, h.Source)
+
+	for _, bad := range []string{
+		"PROXY TCP4 203.0.113.7 192.0.2.1 51234\r\n",
+		"PROXY TCP4 2001:db8::1 192.0.2.1 1 2\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.1 051234 443\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.1 65536 443\r\n",
+		"PROXY UDP4 203.0.113.7 192.0.2.1 1 2\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.1 51234 443\n",
+		"PROXY " + strings.Repeat("x", 120) + "\r\n",
+	} {
+		_, _, err = read(bad)
+		assert.ErrorIs(t, err, ErrInvalidHeader, bad)
+	}
+
+	// Test: Version 2 headers with extensions
+	addrs := []byte{203, 0, 113, 7, 192, 0, 2, 1, 0xc8, 0x22, 0x01, 0xbb}
+	ssl := []byte{0x01, 0, 0, 0, 0, TypeSSLVersion, 0, 7, 'T', 'L', 'S', 'v', '1', '.', '3'}
+	raw := v2(byte(CommandProxy), familyInet<<4|transportStream, addrs,
+		TLV{Type: TypeAuthority, Value: []byte("example.com")},
+		TLV{Type: TypeSSL, Value: ssl},
+		TLV{Type: TypeCRC32C, Value: make([]byte, 4)},
+	)
+	h, rest, err = read(string(raw) + "GET")
+	require.NoError(t, err)
+	assert.Equal(t, 2, h.Version)
+	assert.Equal(t, CommandProxy, h.Command)
+	assert.Equal(t, &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7).To4(), Port: 51234}, h.Source)
+	assert.Equal(t, "192.0.2.1:443", h.Destination.String())
+	authority, ok := h.TLV(TypeAuthority)
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", string(authority))
+	info, ok := h.SSL()
+	require.True(t, ok)
+	assert.True(t, info.ClientSSL)
+	assert.True(t, info.Verified)
+	version, _ := info.TLV(TypeSSLVersion)
+	assert.Equal(t, "TLSv1.3", string(version))
+	assert.Equal(t, "GET", rest)
+
+	// Test: A corrupted header fails its checksum
+	raw[len(raw)-20] ^= 0xff
+	_, _, err = read(string(raw))
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	v6 := make([]byte, 36)
+	v6[0], v6[1], v6[15] = 0x20, 0x01, 0x01
+	binary.BigEndian.PutUint16(v6[32:], 8080)
+	h, _, err = read(string(v2(byte(CommandProxy), familyInet6<<4|transportDgram, v6)))
+	require.NoError(t, err)
+	assert.Equal(t, "[2001::1]:8080", h.Source.String())
+	assert.Equal(t, "udp", h.Source.Network())
+
+	unix := make([]byte, 216)
+	copy(unix, "/run/client.sock")
+	h, _, err = read(string(v2(byte(CommandProxy), familyUnix<<4|transportStream, unix)))
+	require.NoError(t, err)
+	assert.Equal(t, "/run/client.sock", h.Source.String())
+
+	// Test: Health checks send a local header whose addresses are ignored
+	h, _, err = read(string(v2(byte(CommandLocal), familyInet<<4|transportStream, addrs)))
+	require.NoError(t, err)
+	assert.Equal(t, CommandLocal, h.Command)
+	assert.Nil(t, h.Source)
+
+	// Test: Malformed version 2 headers
+	_, _, err = read(string(v2(byte(CommandProxy), familyInet<<4|transportStream, addrs[:8])))
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+	_, _, err = read(string(v2(0x2, familyInet<<4|transportStream, addrs)))
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+	_, _, err = read(string(v2(byte(CommandProxy), familyInet<<4|transportStream, append(addrs, TypeNoop, 0, 9))))
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	// Test: Anything else is left unread
+	_, rest, err = read("GET / HTTP/1.1\r\n\r\n")
+	assert.ErrorIs(t, err, ErrNoHeader)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", rest)
+}
+
+func TestListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l := NewListener(inner, []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")})
+	defer l.Close()
+	l.HeaderTimeout = 100 * time.Millisecond
+
+	accept := func(raw string) (net.Conn, string, error) {
+		client, err := net.Dial("tcp", inner.Addr().String())
+		require.NoError(t, err)
+		defer client.Close()
+		client.Write([]byte(raw))
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+		p := make([]byte, 5)
+		_, err = io.ReadFull(conn, p)
+		return conn, string(p), err
+	}
+
+	// Test: A trusted balancer's header gives the client address
+	conn, data, err := accept("PROXY TCP4 203.0.113.7 192.0.2.1 51234 443\r\nhello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", data)
+	assert.Equal(t, "203.0.113.7:51234", conn.RemoteAddr().String())
+
+	// Test: A trusted balancer must send a header
+	_, _, err = accept("hello")
+	assert.ErrorIs(t, err, ErrNoHeader)
+	_, _, err = accept("")
+	assert.Error(t, err)
+
+	// Test: Untrusted peers cannot claim another address
+	l.Trusted = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	conn, data, err = accept("PROXY TCP4 203.0.113.7 192.0.2.1 51234 443\r\nhello")
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY", data)
+	assert.Contains(t, conn.RemoteAddr().String(), "127.0.0.1:")
+}
@@ -0,0 +1,78 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"strings"
+)
+
+// Builder assembles a Request for sending with WriteTo or the client.
+//
+//	req, err := request.NewBuilder("POST", "http://localhost:42069/submit").
+//		Header("Content-Type", "application/json").
+//		Body([]byte(`{"ok":true}`)).
+//		Build()
+type Builder struct {
+	req  *Request
+	errs []error
+}
+
+func NewBuilder(method, target string) *Builder {
+	return &Builder{
+		req: &Request{
+			RequestLine: RequestLine{
+				HttpVersion:   "1.1",
+				RequestTarget: target,
+				Method:        method,
+			},
+			Headers: headers.NewHeaders(),
+			Body:    make([]byte, 0),
+			State:   requestStateDone,
+		},
+	}
+}
+
+// Header adds a header, comma-joining it with any earlier value for key.
+func (b *Builder) Header(key, value string) *Builder {
+	if !headers.ValidName(key) {
+		b.errs = append(b.errs, fmt.Errorf("invalid header name: %q", key))
+		return b
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		b.errs = append(b.errs, fmt.Errorf("invalid value for header %s", key))
+		return b
+	}
+	b.req.Headers.Set(key, value)
+	return b
+}
+
+func (b *Builder) Body(body []byte) *Builder {
+	b.req.Body = body
+	b.req.BodyReader = nil
+	return b
+}
+
+// BodyReader streams the body from r. Readers that report their size with a
+// Len method are sent with Content-Length, others are sent chunked unless a
+// Content-Length header is set.
+func (b *Builder) BodyReader(r io.Reader) *Builder {
+	b.req.Body = make([]byte, 0)
+	b.req.BodyReader = r
+	return b
+}
+
+func (b *Builder) Build() (*Request, error) {
+	line := b.req.RequestLine
+	if line.Method == "" || strings.ToUpper(line.Method) != line.Method || !headers.ValidName(line.Method) {
+		b.errs = append(b.errs, fmt.Errorf("invalid method: %q", line.Method))
+	}
+	if line.RequestTarget == "" || strings.ContainsAny(line.RequestTarget, " \t\r\n") {
+		b.errs = append(b.errs, fmt.Errorf("invalid request target: %q", line.RequestTarget))
+	}
+	if len(b.errs) > 0 {
+		return nil, errors.Join(b.errs...)
+	}
+	return b.req, nil
+}
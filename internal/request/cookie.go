@@ -0,0 +1,24 @@
+package request
+
+import (
+	"httpfromtcp/internal/cookie"
+)
+
+// Cookies parses the cookies sent in the Cookie header.
+func (r *Request) Cookies() []*cookie.Cookie {
+	v, ok := r.Headers.Get("Cookie")
+	if !ok {
+		return nil
+	}
+	return cookie.Parse(v)
+}
+
+// Cookie returns the first cookie called name.
+func (r *Request) Cookie(name string) (*cookie.Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
@@ -2,12 +2,15 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const bufferSize = 8
@@ -25,46 +28,189 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
-	State       ParserState
+	// BodyReader, when set, is sent by WriteTo instead of Body. It can only
+	// be read once.
+	BodyReader io.Reader
+	State      ParserState
+	// RemoteAddr is the network address of the client, set by the server.
+	RemoteAddr string
+	// LocalAddr is the address of the server the request arrived on.
+	LocalAddr string
+	// TLS is the state of the connection when it is TLS, otherwise nil.
+	TLS *tls.ConnectionState
+	// ConnID tells apart the connections a server accepted, so log lines
+	// of the same connection can be matched up.
+	ConnID uint64
+	// ClientIP, Scheme and Host are the client's address and the scheme
+	// and host it asked for. The server sets them from the connection and
+	// the Host header; middleware.Forwarded replaces them with what
+	// trusted proxies in front report.
+	ClientIP string
+	Scheme   string
+	Host     string
+	// ContentEncoding is the Content-Encoding the body arrived with, kept
+	// when middleware decoded the body and removed the header.
+	ContentEncoding string
+	// ReadStart and ReadDone are when the server started reading the
+	// request, on accepting its connection, and when it had all of it.
+	ReadStart time.Time
+	ReadDone  time.Time
+
+	ctx context.Context
+	// maxBodySize bounds the Content-Length accepted while parsing, if set.
+	maxBodySize int64
 }
 
+// ErrBodyTooLarge is returned by ReadRequestLimit when the Content-Length
+// is over the limit, before any of the body is read.
+var ErrBodyTooLarge = errors.New("request: body too large")
+
+// Kinds of ParseError.
+const (
+	ParseErrorIncomplete  = "incomplete"
+	ParseErrorRead        = "read"
+	ParseErrorRequestLine = "request_line"
+	ParseErrorHeaders     = "headers"
+	ParseErrorBody        = "body"
+	ParseErrorTooLarge    = "too_large"
+)
+
+// ParseError is returned by ReadRequest. Kind tells what went wrong: the
+// connection ended mid-request, reading failed (a timeout, say), or the
+// request line, headers or body were malformed, or the body was too large.
+type ParseError struct {
+	Kind string
+	Err  error
+}
+
+func (e *ParseError) Error() string { return e.Err.Error() }
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
 }
 
+// Clone returns a copy of r with its own headers. The body is shared.
+func (r *Request) Clone() *Request {
+	c := *r
+	c.Headers = r.Headers.Clone()
+	return &c
+}
+
+// Context returns the request's context, which carries values that
+// middleware attach for handlers further down the chain. The server
+// cancels it when the client disconnects, the server is closed or the
+// request timeout passes, so long-running handlers know to stop.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a copy of r with its context replaced by ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	c := r.Clone()
+	c.ctx = ctx
+	return c
+}
+
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize, bufferSize)
-	readToIndex := 0
+	req, _, err := ReadRequest(reader)
+	return req, err
+}
+
+// ReadRequest reads a request like RequestFromReader, and also returns the
+// bytes it read past the end of the request, which belong to whatever the
+// client sent next.
+func ReadRequest(reader io.Reader) (*Request, []byte, error) {
+	return ReadRequestLimit(reader, 0)
+}
+
+// ReadRequestLimit reads a request like ReadRequest, but refuses with
+// ErrBodyTooLarge one whose Content-Length is over maxBodySize bytes, so
+// the body is never buffered. A maxBodySize of 0 means no limit.
+func ReadRequestLimit(reader io.Reader, maxBodySize int64) (*Request, []byte, error) {
+	req, buffered, err := ReadRequestHead(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	buffered, err = req.ReadBody(reader, buffered, maxBodySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return req, buffered, nil
+}
+
+// ReadRequestHead reads the request line and headers of a request, and
+// returns the bytes it read past them. The body is left to ReadBody or
+// StreamBody.
+func ReadRequestHead(reader io.Reader) (*Request, []byte, error) {
 	req := &Request{
 		State:   initalized,
 		Headers: headers.NewHeaders(),
 		Body:    make([]byte, 0),
 	}
+	buffered, err := req.readUntil(reader, nil, requestStateParsingBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	return req, buffered, nil
+}
 
-	for {
-		if readToIndex >= len(buf) {
-			newBuff := make([]byte, len(buf)*2)
-			copy(newBuff, buf)
-			buf = newBuff
-		}
+// ReadBody reads the body of a request from ReadRequestHead into Body, from
+// buffered and then reader, and returns the bytes read past it. Bodies
+// over maxBodySize bytes are refused as in ReadRequestLimit.
+func (r *Request) ReadBody(reader io.Reader, buffered []byte, maxBodySize int64) ([]byte, error) {
+	r.maxBodySize = maxBodySize
+	return r.readUntil(reader, buffered, requestStateDone)
+}
 
-		n, err := reader.Read(buf[readToIndex:])
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				if req.State != requestStateDone {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", req.State, n)
-				}
-				break
-			}
-			return nil, err
-		}
-		readToIndex += n
+// StreamBody makes BodyReader read the body of a request from
+// ReadRequestHead from rest, which continues where the headers ended,
+// instead of reading it into Body. A body cut short is reported as
+// io.ErrUnexpectedEOF. The Content-Length is taken to have been checked
+// with ContentLength.
+func (r *Request) StreamBody(rest io.Reader) {
+	n, _ := r.ContentLength()
+	r.BodyReader = &bodyReader{r: rest, remaining: n}
+	r.State = requestStateDone
+}
 
-		parsed, err := req.parse(buf[:readToIndex])
+// ContentLength returns the length of the body the headers announce, 0
+// without a Content-Length.
+func (r *Request) ContentLength() (int64, error) {
+	v, ok := r.Headers.Get("Content-Length")
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Length: %v", err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("invalid Content-Length: %d", n)
+	}
+	return n, nil
+}
+
+// readUntil parses buffered and then what it reads from reader until the
+// request reaches state until, and returns what is left over.
+func (r *Request) readUntil(reader io.Reader, buffered []byte, until ParserState) ([]byte, error) {
+	buf := make([]byte, max(bufferSize, 2*len(buffered)))
+	readToIndex := copy(buf, buffered)
+
+	var readErr error
+	for {
+		parsed, err := r.parse(buf[:readToIndex], until)
+		if errors.Is(err, ErrBodyTooLarge) {
+			return nil, &ParseError{ParseErrorTooLarge, err}
+		}
 		if err != nil {
-			return nil, err
+			return nil, &ParseError{r.State.errorKind(), err}
 		}
 
 		if parsed > 0 {
@@ -72,17 +218,42 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 			readToIndex -= parsed
 		}
 
-		if req.State == requestStateDone {
-			break
+		if r.State >= until {
+			return buf[:readToIndex], nil
 		}
+		if readErr != nil {
+			if errors.Is(readErr, io.EOF) {
+				return nil, &ParseError{ParseErrorIncomplete, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", r.State, readToIndex)}
+			}
+			return nil, &ParseError{ParseErrorRead, readErr}
+		}
+
+		if readToIndex >= len(buf) {
+			newBuff := make([]byte, len(buf)*2)
+			copy(newBuff, buf)
+			buf = newBuff
+		}
+
+		var n int
+		n, readErr = reader.Read(buf[readToIndex:])
+		readToIndex += n
 	}
+}
 
-	return req, nil
+// errorKind is the kind of a ParseError raised in state s.
+func (s ParserState) errorKind() string {
+	switch s {
+	case initalized:
+		return ParseErrorRequestLine
+	case requestStateParsingHeaders:
+		return ParseErrorHeaders
+	}
+	return ParseErrorBody
 }
 
-func (r *Request) parse(data []byte) (int, error) {
+func (r *Request) parse(data []byte, until ParserState) (int, error) {
 	totalBytesParsed := 0
-	for r.State != requestStateDone {
+	for r.State < until {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return 0, err
@@ -124,29 +295,26 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		return n, nil
 
 	case requestStateParsingBody:
-		contentLengthStr, ok := r.Headers.Get("Content-Length")
-		if !ok {
+		if _, ok := r.Headers.Get("Content-Length"); !ok {
 			r.State = requestStateDone
-			return len(data), nil
+			return 0, nil
 		}
 
-		contentLength, err := strconv.Atoi(contentLengthStr)
+		contentLength, err := r.ContentLength()
 		if err != nil {
-			return 0, fmt.Errorf("invalid Content-Length: %v", err)
+			return 0, err
 		}
-
-		if len(data) < contentLength {
-			return 0, nil
+		if r.maxBodySize > 0 && contentLength > r.maxBodySize {
+			return 0, ErrBodyTooLarge
 		}
 
-		r.Body = append(r.Body, data...)
-
-		if len(r.Body) >= contentLength {
-			r.State = requestStateDone
-			return contentLength, nil
+		if int64(len(data)) < contentLength {
+			return 0, nil
 		}
 
-		return contentLength, nil
+		r.Body = append(r.Body, data[:contentLength]...)
+		r.State = requestStateDone
+		return int(contentLength), nil
 
 	case requestStateDone:
 		return 0, fmt.Errorf("error trying to read data in a requestStateDone state")
@@ -201,10 +369,36 @@ func requestLineFromString(str string) (*RequestLine, error) {
 		return nil, fmt.Errorf("invalid request target")
 	}
 
-	return &RequestLine{
+	requestLine := &RequestLine{
 		HttpVersion:   httpParts[1],
 		RequestTarget: requestTarget,
 		Method:        method,
-	}, nil
+	}
+	if err := requestLine.validateTargetForm(); err != nil {
+		return nil, err
+	}
+	return requestLine, nil
+
+}
 
+// bodyReader reads a body of a known length and reports one cut short as
+// io.ErrUnexpectedEOF rather than a clean EOF.
+type bodyReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (br *bodyReader) Read(p []byte) (int, error) {
+	if br.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > br.remaining {
+		p = p[:br.remaining]
+	}
+	n, err := br.r.Read(p)
+	br.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && br.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
 }
@@ -1,8 +1,11 @@
 package request
 
 import (
+	"bytes"
 	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -68,6 +71,44 @@ func TestRequestLineParse(t *testing.T) {
 	}
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
+
+	// Test: Absolute-form request target
+	reader = &chunkReader{
+		data:            "GET http://example.com/coffee?size=l HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, r.RequestLine.Form())
+	assert.Equal(t, "/coffee", r.RequestLine.Path())
+
+	// Test: Authority-form request target for CONNECT
+	reader = &chunkReader{
+		data:            "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, r.RequestLine.Form())
+	u, err := r.RequestLine.URL()
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", u.Host)
+
+	// Test: Authority-form without a port
+	reader = &chunkReader{
+		data:            "CONNECT example.com HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	r, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Asterisk-form is only valid for OPTIONS
+	reader = &chunkReader{
+		data:            "GET * HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	r, err = RequestFromReader(reader)
+	require.Error(t, err)
 }
 
 func TestRequestHeadersParse(t *testing.T) {
@@ -184,6 +225,109 @@ func TestRequestBodyParse(t *testing.T) {
 	require.NotNil(t, r)
 	assert.Equal(t, "", string(r.Body))
 
+	// Test: Bodies over the limit are refused from the Content-Length alone
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 1073741824\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	_, _, err = ReadRequestLimit(reader, 13)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Bodies at the limit are read
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, _, err = ReadRequestLimit(reader, 13)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: A streamed body is read from what follows the headers, and one
+	// cut short is an error
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, buffered, err := ReadRequestHead(reader)
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+	r.StreamBody(io.MultiReader(bytes.NewReader(buffered), reader))
+	body, err := io.ReadAll(r.BodyReader)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "hello world!\n", string(body))
+}
+
+func TestRequestWriteTo(t *testing.T) {
+	// Test: Builder with a byte body round-trips through the parser
+	req, err := NewBuilder("POST", "/submit").
+		Header("Host", "localhost:42069").
+		Header("Content-Type", "text/plain").
+		Body([]byte("hello world!\n")).
+		Build()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	n, err := req.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.True(t, strings.HasPrefix(buf.String(), "POST /submit HTTP/1.1\r\n"))
+	r, err := RequestFromReader(&chunkReader{data: buf.String(), numBytesPerRead: 3})
+	require.NoError(t, err)
+	assert.Equal(t, "POST", r.RequestLine.Method)
+	assert.Equal(t, "13", r.Headers["content-length"])
+	assert.Equal(t, "text/plain", r.Headers["content-type"])
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Reader with a known length uses Content-Length
+	req, err = NewBuilder("PUT", "/upload").BodyReader(strings.NewReader("abc")).Build()
+	require.NoError(t, err)
+	buf.Reset()
+	_, err = req.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "content-length: 3\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nabc"))
+
+	// Test: Reader of unknown length is sent chunked
+	req, err = NewBuilder("POST", "/stream").
+		BodyReader(io.MultiReader(strings.NewReader("hello "), strings.NewReader("world"))).
+		Build()
+	require.NoError(t, err)
+	buf.Reset()
+	_, err = req.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, buf.String(), "content-length")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n6\r\nhello \r\n5\r\nworld\r\n0\r\n\r\n"))
+
+	// Test: Absolute-form target fills in Host
+	req, err = NewBuilder("GET", "http://example.com:8080/a?b=c").Build()
+	require.NoError(t, err)
+	buf.Reset()
+	_, err = req.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "host: example.com:8080\r\n")
+	assert.NotContains(t, buf.String(), "content-length")
+
+	// Test: Invalid builder input
+	_, err = NewBuilder("get", "/").Build()
+	require.Error(t, err)
+	_, err = NewBuilder("GET", "/a b").Build()
+	require.Error(t, err)
+	_, err = NewBuilder("GET", "/").Header("Bad Name", "x").Build()
+	require.Error(t, err)
+	_, err = NewBuilder("GET", "/").Header("X-Injected", "a\r\nEvil: 1").Build()
+	require.Error(t, err)
 }
 
 type chunkReader struct {
@@ -205,3 +349,44 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 
 	return n, nil
 }
+
+func TestRequestCookies(t *testing.T) {
+	// Test: Cookies are parsed from the Cookie header
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nCookie: session=abc; theme=dark\r\n\r\n"))
+	require.NoError(t, err)
+	require.Len(t, r.Cookies(), 2)
+	c, ok := r.Cookie("theme")
+	require.True(t, ok)
+	assert.Equal(t, "dark", c.Value)
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+
+	// Test: No Cookie header
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
+}
+
+func TestParseErrorKind(t *testing.T) {
+	// Test: Errors tell which part of the request was at fault
+	for raw, kind := range map[string]string{
+		"GET /coffee\r\n\r\n":                                ParseErrorRequestLine,
+		"GET / HTTP/1.1\r\nHost : localhost\r\n\r\n":         ParseErrorHeaders,
+		"POST / HTTP/1.1\r\nContent-Length: ten\r\n\r\n":     ParseErrorBody,
+		"POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n":      ParseErrorBody,
+		"POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nshort": ParseErrorIncomplete,
+		"GET / HTTP/1.1\r\nHost: localhost\r\n":              ParseErrorIncomplete,
+	} {
+		_, err := RequestFromReader(strings.NewReader(raw))
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr, raw)
+		assert.Equal(t, kind, parseErr.Kind, raw)
+	}
+
+	// Test: Read errors are kept
+	_, err := RequestFromReader(iotest.TimeoutReader(strings.NewReader("GET / HTTP/1.1\r\n")))
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, ParseErrorRead, parseErr.Kind)
+	assert.ErrorIs(t, err, iotest.ErrTimeout)
+}
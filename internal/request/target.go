@@ -0,0 +1,86 @@
+package request
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// TargetForm is one of the four request-target forms of RFC 9112 section 3.2.
+type TargetForm int
+
+const (
+	// OriginForm is a path and query, as in "GET /coffee?size=l".
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a full URL, sent to forward proxies.
+	AbsoluteForm
+	// AuthorityForm is "host:port", only used by CONNECT.
+	AuthorityForm
+	// AsteriskForm is "*", only used by a server-wide OPTIONS.
+	AsteriskForm
+)
+
+func (rl RequestLine) Form() TargetForm {
+	switch {
+	case rl.RequestTarget == "*":
+		return AsteriskForm
+	case strings.HasPrefix(rl.RequestTarget, "/"):
+		return OriginForm
+	case rl.Method == "CONNECT":
+		return AuthorityForm
+	default:
+		return AbsoluteForm
+	}
+}
+
+// URL parses the request target. Authority-form targets only fill in Host.
+func (rl RequestLine) URL() (*url.URL, error) {
+	switch rl.Form() {
+	case AuthorityForm:
+		return &url.URL{Host: rl.RequestTarget}, nil
+	case AsteriskForm:
+		return &url.URL{Path: "*"}, nil
+	}
+	return url.ParseRequestURI(rl.RequestTarget)
+}
+
+// Path returns the path of an origin-form or absolute-form target, so
+// handlers can route requests the same way whichever form the client sent.
+func (rl RequestLine) Path() string {
+	switch rl.Form() {
+	case OriginForm:
+		path, _, _ := strings.Cut(rl.RequestTarget, "?")
+		return path
+	case AbsoluteForm:
+		u, err := url.ParseRequestURI(rl.RequestTarget)
+		if err != nil {
+			return ""
+		}
+		if u.Path == "" {
+			return "/"
+		}
+		return u.Path
+	}
+	return ""
+}
+
+func (rl RequestLine) validateTargetForm() error {
+	switch rl.Form() {
+	case AsteriskForm:
+		if rl.Method != "OPTIONS" {
+			return fmt.Errorf("asterisk-form request target is only allowed for OPTIONS")
+		}
+	case AuthorityForm:
+		host, port, err := net.SplitHostPort(rl.RequestTarget)
+		if err != nil || host == "" || port == "" {
+			return fmt.Errorf("CONNECT request target must be host:port")
+		}
+	case AbsoluteForm:
+		u, err := url.ParseRequestURI(rl.RequestTarget)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid request target")
+		}
+	}
+	return nil
+}
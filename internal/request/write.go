@@ -0,0 +1,130 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const chunkSize = 32 * 1024
+
+// WriteTo writes r to w as an HTTP/1.1 message. Body framing is chosen from
+// the body: a []byte Body or a BodyReader of known length is sent with
+// Content-Length, any other BodyReader is sent chunked.
+func (r *Request) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+
+	h := r.Headers.Clone()
+	h.Remove("Content-Length")
+	h.Remove("Transfer-Encoding")
+	if _, ok := h.Get("Host"); !ok {
+		if host := hostFromTarget(r.RequestLine.RequestTarget); host != "" {
+			h.Set("Host", host)
+		}
+	}
+
+	length, chunked := r.bodyFraming()
+	if chunked {
+		h.Set("Transfer-Encoding", "chunked")
+	} else if length > 0 || methodHasBody(r.RequestLine.Method) {
+		h.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+
+	fmt.Fprintf(cw, "%s %s HTTP/1.1\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget)
+	for k, v := range h {
+		fmt.Fprintf(cw, "%s: %s\r\n", k, v)
+	}
+	io.WriteString(cw, "\r\n")
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	switch {
+	case r.BodyReader == nil:
+		cw.Write(r.Body)
+	case chunked:
+		writeChunked(cw, r.BodyReader)
+	default:
+		n, err := io.CopyN(cw, r.BodyReader, length)
+		if err != nil && cw.err == nil {
+			cw.err = fmt.Errorf("body shorter than Content-Length, wrote %d of %d bytes: %w", n, length, err)
+		}
+	}
+	return cw.n, cw.err
+}
+
+// bodyFraming returns the Content-Length to send, or chunked=true when the
+// length of BodyReader cannot be known up front. An explicit Content-Length
+// header is trusted for a BodyReader such as an *os.File.
+func (r *Request) bodyFraming() (length int64, chunked bool) {
+	if r.BodyReader == nil {
+		return int64(len(r.Body)), false
+	}
+	if cl, ok := r.Headers.Get("Content-Length"); ok {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			return n, false
+		}
+	}
+	if l, ok := r.BodyReader.(interface{ Len() int }); ok {
+		return int64(l.Len()), false
+	}
+	return 0, true
+}
+
+func writeChunked(w *countingWriter, body io.Reader) {
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			io.WriteString(w, "\r\n")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if w.err == nil {
+				w.err = err
+			}
+			return
+		}
+	}
+	io.WriteString(w, "0\r\n\r\n")
+}
+
+func hostFromTarget(target string) string {
+	_, rest, ok := strings.Cut(target, "://")
+	if !ok {
+		return ""
+	}
+	host, _, _ := strings.Cut(rest, "/")
+	host, _, _ = strings.Cut(host, "?")
+	if idx := strings.LastIndexByte(host, '@'); idx != -1 {
+		host = host[idx+1:]
+	}
+	return host
+}
+
+func methodHasBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
+
+// countingWriter remembers the first write error so a sequence of writes can
+// be checked once at the end.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
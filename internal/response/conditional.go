@@ -0,0 +1,134 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"strings"
+	"time"
+)
+
+// StrongETag returns a strong entity tag for a representation whose bytes
+// are exactly content.
+func StrongETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+}
+
+// WeakETag marks etag as weak: it only promises semantic equivalence, not
+// identical bytes.
+func WeakETag(etag string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
+
+// CheckPreconditions evaluates the conditional headers of req against the
+// validators of the selected representation, in the order RFC 9110 section
+// 13.2.2 requires. Either validator may be empty or zero when the
+// representation does not have it. It returns StatusCodeSuccess when the
+// request should go ahead, and StatusCodeNotModified or
+// StatusCodePreconditionFailed when that response should be sent instead.
+func CheckPreconditions(req *request.Request, etag string, lastModified time.Time) StatusCode {
+	method := req.RequestLine.Method
+	h := req.Headers
+
+	if v, ok := h.Get("If-Match"); ok {
+		if !ETagsMatch(v, etag, false) {
+			return StatusCodePreconditionFailed
+		}
+	} else if since, ok := headerTime(h, "If-Unmodified-Since"); ok && !lastModified.IsZero() {
+		if lastModified.Truncate(time.Second).After(since) {
+			return StatusCodePreconditionFailed
+		}
+	}
+
+	if v, ok := h.Get("If-None-Match"); ok {
+		if ETagsMatch(v, etag, true) {
+			if method == "GET" || method == "HEAD" {
+				return StatusCodeNotModified
+			}
+			return StatusCodePreconditionFailed
+		}
+	} else if since, ok := headerTime(h, "If-Modified-Since"); ok && !lastModified.IsZero() {
+		if (method == "GET" || method == "HEAD") && !lastModified.Truncate(time.Second).After(since) {
+			return StatusCodeNotModified
+		}
+	}
+
+	return StatusCodeSuccess
+}
+
+// NotModifiedHeaders picks the headers of a full response that a 304 must
+// repeat (RFC 9110 section 15.4.5).
+func NotModifiedHeaders(full headers.Headers) headers.Headers {
+	h := headers.NewHeaders()
+	for _, name := range []string{"cache-control", "content-location", "date", "etag", "expires", "last-modified", "vary"} {
+		if v, ok := full.Get(name); ok {
+			h.Set(name, v)
+		}
+	}
+	h.Set("Connection", "close")
+	return h
+}
+
+// ETagsMatch reports whether etag is listed in an If-Match, If-None-Match
+// or If-Range value. Weak comparison ignores the weakness of either tag,
+// strong comparison requires both to be strong.
+func ETagsMatch(list, etag string, weak bool) bool {
+	list = strings.TrimSpace(list)
+	// "*" matches any current representation, which the caller has
+	if list == "*" {
+		return true
+	}
+	if etag == "" {
+		return false
+	}
+	for list != "" {
+		tag, rest, ok := scanETag(list)
+		if !ok {
+			return false
+		}
+		if compareETags(tag, etag, weak) {
+			return true
+		}
+		list = strings.TrimLeft(rest, " \t,")
+	}
+	return false
+}
+
+func compareETags(a, b string, weak bool) bool {
+	aWeak, bWeak := strings.HasPrefix(a, "W/"), strings.HasPrefix(b, "W/")
+	if !weak && (aWeak || bWeak) {
+		return false
+	}
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// scanETag reads one entity tag from the start of s. Commas are allowed
+// inside the quotes, so the list cannot simply be split on them.
+func scanETag(s string) (etag, rest string, ok bool) {
+	start := s
+	s = strings.TrimPrefix(s, "W/")
+	if len(s) < 2 || s[0] != '"' {
+		return "", "", false
+	}
+	end := strings.IndexByte(s[1:], '"')
+	if end < 0 {
+		return "", "", false
+	}
+	n := len(start) - len(s) + end + 2
+	return start[:n], start[n:], true
+}
+
+func headerTime(h headers.Headers, name string) (time.Time, bool) {
+	v, ok := h.Get(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	// an invalid date means the condition is ignored
+	t, err := headers.ParseTime(v)
+	return t, err == nil
+}
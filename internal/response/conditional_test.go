@@ -0,0 +1,104 @@
+package response
+
+import (
+	"bytes"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func conditionalRequest(t *testing.T, method string, extra ...string) *request.Request {
+	raw := method + " / HTTP/1.1\r\nHost: localhost\r\n"
+	for _, h := range extra {
+		raw += h + "\r\n"
+	}
+	req, err := request.RequestFromReader(bytes.NewBufferString(raw + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestETags(t *testing.T) {
+	// Test: Strong tags are stable hashes of the content
+	etag := StrongETag([]byte("hello"))
+	assert.Equal(t, etag, StrongETag([]byte("hello")))
+	assert.NotEqual(t, etag, StrongETag([]byte("hello!")))
+	assert.Regexp(t, `^"[A-Za-z0-9_-]+"$`, etag)
+	assert.Equal(t, "W/"+etag, WeakETag(etag))
+	assert.Equal(t, "W/"+etag, WeakETag(WeakETag(etag)))
+
+	// Test: Weak comparison ignores weakness, strong comparison does not
+	assert.True(t, ETagsMatch(`W/"a"`, `"a"`, true))
+	assert.False(t, ETagsMatch(`W/"a"`, `"a"`, false))
+	assert.True(t, ETagsMatch(`"a"`, `"a"`, false))
+
+	// Test: Lists may contain commas inside quoted tags
+	assert.True(t, ETagsMatch(`"x", "a,b" , W/"c"`, `"a,b"`, false))
+	assert.True(t, ETagsMatch(`"x","a,b",W/"c"`, `W/"c"`, true))
+	assert.False(t, ETagsMatch(`"x", "a,b"`, `"a"`, true))
+	assert.True(t, ETagsMatch("*", `"anything"`, false))
+	assert.False(t, ETagsMatch(`unquoted`, `"unquoted"`, true))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := `"v2"`
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	before := headers.FormatTime(modified.Add(-time.Hour))
+	same := headers.FormatTime(modified)
+	after := headers.FormatTime(modified.Add(time.Hour))
+
+	check := func(method string, header ...string) StatusCode {
+		return CheckPreconditions(conditionalRequest(t, method, header...), etag, modified)
+	}
+
+	// Test: Unconditional requests go ahead
+	assert.Equal(t, StatusCodeSuccess, check("GET"))
+
+	// Test: If-None-Match hits are 304 for GET and HEAD, 412 otherwise
+	assert.Equal(t, StatusCodeNotModified, check("GET", `If-None-Match: "v1", "v2"`))
+	assert.Equal(t, StatusCodeNotModified, check("HEAD", `If-None-Match: W/"v2"`))
+	assert.Equal(t, StatusCodeNotModified, check("GET", "If-None-Match: *"))
+	assert.Equal(t, StatusCodeSuccess, check("GET", `If-None-Match: "v1"`))
+	assert.Equal(t, StatusCodePreconditionFailed, check("PUT", "If-None-Match: *"))
+
+	// Test: If-Modified-Since applies to GET and HEAD, at second precision
+	assert.Equal(t, StatusCodeNotModified, check("GET", "If-Modified-Since: "+same))
+	assert.Equal(t, StatusCodeSuccess, check("GET", "If-Modified-Since: "+before))
+	assert.Equal(t, StatusCodeSuccess, check("POST", "If-Modified-Since: "+after))
+	assert.Equal(t, StatusCodeSuccess, check("GET", "If-Modified-Since: yesterday"))
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	assert.Equal(t, StatusCodeSuccess, check("GET", `If-None-Match: "v1"`, "If-Modified-Since: "+after))
+
+	// Test: If-Match needs a strong match
+	assert.Equal(t, StatusCodeSuccess, check("PUT", `If-Match: "v2"`))
+	assert.Equal(t, StatusCodePreconditionFailed, check("PUT", `If-Match: W/"v2"`))
+	assert.Equal(t, StatusCodePreconditionFailed, check("GET", `If-Match: "v1"`))
+
+	// Test: If-Unmodified-Since fails once the resource changed
+	assert.Equal(t, StatusCodeSuccess, check("PUT", "If-Unmodified-Since: "+same))
+	assert.Equal(t, StatusCodePreconditionFailed, check("PUT", "If-Unmodified-Since: "+before))
+
+	// Test: If-Match takes precedence over If-Unmodified-Since and is checked first
+	assert.Equal(t, StatusCodeSuccess, check("PUT", `If-Match: "v2"`, "If-Unmodified-Since: "+before))
+	assert.Equal(t, StatusCodePreconditionFailed, check("GET", `If-Match: "v1"`, `If-None-Match: "v2"`))
+
+	// Test: A 304 repeats validators and caching headers only
+	full := headers.NewHeaders()
+	full.Set("ETag", etag)
+	full.Set("Cache-Control", "max-age=60")
+	full.Set("Content-Length", "123")
+	full.Set("Content-Type", "text/html")
+	h := NotModifiedHeaders(full)
+	v, _ := h.Get("ETag")
+	assert.Equal(t, etag, v)
+	_, ok := h.Get("Cache-Control")
+	assert.True(t, ok)
+	_, ok = h.Get("Content-Length")
+	assert.False(t, ok)
+	_, ok = h.Get("Content-Type")
+	assert.False(t, ok)
+}
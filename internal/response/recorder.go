@@ -0,0 +1,79 @@
+package response
+
+import (
+	"bytes"
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+	"strings"
+)
+
+// Recorder keeps a response in memory instead of sending it, so middleware
+// can inspect or change the whole response before it goes out. Handlers
+// write to Writer as usual.
+type Recorder struct {
+	Writer     *Writer
+	StatusCode StatusCode
+	Headers    headers.Headers
+	Cookies    []*cookie.Cookie
+	// Body holds the payload, without any chunked framing.
+	Body     bytes.Buffer
+	Trailers headers.Headers
+}
+
+func NewRecorder() *Recorder {
+	r := &Recorder{
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+	}
+	r.Writer = &Writer{
+		writerState: writerStateStatusLine,
+		recorder:    r,
+	}
+	return r
+}
+
+// Written reports whether the handler wrote a status line.
+func (r *Recorder) Written() bool {
+	return r.Writer.writerState != writerStateStatusLine
+}
+
+// Chunked reports whether the recorded response uses chunked framing.
+func (r *Recorder) Chunked() bool {
+	te, _ := r.Headers.Get("Transfer-Encoding")
+	return strings.Contains(strings.ToLower(te), "chunked")
+}
+
+// WriteTo sends the recorded response to w. A chunked response is sent as a
+// single chunk followed by its trailers. Headers are sent as recorded, so a
+// middleware that changes Body must update Content-Length itself.
+func (r *Recorder) WriteTo(w *Writer) error {
+	if err := w.WriteStatusLine(r.StatusCode); err != nil {
+		return err
+	}
+
+	for _, c := range r.Cookies {
+		if err := w.SetCookie(c); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteHeaders(r.Headers); err != nil {
+		return err
+	}
+	if !r.Chunked() {
+		if r.Body.Len() == 0 {
+			return nil
+		}
+		_, err := w.WriteBody(r.Body.Bytes())
+		return err
+	}
+
+	if r.Body.Len() > 0 {
+		if _, err := w.WriteChunkedBody(r.Body.Bytes()); err != nil {
+			return err
+		}
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return w.WriteTrailers(r.Trailers)
+}
@@ -7,20 +7,73 @@ import (
 type StatusCode int
 
 const (
-	StatusCodeSuccess             StatusCode = 200
-	StatusCodeBadRequest          StatusCode = 400
-	StatusCodeInternalServerError StatusCode = 500
+	StatusCodeSwitchingProtocols   StatusCode = 101
+	StatusCodeSuccess              StatusCode = 200
+	StatusCodePartialContent       StatusCode = 206
+	StatusCodeMovedPermanently     StatusCode = 301
+	StatusCodeNotModified          StatusCode = 304
+	StatusCodeBadRequest           StatusCode = 400
+	StatusCodeForbidden            StatusCode = 403
+	StatusCodeNotFound             StatusCode = 404
+	StatusCodeMethodNotAllowed     StatusCode = 405
+	StatusCodeProxyAuthRequired    StatusCode = 407
+	StatusCodePreconditionFailed   StatusCode = 412
+	StatusCodeContentTooLarge      StatusCode = 413
+	StatusCodeUnsupportedMediaType StatusCode = 415
+	StatusCodeRangeNotSatisfiable  StatusCode = 416
+	StatusCodeUpgradeRequired      StatusCode = 426
+	StatusCodeInternalServerError  StatusCode = 500
+	StatusCodeBadGateway           StatusCode = 502
+	StatusCodeServiceUnavailable   StatusCode = 503
+	StatusCodeGatewayTimeout       StatusCode = 504
 )
 
+var reasonPhrases = map[StatusCode]string{
+	100: "Continue",
+	101: "Switching Protocols",
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	204: "No Content",
+	206: "Partial Content",
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	304: "Not Modified",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	407: "Proxy Authentication Required",
+	408: "Request Timeout",
+	409: "Conflict",
+	410: "Gone",
+	411: "Length Required",
+	412: "Precondition Failed",
+	413: "Content Too Large",
+	414: "URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Range Not Satisfiable",
+	426: "Upgrade Required",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+}
+
+// ReasonPhrase returns the standard reason phrase for statusCode, or an
+// empty string if it is not known.
+func ReasonPhrase(statusCode StatusCode) string {
+	return reasonPhrases[statusCode]
+}
+
 func getStatusLine(statusCode StatusCode) []byte {
-	reasonPhrase := ""
-	switch statusCode {
-	case StatusCodeSuccess:
-		reasonPhrase = "OK"
-	case StatusCodeBadRequest:
-		reasonPhrase = "Bad Request"
-	case StatusCodeInternalServerError:
-		reasonPhrase = "Internal Server Error"
-	}
-	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase))
+	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, ReasonPhrase(statusCode)))
 }
@@ -1,9 +1,12 @@
 package response
 
 import (
+	"errors"
 	"fmt"
+	"httpfromtcp/internal/cookie"
 	"httpfromtcp/internal/headers"
 	"io"
+	"net"
 )
 
 type writerState int
@@ -14,11 +17,28 @@ const (
 	writerStateBody
 	writerStateTrailer
 	writerStateDone
+	writerStateHijacked
 )
 
+var ErrNotHijackable = errors.New("response writer is not backed by a connection")
+
 type Writer struct {
 	writerState writerState
 	writer      io.Writer
+	// recorder, when set, receives the response instead of writer
+	recorder *Recorder
+
+	statusCode     StatusCode
+	onWriteHeaders []func(StatusCode, headers.Headers)
+	// cookies are sent as one Set-Cookie line each, which Headers cannot
+	// hold
+	cookies []*cookie.Cookie
+	// encoder, when set, transforms the body, which is then sent chunked
+	encoder       io.WriteCloser
+	encoderClosed bool
+	// bodyBytes counts the body as sent, after any encoding and without
+	// chunk framing
+	bodyBytes int64
 }
 
 func NewWriter(w io.Writer) *Writer {
@@ -33,6 +53,11 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 		return fmt.Errorf("cannot write status line in state %d", w.writerState)
 	}
 	defer func() { w.writerState = writerStateHeaders }()
+	w.statusCode = statusCode
+	if w.recorder != nil {
+		w.recorder.StatusCode = statusCode
+		return nil
+	}
 	_, err := w.writer.Write(getStatusLine(statusCode))
 	return err
 }
@@ -42,28 +67,136 @@ func (w *Writer) WriteHeaders(h headers.Headers) error {
 		return fmt.Errorf("cannot write headers in state %d", w.writerState)
 	}
 	defer func() { w.writerState = writerStateBody }()
+	// the most recently added hook belongs to the innermost middleware and
+	// runs first, so outer middleware see the headers it left
+	for i := len(w.onWriteHeaders) - 1; i >= 0; i-- {
+		w.onWriteHeaders[i](w.statusCode, h)
+	}
+	if w.recorder != nil {
+		w.recorder.Headers = h.Clone()
+		w.recorder.Cookies = w.cookies
+		return nil
+	}
 	for k, v := range h {
 		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
 		if err != nil {
 			return err
 		}
 	}
+	for _, c := range w.cookies {
+		_, err := w.writer.Write([]byte(fmt.Sprintf("set-cookie: %s\r\n", c)))
+		if err != nil {
+			return err
+		}
+	}
 	_, err := w.writer.Write([]byte("\r\n"))
 	return err
 }
 
+// SetCookie adds a Set-Cookie line to the response. It must be called
+// before WriteHeaders, and can be called from an OnWriteHeaders hook.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.writerState != writerStateStatusLine && w.writerState != writerStateHeaders {
+		return fmt.Errorf("cannot set cookie in state %d", w.writerState)
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, c)
+	return nil
+}
+
 func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
 	defer func() { w.writerState = writerStateTrailer }()
-	return w.writer.Write(p)
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+	if w.recorder != nil {
+		return w.countBody(w.recorder.Body.Write(p))
+	}
+	return w.countBody(w.writer.Write(p))
+}
+
+func (w *Writer) countBody(n int, err error) (int, error) {
+	w.bodyBytes += int64(n)
+	return n, err
+}
+
+// StatusCode returns the status written so far, or zero before
+// WriteStatusLine.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BodyBytes returns how many body bytes were sent, after any encoding and
+// not counting chunk framing. Bytes written after hijacking are not seen.
+func (w *Writer) BodyBytes() int64 {
+	return w.bodyBytes
+}
+
+// WriteBodyFrom streams a body of exactly n bytes from r, which should match
+// the Content-Length already sent. When the connection is a *net.TCPConn
+// and r is an *os.File the copy is done by the kernel (sendfile on Linux)
+// without passing through user space.
+func (w *Writer) WriteBodyFrom(r io.Reader, n int64) (int64, error) {
+	if w.writerState != writerStateBody {
+		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
+	}
+	defer func() { w.writerState = writerStateTrailer }()
+	dst := w.writer
+	switch {
+	case w.encoder != nil:
+		dst = w.encoder
+	case w.recorder != nil:
+		dst = &w.recorder.Body
+	}
+	// io.Copy hands the limited reader to the connection's ReadFrom, which
+	// recognises an *os.File inside it
+	written, err := io.Copy(dst, io.LimitReader(r, n))
+	if w.encoder == nil {
+		w.bodyBytes += written
+	}
+	if err == nil && written < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return written, err
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+	if w.recorder != nil {
+		return w.countBody(w.recorder.Body.Write(p))
+	}
+	n, err := w.writeChunk(p)
+	if err == nil {
+		w.bodyBytes += int64(len(p))
+	}
+	return n, err
+}
+
+// Flush sends any body bytes an encoder is holding back, so streamed
+// responses such as event streams reach the client as they are written.
+// Without an encoder every write is sent straight away and Flush does
+// nothing.
+func (w *Writer) Flush() error {
+	if w.writerState != writerStateBody {
+		return fmt.Errorf("cannot flush body in state %d", w.writerState)
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
 	chunkSize := len(p)
 
 	nTotal := 0
@@ -92,6 +225,12 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
 	defer func() { w.writerState = writerStateTrailer }()
+	if w.encoder != nil {
+		return 0, w.closeEncoder()
+	}
+	if w.recorder != nil {
+		return 0, nil
+	}
 	n, err := w.writer.Write([]byte("0\r\n"))
 	if err != nil {
 		return n, err
@@ -105,6 +244,10 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	}
 
 	defer func() { w.writerState = writerStateDone }()
+	if w.recorder != nil {
+		w.recorder.Trailers = h.Clone()
+		return nil
+	}
 
 	for k, v := range h {
 		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
@@ -117,3 +260,94 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 
 	return err
 }
+
+// Hijack hands the underlying connection over to the caller, who becomes
+// responsible for closing it. Nothing more can be written through w. A
+// status line and headers written before hijacking have already been sent.
+func (w *Writer) Hijack() (net.Conn, error) {
+	if w.writerState == writerStateHijacked {
+		return nil, fmt.Errorf("connection already hijacked")
+	}
+	conn, ok := w.writer.(net.Conn)
+	if !ok {
+		return nil, ErrNotHijackable
+	}
+	w.writerState = writerStateHijacked
+	return conn, nil
+}
+
+func (w *Writer) Hijacked() bool {
+	return w.writerState == writerStateHijacked
+}
+
+// OnWriteHeaders registers fn to run just before the headers are written.
+// fn may change the headers, or call EncodeBody. Middleware use it to act
+// on the response a handler chose without buffering it.
+func (w *Writer) OnWriteHeaders(fn func(statusCode StatusCode, h headers.Headers)) {
+	w.onWriteHeaders = append(w.onWriteHeaders, fn)
+}
+
+// EncodeBody passes the body through an encoder, such as a compressor,
+// and sends its output chunked. It must be called from an OnWriteHeaders
+// hook, which also has to set Transfer-Encoding: chunked and drop
+// Content-Length. Finish must be called once the handler returns.
+func (w *Writer) EncodeBody(newEncoder func(io.Writer) io.WriteCloser) {
+	var dst io.Writer = chunkWriter{w}
+	if w.recorder != nil {
+		dst = &w.recorder.Body
+	}
+	w.encoder = newEncoder(dst)
+}
+
+// Finish completes a response whose body is being encoded: it flushes the
+// encoder and ends the chunked body, unless the handler already did.
+func (w *Writer) Finish() error {
+	if w.encoder == nil {
+		return nil
+	}
+	switch w.writerState {
+	case writerStateBody:
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+	case writerStateTrailer:
+		if err := w.closeEncoder(); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	return w.WriteTrailers(headers.NewHeaders())
+}
+
+// closeEncoder flushes the rest of the encoded body and writes the last
+// chunk.
+func (w *Writer) closeEncoder() error {
+	if w.encoderClosed {
+		return nil
+	}
+	w.encoderClosed = true
+	if err := w.encoder.Close(); err != nil {
+		return err
+	}
+	if w.recorder != nil {
+		return nil
+	}
+	_, err := w.writer.Write([]byte("0\r\n"))
+	return err
+}
+
+// chunkWriter frames everything an encoder outputs as chunks.
+type chunkWriter struct {
+	w *Writer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := cw.w.writeChunk(p); err != nil {
+		return 0, err
+	}
+	return cw.w.countBody(len(p), nil)
+}
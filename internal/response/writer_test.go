@@ -0,0 +1,96 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetCookie(t *testing.T) {
+	// Test: Each cookie gets its own Set-Cookie line
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Path: "/"}))
+	w.OnWriteHeaders(func(_ StatusCode, _ headers.Headers) {
+		require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	})
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.Contains(t, out.String(), "\r\nset-cookie: a=1; Path=/\r\nset-cookie: b=2; HttpOnly\r\n\r\n")
+	assert.Equal(t, 2, strings.Count(out.String(), "set-cookie:"))
+
+	// Test: Cookies cannot be set once the headers are sent, or if invalid
+	assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "c", Value: "3"}))
+	assert.Error(t, NewWriter(&out).SetCookie(&cookie.Cookie{Name: "c", Value: "a b"}))
+
+	// Test: Recorded cookies are replayed
+	rec := NewRecorder()
+	require.NoError(t, rec.Writer.SetCookie(&cookie.Cookie{Name: "a", Value: "1"}))
+	require.NoError(t, rec.Writer.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, rec.Writer.WriteHeaders(GetDefaultHeaders(0)))
+	require.Len(t, rec.Cookies, 1)
+	out.Reset()
+	require.NoError(t, rec.WriteTo(NewWriter(&out)))
+	assert.Contains(t, out.String(), "set-cookie: a=1\r\n")
+}
+
+func TestFlush(t *testing.T) {
+	// Test: Flush pushes out what an encoder holds back
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.OnWriteHeaders(func(_ StatusCode, h headers.Headers) {
+		w.EncodeBody(func(dst io.Writer) io.WriteCloser { return gzip.NewWriter(dst) })
+	})
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err := w.WriteChunkedBody([]byte("data: tick\n\n"))
+	require.NoError(t, err)
+	before := out.Len()
+	require.NoError(t, w.Flush())
+	assert.Greater(t, out.Len(), before)
+
+	// Test: Flush needs a body under way, and without an encoder does nothing
+	w = NewWriter(&out)
+	assert.Error(t, w.Flush())
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.NoError(t, w.Flush())
+}
+
+func TestBodyBytes(t *testing.T) {
+	// Test: Plain and chunked bodies are counted without framing
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusCodeNotFound))
+	assert.Equal(t, StatusCodeNotFound, w.StatusCode())
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	w.WriteChunkedBody([]byte("hello"))
+	w.WriteChunkedBody([]byte(" world"))
+	assert.Equal(t, int64(11), w.BodyBytes())
+
+	w = NewWriter(&out)
+	w.WriteStatusLine(StatusCodeSuccess)
+	w.WriteHeaders(GetDefaultHeaders(3))
+	w.WriteBodyFrom(strings.NewReader("abc"), 3)
+	assert.Equal(t, int64(3), w.BodyBytes())
+
+	// Test: Encoded bodies are counted as sent
+	out.Reset()
+	w = NewWriter(&out)
+	w.OnWriteHeaders(func(_ StatusCode, h headers.Headers) {
+		w.EncodeBody(func(dst io.Writer) io.WriteCloser { return gzip.NewWriter(dst) })
+	})
+	w.WriteStatusLine(StatusCodeSuccess)
+	w.WriteHeaders(headers.NewHeaders())
+	w.WriteChunkedBody([]byte(strings.Repeat("a", 1000)))
+	require.NoError(t, w.Finish())
+	assert.Greater(t, w.BodyBytes(), int64(0))
+	assert.Less(t, w.BodyBytes(), int64(100))
+}
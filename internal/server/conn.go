@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// conn is the connection a handler writes to. While the handler runs a
+// background read waits on the connection, so the request's context is
+// cancelled as soon as the client hangs up. Whoever hijacks the
+// connection stops that read by reading or setting a read deadline, and
+// first gets back any bytes read ahead of the request.
+type conn struct {
+	net.Conn
+	cancel func()
+
+	// pending holds bytes read past the request, by its parser or by
+	// the background read
+	pending  []byte
+	stopping atomic.Bool
+	stopOnce sync.Once
+	done     chan struct{}
+	b        [1]byte
+	n        int
+}
+
+// aLongTimeAgo is a read deadline that makes a blocked read return at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+func watchConn(c net.Conn, buffered []byte, cancel func()) *conn {
+	wc := &conn{
+		Conn:    c,
+		cancel:  cancel,
+		pending: buffered,
+		done:    make(chan struct{}),
+	}
+	go wc.backgroundRead()
+	return wc
+}
+
+func (c *conn) backgroundRead() {
+	defer close(c.done)
+	n, err := c.Conn.Read(c.b[:])
+	c.n = n
+	if n > 0 {
+		// the client sent more, so a hang up cannot be told apart from it
+		return
+	}
+	if c.stopping.Load() && errors.Is(err, os.ErrDeadlineExceeded) {
+		return
+	}
+	// a client that only shuts down its sending side looks the same as
+	// one that went away, and is treated as gone
+	c.cancel()
+}
+
+// stop ends the background read, keeping any byte it got.
+func (c *conn) stop() {
+	c.stopOnce.Do(func() {
+		c.stopping.Store(true)
+		c.Conn.SetReadDeadline(aLongTimeAgo)
+		<-c.done
+		c.Conn.SetReadDeadline(time.Time{})
+		c.pending = append(c.pending, c.b[:c.n]...)
+	})
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	c.stop()
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.stop()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.stop()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// ReadFrom keeps the kernel copy (sendfile) of the underlying connection
+// available to response.Writer.WriteBodyFrom.
+func (c *conn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(c.Conn, r)
+}
+
+// CloseWrite half-closes the connection when the underlying one can, as
+// the CONNECT tunnel of the forward proxy expects.
+func (c *conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,12 @@
+package server
+
+// Middleware wraps a Handler to run code before and after it.
+type Middleware func(Handler) Handler
+
+// Chain wraps h in middleware, the first of which runs first.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
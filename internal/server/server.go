@@ -1,12 +1,19 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/proxyproto"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 	"log"
 	"net"
+	"net/netip"
 	"sync/atomic"
+	"time"
 )
 
 type Handler func(w *response.Writer, req *request.Request)
@@ -16,23 +23,116 @@ type Server struct {
 	handler  Handler
 	listener net.Listener
 	closed   atomic.Bool
+	errors   problem.Renderer
+	// ctx is the parent of every request's context, cancelled on Close
+	ctx            context.Context
+	cancel         context.CancelFunc
+	requestTimeout time.Duration
+	maxBodySize    int64
+	streamBody     func(*request.Request) bool
+	lastConnID     atomic.Uint64
+	observer       Observer
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
+// Observer is told about what the server does before and around the
+// handler, for metrics.
+type Observer interface {
+	// ConnOpened and ConnClosed bracket the serving of each connection. A
+	// hijacked connection counts as closed once handed over.
+	ConnOpened()
+	ConnClosed()
+	// ParseError is called with the request.ParseError kind of every
+	// request that could not be read.
+	ParseError(kind string)
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithErrorRenderer renders the errors the server itself responds with,
+// such as requests that cannot be parsed.
+func WithErrorRenderer(r problem.Renderer) Option {
+	return func(s *Server) {
+		s.errors = r
+	}
+}
+
+// WithRequestTimeout sets a deadline on the context of every request, d
+// after it was read. Handlers that respect the context, such as the proxy,
+// give up once it passes.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = d
+	}
+}
+
+// WithMaxBodySize refuses requests with a body over n bytes with 413,
+// going by their Content-Length before reading any of the body.
+func WithMaxBodySize(n int64) Option {
+	return func(s *Server) {
+		s.maxBodySize = n
+	}
+}
+
+// WithStreamedBodies leaves the body of requests that stream reports true
+// for unread, for the handler to read from the request's BodyReader as it
+// arrives, such as uploads too large to hold in memory. Their size is for
+// the handler to bound, not WithMaxBodySize. stream is called with the
+// request line and headers.
+func WithStreamedBodies(stream func(req *request.Request) bool) Option {
+	return func(s *Server) {
+		s.streamBody = stream
+	}
+}
+
+// WithProxyProtocol makes the server expect a PROXY protocol header
+// (version 1 or 2) on connections from the trusted load balancers, and
+// report the client address it carries as the request's RemoteAddr. For
+// HTTPS the header comes before the TLS handshake, so serve
+// tls.NewListener(proxyproto.NewListener(l, trusted), config) instead.
+func WithProxyProtocol(trusted []netip.Prefix) Option {
+	return func(s *Server) {
+		s.listener = proxyproto.NewListener(s.listener, trusted)
+	}
+}
+
+// WithObserver reports connections and unreadable requests to o.
+func WithObserver(o Observer) Option {
+	return func(s *Server) {
+		s.observer = o
+	}
+}
+
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
+	return ServeListener(listener, handler, opts...), nil
+}
+
+// ServeListener serves connections accepted from listener, which the
+// server closes on Close. Serving a listener from tls.NewListener makes an
+// HTTPS server, and requests then carry the TLS connection state.
+func ServeListener(listener net.Listener, handler Handler, opts ...Option) *Server {
 	s := &Server{
 		handler:  handler,
 		listener: listener,
+		errors:   problem.Default,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(s)
 	}
 	go s.listen()
-	return s, nil
+	return s
 }
 
+// Close stops accepting connections and cancels the context of every
+// request still being handled.
 func (s *Server) Close() error {
 	s.closed.Store(true)
+	s.cancel()
 	if s.listener != nil {
 		return s.listener.Close()
 	}
@@ -54,16 +154,72 @@ func (s *Server) listen() {
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	w := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
+	connID := s.lastConnID.Add(1)
+	if s.observer != nil {
+		s.observer.ConnOpened()
+		defer s.observer.ConnClosed()
+	}
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+		}
+	}()
+	readStart := time.Now()
+	req, buffered, err := request.ReadRequestHead(conn)
+	streamed := false
+	if err == nil {
+		if s.streamBody != nil && s.streamBody(req) {
+			streamed = true
+			if _, lenErr := req.ContentLength(); lenErr != nil {
+				err = &request.ParseError{Kind: request.ParseErrorBody, Err: lenErr}
+			}
+		} else {
+			buffered, err = req.ReadBody(conn, buffered, s.maxBodySize)
+		}
+	}
 	if err != nil {
-		w.WriteStatusLine(response.StatusCodeBadRequest)
-		body := []byte(fmt.Sprintf("Error parsing request: %v", err))
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
+		var parseErr *request.ParseError
+		if s.observer != nil && errors.As(err, &parseErr) {
+			s.observer.ParseError(parseErr.Kind)
+		}
+		status := response.StatusCodeBadRequest
+		if errors.Is(err, request.ErrBodyTooLarge) {
+			status = response.StatusCodeContentTooLarge
+		}
+		s.errors.Render(response.NewWriter(conn), nil, problem.New(status, fmt.Sprintf("Error parsing request: %v", err)))
 		return
 	}
-	s.handler(w, req)
-	return
+	req.ReadStart, req.ReadDone = readStart, time.Now()
+	req.RemoteAddr = conn.RemoteAddr().String()
+	req.LocalAddr = conn.LocalAddr().String()
+	req.ConnID = connID
+	req.ClientIP, _, _ = net.SplitHostPort(req.RemoteAddr)
+	req.Scheme = "http"
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// reading the request completed the handshake
+		state := tlsConn.ConnectionState()
+		req.TLS = &state
+		req.Scheme = "https"
+	}
+	req.Host, _ = req.Headers.Get("Host")
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if s.requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(s.ctx, s.requestTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(s.ctx)
+	}
+	defer cancel()
+	ctx = problem.NewContext(ctx, s.errors)
+	c := watchConn(conn, buffered, cancel)
+	if streamed {
+		// reading the body stops the watch for the client hanging up, as
+		// hijacking does
+		req.StreamBody(c)
+	}
+	w := response.NewWriter(c)
+	s.handler(w, req.WithContext(ctx))
+	hijacked = w.Hijacked()
 }
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const get = "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+func serve(t *testing.T, handler Handler, opts ...Option) (*Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := ServeListener(listener, handler, opts...)
+	t.Cleanup(func() { s.Close() })
+	return s, listener.Addr().String()
+}
+
+func send(t *testing.T, addr, raw string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	return conn
+}
+
+// waitDone reports the error of ctx once it is done, or nil if that takes
+// too long.
+func waitDone(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	errs := make(chan error, 1)
+	s, addr := serve(t, func(w *response.Writer, req *request.Request) {
+		errs <- waitDone(req.Context())
+	})
+
+	// Test: The context is cancelled when the client hangs up
+	conn := send(t, addr, get)
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	// Test: Closing the server cancels requests in flight
+	send(t, addr, get)
+	time.Sleep(10 * time.Millisecond)
+	s.Close()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	// Test: The request timeout puts a deadline on the context
+	_, addr = serve(t, func(w *response.Writer, req *request.Request) {
+		errs <- waitDone(req.Context())
+		w.WriteStatusLine(response.StatusCodeServiceUnavailable)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}, WithRequestTimeout(20*time.Millisecond))
+	conn = send(t, addr, get)
+	assert.ErrorIs(t, <-errs, context.DeadlineExceeded)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 503 Service Unavailable\r\n", line)
+
+	// Test: Middleware attach values for the handlers they wrap
+	type key struct{}
+	values := make(chan any, 1)
+	withUser := func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			next(w, req.WithContext(context.WithValue(req.Context(), key{}, "ada")))
+		}
+	}
+	_, addr = serve(t, Chain(func(w *response.Writer, req *request.Request) {
+		values <- req.Context().Value(key{})
+	}, withUser))
+	send(t, addr, get)
+	assert.Equal(t, "ada", <-values)
+}
+
+func TestHijackKeepsReadAhead(t *testing.T) {
+	got := make(chan string, 1)
+	_, addr := serve(t, func(w *response.Writer, req *request.Request) {
+		// give the background read time to take a byte
+		time.Sleep(20 * time.Millisecond)
+		if req.Context().Err() != nil {
+			got <- "cancelled"
+			return
+		}
+		conn, err := w.Hijack()
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		defer conn.Close()
+		p := make([]byte, 11)
+		io.ReadFull(conn, p)
+		got <- string(p)
+	})
+
+	// Test: Data sent after the request reaches whoever hijacks the
+	// connection, in order, whether the parser or the background read
+	// read it
+	conn := send(t, addr, get+"hello")
+	time.Sleep(50 * time.Millisecond)
+	conn.Write([]byte(" world"))
+	assert.Equal(t, "hello world", <-got)
+
+	conn = send(t, addr, get)
+	time.Sleep(5 * time.Millisecond)
+	conn.Write([]byte("hello world"))
+	assert.Equal(t, "hello world", <-got)
+}
+
+// selfSigned returns a certificate for localhost.
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestConnInfo(t *testing.T) {
+	reqs := make(chan *request.Request, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		reqs <- req
+	}
+	_, addr := serve(t, handler)
+
+	// Test: Requests carry both ends of their connection
+	conn := send(t, addr, get)
+	req := <-reqs
+	assert.Equal(t, conn.LocalAddr().String(), req.RemoteAddr)
+	assert.Equal(t, addr, req.LocalAddr)
+	assert.Nil(t, req.TLS)
+	assert.Equal(t, "127.0.0.1", req.ClientIP)
+	assert.Equal(t, "http", req.Scheme)
+	assert.Equal(t, "localhost", req.Host)
+	assert.False(t, req.ReadStart.IsZero())
+	assert.False(t, req.ReadDone.Before(req.ReadStart))
+
+	// Test: Each connection gets its own ID
+	send(t, addr, get)
+	next := <-reqs
+	assert.NotZero(t, req.ConnID)
+	assert.Greater(t, next.ConnID, req.ConnID)
+
+	// Test: Behind a trusted balancer the client comes from its PROXY header
+	_, addr = serve(t, handler, WithProxyProtocol([]netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}))
+	send(t, addr, "PROXY TCP4 203.0.113.7 192.0.2.1 51234 443\r\n"+get)
+	req = <-reqs
+	assert.Equal(t, "203.0.113.7:51234", req.RemoteAddr)
+	assert.Equal(t, "203.0.113.7", req.ClientIP)
+
+	// Test: A TLS listener exposes the connection state
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	config := &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}
+	s := ServeListener(tls.NewListener(listener, config), handler)
+	t.Cleanup(func() { s.Close() })
+	tlsConn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "localhost", InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer tlsConn.Close()
+	_, err = tlsConn.Write([]byte(get))
+	require.NoError(t, err)
+	req = <-reqs
+	require.NotNil(t, req.TLS)
+	assert.True(t, req.TLS.HandshakeComplete)
+	assert.Equal(t, "localhost", req.TLS.ServerName)
+	assert.Equal(t, "https", req.Scheme)
+}
+
+// observer records what a server reports.
+type observer struct {
+	mu     sync.Mutex
+	open   int
+	errors []string
+}
+
+func (o *observer) ConnOpened() { o.mu.Lock(); o.open++; o.mu.Unlock() }
+
+func (o *observer) ConnClosed() { o.mu.Lock(); o.open--; o.mu.Unlock() }
+
+func (o *observer) ParseError(kind string) {
+	o.mu.Lock()
+	o.errors = append(o.errors, kind)
+	o.mu.Unlock()
+}
+
+func (o *observer) state() (int, []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.open, append([]string(nil), o.errors...)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	_, addr := serve(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}, WithMaxBodySize(16))
+
+	// Test: A body over the limit is refused before the client sends it
+	conn := send(t, addr, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1073741824\r\n\r\n")
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large\r\n", line)
+
+	// Test: A body within the limit is handled
+	conn = send(t, addr, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 16\r\n\r\n0123456789abcdef")
+	line, err = bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+}
+
+func TestStreamedBodies(t *testing.T) {
+	_, addr := serve(t, func(w *response.Writer, req *request.Request) {
+		prefix := make([]byte, 5)
+		if _, err := io.ReadFull(req.BodyReader, prefix); err != nil {
+			w.WriteStatusLine(response.StatusCodeBadRequest)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			return
+		}
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(prefix)))
+		w.WriteBody(prefix)
+	}, WithMaxBodySize(16), WithStreamedBodies(func(req *request.Request) bool {
+		return req.RequestLine.Path() == "/upload"
+	}))
+
+	// Test: The handler reads a streamed body as it arrives, past the
+	// limit on buffered bodies
+	conn := send(t, addr, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1073741824\r\n\r\nhello")
+	body, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), "HTTP/1.1 200 OK\r\n"), string(body))
+	assert.True(t, strings.HasSuffix(string(body), "\r\n\r\nhello"), string(body))
+
+	// Test: Other requests are still held to the limit
+	conn = send(t, addr, "POST /other HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1073741824\r\n\r\nhello")
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large\r\n", line)
+}
+
+func TestObserver(t *testing.T) {
+	obs := &observer{}
+	release := make(chan struct{})
+	_, addr := serve(t, func(w *response.Writer, req *request.Request) {
+		<-release
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}, WithObserver(obs))
+
+	// Test: Connections count as open while they are served
+	conn := send(t, addr, get)
+	require.Eventually(t, func() bool { open, _ := obs.state(); return open == 1 }, time.Second, 5*time.Millisecond)
+	close(release)
+	_, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { open, _ := obs.state(); return open == 0 }, time.Second, 5*time.Millisecond)
+
+	// Test: Unreadable requests are reported by kind
+	send(t, addr, "GET /\r\n\r\n")
+	require.Eventually(t, func() bool {
+		_, errors := obs.state()
+		return assert.ObjectsAreEqual([]string{request.ParseErrorRequestLine}, errors)
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,96 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidCookie = errors.New("session: invalid cookie")
+
+// Codec signs cookie values with HMAC-SHA256 and, when given a block key,
+// encrypts them with AES-GCM so clients cannot read them either.
+type Codec struct {
+	hashKey []byte
+	aead    cipher.AEAD
+}
+
+// NewCodec returns a codec signing with hashKey, which should be 32 or
+// more random bytes. blockKey, when not nil, must be 16, 24 or 32 bytes to
+// select AES-128, AES-192 or AES-256.
+func NewCodec(hashKey, blockKey []byte) (*Codec, error) {
+	if len(hashKey) == 0 {
+		return nil, errors.New("session: hash key is required")
+	}
+	c := &Codec{hashKey: hashKey}
+	if blockKey != nil {
+		block, err := aes.NewCipher(blockKey)
+		if err != nil {
+			return nil, fmt.Errorf("session: block key: %w", err)
+		}
+		c.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Encode returns value sealed for the cookie called name. The name is
+// covered by the signature, so a value cannot be moved to another cookie.
+func (c *Codec) Encode(name, value string) (string, error) {
+	payload := []byte(value)
+	if c.aead != nil {
+		nonce := make([]byte, c.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = c.aead.Seal(nonce, nonce, payload, []byte(name))
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(c.mac(name, encoded)), nil
+}
+
+// Decode checks the signature on a value produced by Encode and returns
+// the original value.
+func (c *Codec) Decode(name, encoded string) (string, error) {
+	payload, sig, ok := strings.Cut(encoded, ".")
+	if !ok {
+		return "", ErrInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, c.mac(name, payload)) {
+		return "", ErrInvalidCookie
+	}
+	value, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	if c.aead == nil {
+		return string(value), nil
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(value) < nonceSize {
+		return "", ErrInvalidCookie
+	}
+	value, err = c.aead.Open(nil, value[:nonceSize], value[nonceSize:], []byte(name))
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	return string(value), nil
+}
+
+func (c *Codec) mac(name, payload string) []byte {
+	h := hmac.New(sha256.New, c.hashKey)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileStore keeps one JSON file per session in a directory, so sessions
+// survive restarts.
+type FileStore struct {
+	dir string
+}
+
+type fileEntry struct {
+	Record
+	Expires time.Time `json:"expires"`
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Load(id string) (*Record, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var e fileEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("session: decoding %s: %w", path, err)
+	}
+	if !e.Expires.IsZero() && time.Now().After(e.Expires) {
+		os.Remove(path)
+		return nil, ErrNotFound
+	}
+	return &e.Record, nil
+}
+
+// Save writes the session to a temporary file and renames it into place,
+// so a concurrent Load never sees a partial session.
+func (s *FileStore) Save(id string, r *Record, expires time.Time) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(fileEntry{Record: *r, Expires: expires})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Purge removes the files of expired sessions, which are otherwise only
+// removed when they are next loaded.
+func (s *FileStore) Purge() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			continue
+		}
+		var e fileEntry
+		if json.Unmarshal(data, &e) == nil && !e.Expires.IsZero() && now.After(e.Expires) {
+			os.Remove(filepath.Join(s.dir, name))
+		}
+	}
+	return nil
+}
+
+// path maps a session ID to its file. IDs come from cookies, so anything
+// that is not a generated ID is refused rather than used as a file name.
+func (s *FileStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
@@ -0,0 +1,321 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"log"
+	"maps"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultIdleTimeout     = 30 * time.Minute
+	DefaultAbsoluteTimeout = 24 * time.Hour
+)
+
+// Session is the state kept for one client between requests. It is only
+// created in the store once a value is set.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	oldID     string
+	record    Record
+	isNew     bool
+	modified  bool
+	destroyed bool
+}
+
+// ID returns the session ID, which is empty until the session is saved.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the session was created for this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Created returns when the session started.
+func (s *Session) Created() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.Created
+}
+
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.Values[key]
+}
+
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.record.Values[key]; ok {
+		delete(s.record.Values, key)
+		s.modified = true
+	}
+}
+
+// Values returns a copy of the session's values.
+func (s *Session) Values() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.record.Values)
+}
+
+// Renew moves the session to a new ID, keeping its values. Call it when
+// the client's privileges change, such as on login, so an ID planted
+// before then is worthless (session fixation).
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id != "" {
+		s.oldID = s.id
+		s.id = ""
+	}
+	s.modified = true
+}
+
+// Destroy removes the session from the store and the client.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// Manager loads and saves sessions for the Middleware it returns.
+type Manager struct {
+	Store Store
+	// Codecs seal the session cookie. The first one encodes; all are tried
+	// when decoding, so keys are rotated by putting a new codec first and
+	// dropping the old one once its cookies have expired. Cookies sealed
+	// by an older codec are reissued with the first.
+	Codecs []*Codec
+	// Cookie is the template for the session cookie. Its Value and MaxAge
+	// are set by the manager.
+	Cookie cookie.Cookie
+	// IdleTimeout ends sessions not used for this long, AbsoluteTimeout
+	// those started this long ago, however busy. Zero disables either.
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+
+	now func() time.Time
+}
+
+func NewManager(store Store, codecs ...*Codec) *Manager {
+	return &Manager{
+		Store:  store,
+		Codecs: codecs,
+		Cookie: cookie.Cookie{
+			Name:     "session",
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: cookie.SameSiteLax,
+		},
+		IdleTimeout:     DefaultIdleTimeout,
+		AbsoluteTimeout: DefaultAbsoluteTimeout,
+		now:             time.Now,
+	}
+}
+
+type contextKey struct{}
+
+// Get returns the session the middleware loaded for req, or nil when the
+// request did not pass through it.
+func Get(req *request.Request) *Session {
+	s, _ := req.Context().Value(contextKey{}).(*Session)
+	return s
+}
+
+// Middleware loads the client's session before the handler runs, and
+// saves it just before the response headers are written, when it can
+// still set the cookie. Handlers reach the session with Get.
+func (m *Manager) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			s, sent, reissue := m.load(req)
+			w.OnWriteHeaders(func(_ response.StatusCode, _ headers.Headers) {
+				m.save(w, s, sent, reissue)
+			})
+			next(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, s)))
+		}
+	}
+}
+
+// load returns the client's session, or a new one. sent reports whether
+// the client sent a session cookie, reissue whether that cookie was sealed
+// by an old codec.
+func (m *Manager) load(req *request.Request) (s *Session, sent, reissue bool) {
+	now := m.now()
+	s = &Session{
+		record: Record{Values: map[string]string{}, Created: now, LastSeen: now},
+		isNew:  true,
+	}
+
+	c, sent := req.Cookie(m.Cookie.Name)
+	if !sent {
+		return s, false, false
+	}
+	id := ""
+	for i, codec := range m.Codecs {
+		if decoded, err := codec.Decode(m.Cookie.Name, c.Value); err == nil {
+			id, reissue = decoded, i > 0
+			break
+		}
+	}
+	if id == "" {
+		return s, true, false
+	}
+
+	r, err := m.Store.Load(id)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Printf("session: loading %s: %v", id, err)
+		}
+		return s, true, false
+	}
+	if m.expired(r, now) {
+		if err := m.Store.Delete(id); err != nil {
+			log.Printf("session: deleting expired session: %v", err)
+		}
+		return s, true, false
+	}
+	if r.Values == nil {
+		r.Values = map[string]string{}
+	}
+	s.id, s.record, s.isNew = id, *r, false
+	return s, true, reissue
+}
+
+func (m *Manager) save(w *response.Writer, s *Session, sent, reissue bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.oldID != "" {
+		if err := m.Store.Delete(s.oldID); err != nil {
+			log.Printf("session: deleting renewed session: %v", err)
+		}
+		s.oldID = ""
+	}
+	if s.destroyed {
+		if s.id != "" {
+			if err := m.Store.Delete(s.id); err != nil {
+				log.Printf("session: deleting session: %v", err)
+			}
+		}
+		if sent {
+			m.setCookie(w, "", -1)
+		}
+		return
+	}
+	if s.id == "" && !s.modified {
+		// nothing worth storing, but a cookie for a session that is gone
+		// should not be sent again
+		if sent {
+			m.setCookie(w, "", -1)
+		}
+		return
+	}
+
+	fresh := s.id == ""
+	if fresh {
+		id, err := newID()
+		if err != nil {
+			log.Printf("session: generating ID: %v", err)
+			return
+		}
+		s.id = id
+	}
+	now := m.now()
+	s.record.LastSeen = now
+	expires := m.expires(&s.record)
+	if err := m.Store.Save(s.id, &s.record, expires); err != nil {
+		log.Printf("session: saving %s: %v", s.id, err)
+		return
+	}
+
+	// with an idle timeout every response pushes the cookie's expiry back
+	if !fresh && !reissue && m.IdleTimeout == 0 {
+		return
+	}
+	if len(m.Codecs) == 0 {
+		log.Printf("session: no codec to seal the session cookie")
+		return
+	}
+	value, err := m.Codecs[0].Encode(m.Cookie.Name, s.id)
+	if err != nil {
+		log.Printf("session: encoding cookie: %v", err)
+		return
+	}
+	maxAge := m.Cookie.MaxAge
+	if !expires.IsZero() {
+		maxAge = max(int(expires.Sub(now).Seconds()), 1)
+	}
+	m.setCookie(w, value, maxAge)
+}
+
+func (m *Manager) setCookie(w *response.Writer, value string, maxAge int) {
+	c := m.Cookie
+	c.Value = value
+	c.MaxAge = maxAge
+	if err := w.SetCookie(&c); err != nil {
+		log.Printf("session: setting cookie: %v", err)
+	}
+}
+
+func (m *Manager) expired(r *Record, now time.Time) bool {
+	if m.IdleTimeout > 0 && now.Sub(r.LastSeen) > m.IdleTimeout {
+		return true
+	}
+	return m.AbsoluteTimeout > 0 && now.Sub(r.Created) > m.AbsoluteTimeout
+}
+
+// expires returns when the session will expire if left idle, or the zero
+// time when it never does.
+func (m *Manager) expires(r *Record) time.Time {
+	var t time.Time
+	if m.IdleTimeout > 0 {
+		t = r.LastSeen.Add(m.IdleTimeout)
+	}
+	if m.AbsoluteTimeout > 0 {
+		if end := r.Created.Add(m.AbsoluteTimeout); t.IsZero() || end.Before(t) {
+			t = end
+		}
+	}
+	return t
+}
+
+const idLen = 32
+
+func newID() (string, error) {
+	b := make([]byte, idLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func validID(id string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(id)
+	return err == nil && len(b) == idLen
+}
@@ -0,0 +1,263 @@
+package session
+
+import (
+	"bytes"
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var setCookieLine = regexp.MustCompile(`(?m)^set-cookie: (.*)\r$`)
+
+// serve runs handler for a GET request sending cookieValue as the session
+// cookie, and returns the cookies set by the response.
+func serve(t *testing.T, handler server.Handler, cookieValue string, extra ...string) []*cookie.Cookie {
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	for _, h := range extra {
+		raw += h + "\r\n"
+	}
+	if cookieValue != "" {
+		raw += "Cookie: other=1; session=" + cookieValue + "\r\n"
+	}
+	req, err := request.RequestFromReader(bytes.NewBufferString(raw + "\r\n"))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	handler(response.NewWriter(&out), req)
+	var cookies []*cookie.Cookie
+	for _, m := range setCookieLine.FindAllStringSubmatch(out.String(), -1) {
+		c, err := cookie.ParseSetCookie(m[1])
+		require.NoError(t, err)
+		cookies = append(cookies, c)
+	}
+	return cookies
+}
+
+func ok(w *response.Writer, _ *request.Request) {
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+}
+
+func newCodec(t *testing.T, hashKey string, blockKey []byte) *Codec {
+	c, err := NewCodec([]byte(hashKey), blockKey)
+	require.NoError(t, err)
+	return c
+}
+
+func TestCodec(t *testing.T) {
+	signed := newCodec(t, "hash key", nil)
+	sealed := newCodec(t, "hash key", bytes.Repeat([]byte{7}, 32))
+
+	// Test: Signed values round-trip and stay readable
+	v, err := signed.Encode("session", "hello")
+	require.NoError(t, err)
+	got, err := signed.Decode("session", v)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", got)
+	assert.True(t, strings.HasPrefix(v, "aGVsbG8."))
+
+	// Test: Encrypted values round-trip but are unreadable and unique
+	v, err = sealed.Encode("session", "hello")
+	require.NoError(t, err)
+	got, err = sealed.Decode("session", v)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", got)
+	again, _ := sealed.Encode("session", "hello")
+	assert.NotEqual(t, v, again)
+	_, err = signed.Decode("session", v)
+	assert.NoError(t, err, "the signature does not depend on encryption")
+
+	// Test: Tampering, another cookie name or another key fail
+	_, err = sealed.Decode("session", "A"+v[1:])
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+	_, err = sealed.Decode("other", v)
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+	_, err = newCodec(t, "new key", nil).Decode("session", v)
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+	_, err = signed.Decode("session", "no-signature")
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+
+	// Test: Bad keys are refused
+	_, err = NewCodec(nil, nil)
+	assert.Error(t, err)
+	_, err = NewCodec([]byte("k"), []byte("short"))
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	store := NewMemoryStore()
+	m := NewManager(store, newCodec(t, "hash key", bytes.Repeat([]byte{1}, 16)))
+	now := time.Date(2030, time.January, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	var seen *Session
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		seen = Get(req)
+		switch req.Headers["x-action"] {
+		case "login":
+			seen.Set("user", "ada")
+			seen.Renew()
+		case "logout":
+			seen.Destroy()
+		}
+		ok(w, req)
+	}, m.Middleware())
+	act := func(action, cookieValue string) []*cookie.Cookie {
+		return serve(t, handler, cookieValue, "X-Action: "+action)
+	}
+
+	// Test: Sessions without values are neither stored nor sent
+	assert.Empty(t, serve(t, handler, ""))
+	require.NotNil(t, seen)
+	assert.True(t, seen.IsNew())
+	assert.Equal(t, 0, store.Len())
+
+	// Test: Setting a value stores the session and sets the cookie
+	cookies := act("login", "")
+	require.Len(t, cookies, 1)
+	c := cookies[0]
+	assert.Equal(t, "session", c.Name)
+	assert.Equal(t, "/", c.Path)
+	assert.True(t, c.HttpOnly)
+	assert.Equal(t, cookie.SameSiteLax, c.SameSite)
+	assert.Equal(t, int(DefaultIdleTimeout.Seconds()), c.MaxAge)
+	assert.Equal(t, 1, store.Len())
+	firstID := seen.ID()
+
+	// Test: The cookie brings the session back, sliding its expiry
+	now = now.Add(20 * time.Minute)
+	cookies = serve(t, handler, c.Value)
+	assert.False(t, seen.IsNew())
+	assert.Equal(t, "ada", seen.Get("user"))
+	assert.Equal(t, firstID, seen.ID())
+	require.Len(t, cookies, 1)
+
+	// Test: Renewing moves the session to a new ID and drops the old one
+	cookies = act("login", c.Value)
+	require.Len(t, cookies, 1)
+	assert.NotEqual(t, firstID, seen.ID())
+	assert.Equal(t, 1, store.Len())
+	_, err := store.Load(firstID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	c = cookies[0]
+
+	// Test: Idle sessions expire, and their cookie is cleared
+	now = now.Add(DefaultIdleTimeout + time.Second)
+	cookies = serve(t, handler, c.Value)
+	assert.True(t, seen.IsNew())
+	assert.Empty(t, seen.Get("user"))
+	require.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+	assert.Equal(t, 0, store.Len())
+
+	// Test: Busy sessions still end after the absolute timeout
+	c = act("login", "")[0]
+	for range 50 {
+		now = now.Add(DefaultIdleTimeout - time.Minute)
+		cookies = serve(t, handler, c.Value)
+		if seen.IsNew() {
+			break
+		}
+		c = cookies[0]
+	}
+	assert.True(t, seen.IsNew())
+	assert.LessOrEqual(t, now.Sub(seen.Created()), DefaultAbsoluteTimeout+DefaultIdleTimeout)
+
+	// Test: Destroying removes the session and the cookie
+	c = act("login", "")[0]
+	cookies = act("logout", c.Value)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+	assert.Equal(t, 0, store.Len())
+
+	// Test: Forged cookies get a new session
+	c = act("login", "")[0]
+	cookies = serve(t, handler, "x"+c.Value)
+	assert.True(t, seen.IsNew())
+	require.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+}
+
+func TestKeyRotation(t *testing.T) {
+	store := NewMemoryStore()
+	oldCodec := newCodec(t, "old key", nil)
+	m := NewManager(store, oldCodec)
+	m.IdleTimeout = 0
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	var seen *Session
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		seen = Get(req)
+		if seen.IsNew() {
+			seen.Set("n", "1")
+		}
+		ok(w, req)
+	}, m.Middleware())
+
+	c := serve(t, handler, "")[0]
+	assert.Equal(t, int(DefaultAbsoluteTimeout.Seconds()), c.MaxAge)
+
+	// Test: Without an idle timeout known cookies are not resent
+	assert.Empty(t, serve(t, handler, c.Value))
+
+	// Test: Cookies sealed with a retired key are still read, and reissued
+	m.Codecs = []*Codec{newCodec(t, "new key", nil), oldCodec}
+	cookies := serve(t, handler, c.Value)
+	assert.False(t, seen.IsNew())
+	require.Len(t, cookies, 1)
+	assert.NotEqual(t, c.Value, cookies[0].Value)
+
+	// Test: Once the old key is dropped only the new cookie works
+	m.Codecs = m.Codecs[:1]
+	assert.Empty(t, serve(t, handler, cookies[0].Value))
+	assert.False(t, seen.IsNew())
+	serve(t, handler, c.Value)
+	assert.True(t, seen.IsNew())
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+	id, err := newID()
+	require.NoError(t, err)
+	created := time.Now().Truncate(time.Second)
+	r := &Record{Values: map[string]string{"user": "ada"}, Created: created, LastSeen: created}
+
+	// Test: Records round-trip
+	require.NoError(t, store.Save(id, r, time.Now().Add(time.Hour)))
+	got, err := store.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, "ada", got.Values["user"])
+	assert.True(t, created.Equal(got.Created))
+
+	// Test: Expired records are not loaded, and Purge removes them
+	expired, _ := newID()
+	require.NoError(t, store.Save(expired, r, time.Now().Add(-time.Second)))
+	require.NoError(t, store.Purge())
+	_, err = os.Stat(filepath.Join(dir, expired+".json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = store.Load(expired)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Test: IDs that could escape the directory are refused
+	_, err = store.Load("../../etc/passwd")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Error(t, store.Save("../x", r, time.Time{}))
+
+	// Test: Delete
+	require.NoError(t, store.Delete(id))
+	_, err = store.Load(id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, store.Delete(id))
+}
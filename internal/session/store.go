@@ -0,0 +1,98 @@
+package session
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("session: not found")
+
+// Record is the stored state of a session.
+type Record struct {
+	Values   map[string]string `json:"values"`
+	Created  time.Time         `json:"created"`
+	LastSeen time.Time         `json:"lastSeen"`
+}
+
+// Store keeps session records by ID. Implementations must be safe for
+// concurrent use, and may drop records once they expire.
+type Store interface {
+	// Load returns ErrNotFound for unknown or expired sessions.
+	Load(id string) (*Record, error)
+	Save(id string, r *Record, expires time.Time) error
+	Delete(id string) error
+}
+
+// MemoryStore keeps sessions in memory, so they are lost on restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	saves   int
+}
+
+type memoryEntry struct {
+	record  Record
+	expires time.Time
+}
+
+// sweepEvery is how many saves pass between sweeps for expired sessions.
+const sweepEvery = 256
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]memoryEntry{}}
+}
+
+func (s *MemoryStore) Load(id string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(s.entries, id)
+		return nil, ErrNotFound
+	}
+	r := e.record
+	r.Values = maps.Clone(r.Values)
+	return &r, nil
+}
+
+func (s *MemoryStore) Save(id string, r *Record, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := memoryEntry{record: *r, expires: expires}
+	e.record.Values = maps.Clone(r.Values)
+	s.entries[id] = e
+
+	s.saves++
+	if s.saves%sweepEvery == 0 {
+		s.sweep(time.Now())
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+// Len returns the number of stored sessions, including expired ones not
+// swept yet.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for id, e := range s.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(s.entries, id)
+		}
+	}
+}
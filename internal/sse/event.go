@@ -0,0 +1,141 @@
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidEvent = errors.New("sse: event type and id cannot contain line breaks")
+
+// Event is one message of an event stream. Fields left empty are not sent.
+type Event struct {
+	// ID is remembered by the browser and sent back as Last-Event-ID when
+	// it reconnects.
+	ID string
+	// Event is the type a browser dispatches the event as; "message" when
+	// empty.
+	Event string
+	Data  string
+	// Retry tells the browser how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// encode formats e in the text/event-stream format (HTML Living Standard
+// section 9.2.5). Data is split into one data line per line. An event
+// with only an ID or a retry time carries no data, so browsers update
+// their state without dispatching anything.
+func (e Event) encode() ([]byte, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event, "\r\n") {
+		return nil, ErrInvalidEvent
+	}
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	if e.Data != "" || e.Event != "" || e.ID == "" && e.Retry == 0 {
+		data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+		for _, line := range strings.Split(data, "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind by
+// before it is dropped.
+const subscriberBuffer = 64
+
+// Feed publishes events to every subscribed stream and keeps the most
+// recent ones, so clients reconnecting with Last-Event-ID get what they
+// missed.
+type Feed struct {
+	mu     sync.Mutex
+	events []Event
+	size   int
+	seq    uint64
+	subs   map[chan Event]struct{}
+}
+
+// NewFeed returns a feed that keeps the last size events for replay.
+func NewFeed(size int) *Feed {
+	return &Feed{size: size, subs: map[chan Event]struct{}{}}
+}
+
+// Publish numbers e, unless it has an ID already, keeps it for replay and
+// sends it to the subscribers. A subscriber too far behind is dropped by
+// closing its channel; its client reconnects and catches up through the
+// replay buffer.
+func (f *Feed) Publish(e Event) Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	if e.ID == "" {
+		e.ID = strconv.FormatUint(f.seq, 10)
+	}
+	if f.size > 0 {
+		if len(f.events) == f.size {
+			f.events = append(f.events[:0], f.events[1:]...)
+		}
+		f.events = append(f.events, e)
+	}
+	for ch := range f.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+	return e
+}
+
+// Since returns the kept events published after the one with ID id. ok
+// is false when id is not among them, because it is too old or from
+// before a restart, and then every kept event is returned.
+func (f *Feed) Since(id string) (events []Event, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.since(id)
+}
+
+func (f *Feed) since(id string) ([]Event, bool) {
+	for i := len(f.events) - 1; i >= 0; i-- {
+		if f.events[i].ID == id {
+			return append([]Event(nil), f.events[i+1:]...), true
+		}
+	}
+	return append([]Event(nil), f.events...), false
+}
+
+// Subscribe returns the events a client that last saw lastID missed,
+// none when lastID is empty, and a channel of the events published from
+// then on, with no gap between the two. cancel ends the subscription.
+func (f *Feed) Subscribe(lastID string) (missed []Event, events <-chan Event, cancel func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if lastID != "" {
+		missed, _ = f.since(lastID)
+	}
+	ch := make(chan Event, subscriberBuffer)
+	f.subs[ch] = struct{}{}
+	cancel = func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subs[ch]; ok {
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+	return missed, ch, cancel
+}
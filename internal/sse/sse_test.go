@@ -0,0 +1,184 @@
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	for _, tc := range []struct {
+		event Event
+		want  string
+	}{
+		{Event{Data: "hello"}, "data: hello\n\n"},
+		{Event{ID: "7", Event: "tick", Data: "a\nb\r\nc\rd"}, "id: 7\nevent: tick\ndata: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{Event{}, "data: \n\n"},
+		{Event{Retry: 2500 * time.Millisecond}, "retry: 2500\n\n"},
+		{Event{ID: "8"}, "id: 8\n\n"},
+	} {
+		p, err := tc.event.encode()
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, string(p))
+	}
+
+	// Test: Line breaks cannot be smuggled into fields
+	_, err := Event{ID: "1\ndata: x"}.encode()
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+	_, err = Event{Event: "a\rb"}.encode()
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+}
+
+func TestFeed(t *testing.T) {
+	f := NewFeed(3)
+	for i := 1; i <= 5; i++ {
+		f.Publish(Event{Data: fmt.Sprint(i)})
+	}
+
+	// Test: Events after a kept ID are replayed
+	events, ok := f.Since("4")
+	assert.True(t, ok)
+	assert.Equal(t, []Event{{ID: "5", Data: "5"}}, events)
+
+	// Test: An ID no longer kept replays everything kept
+	events, ok = f.Since("1")
+	assert.False(t, ok)
+	assert.Len(t, events, 3)
+
+	// Test: Subscribers get what they missed and then new events
+	missed, ch, cancel := f.Subscribe("3")
+	assert.Equal(t, []Event{{ID: "4", Data: "4"}, {ID: "5", Data: "5"}}, missed)
+	assert.Equal(t, Event{ID: "x", Data: "6"}, f.Publish(Event{ID: "x", Data: "6"}))
+	assert.Equal(t, "6", (<-ch).Data)
+	cancel()
+	_, open := <-ch
+	assert.False(t, open)
+	cancel()
+
+	// Test: A subscriber that falls too far behind is dropped
+	_, ch, cancel = f.Subscribe("")
+	defer cancel()
+	for i := 0; i <= subscriberBuffer; i++ {
+		f.Publish(Event{Data: "flood"})
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	assert.Equal(t, subscriberBuffer, n)
+}
+
+// streamServer serves a stream following feed, and reports how each
+// stream ended.
+func streamServer(t *testing.T, feed *Feed, heartbeat time.Duration) (string, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 10)
+	s := server.ServeListener(listener, func(w *response.Writer, req *request.Request) {
+		stream, err := Open(w, req, heartbeat)
+		if err != nil {
+			done <- err
+			return
+		}
+		err = stream.Follow(feed)
+		stream.Close()
+		done <- err
+	})
+	t.Cleanup(func() { s.Close() })
+	return listener.Addr().String(), done
+}
+
+// connect opens a stream, and returns a reader positioned at the body.
+func connect(t *testing.T, addr, lastEventID string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	raw := "GET /events HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if lastEventID != "" {
+		raw += "Last-Event-ID: " + lastEventID + "\r\n"
+	}
+	_, err = conn.Write([]byte(raw + "\r\n"))
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	var head strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		head.WriteString(strings.ToLower(line))
+	}
+	require.Contains(t, head.String(), "content-type: text/event-stream\r\n")
+	require.Contains(t, head.String(), "transfer-encoding: chunked\r\n")
+	return conn, br
+}
+
+// readEvent reads one chunk, which holds one event or heartbeat.
+func readEvent(t *testing.T, br *bufio.Reader) string {
+	var size int
+	_, err := fmt.Fscanf(br, "%x\r\n", &size)
+	require.NoError(t, err)
+	p := make([]byte, size+2)
+	_, err = io.ReadFull(br, p)
+	require.NoError(t, err)
+	return string(p[:size])
+}
+
+// nextEvent reads the next chunk that is not a heartbeat.
+func nextEvent(t *testing.T, br *bufio.Reader) string {
+	for {
+		if e := readEvent(t, br); e != string(heartbeat) {
+			return e
+		}
+	}
+}
+
+func subscribers(f *Feed) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs)
+}
+
+func TestStream(t *testing.T) {
+	feed := NewFeed(10)
+	feed.Publish(Event{Event: "greeting", Data: "one"})
+	feed.Publish(Event{Data: "two"})
+	addr, done := streamServer(t, feed, 20*time.Millisecond)
+
+	// Test: A reconnecting client resumes after its Last-Event-ID
+	_, br := connect(t, addr, "1")
+	assert.Equal(t, "id: 2\ndata: two\n\n", nextEvent(t, br))
+
+	// Test: Published events are pushed, and heartbeats fill the silence
+	feed.Publish(Event{Data: "three"})
+	assert.Equal(t, "id: 3\ndata: three\n\n", nextEvent(t, br))
+	assert.Equal(t, ": keep-alive\n\n", readEvent(t, br))
+
+	// Test: A first connection gets only new events
+	conn, br := connect(t, addr, "")
+	require.Eventually(t, func() bool { return subscribers(feed) == 2 }, 5*time.Second, time.Millisecond)
+	feed.Publish(Event{Data: "four"})
+	assert.Equal(t, "id: 4\ndata: four\n\n", nextEvent(t, br))
+
+	// Test: The producer stops once the client goes away
+	conn.Close()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not ended after the client disconnected")
+	}
+}
@@ -0,0 +1,198 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"sync"
+	"time"
+)
+
+// DefaultHeartbeat is often enough to keep most proxies and load
+// balancers from closing an idle stream.
+const DefaultHeartbeat = 15 * time.Second
+
+var ErrClosed = errors.New("sse: stream closed")
+
+// heartbeat is a comment line, which browsers ignore.
+var heartbeat = []byte(": keep-alive\n\n")
+
+// Stream is an open text/event-stream response. Send may be called from
+// several goroutines.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu        sync.Mutex
+	lastWrite time.Time
+	err       error
+	done      chan struct{}
+	stop      chan struct{}
+	stopOnce  sync.Once
+	stopped   sync.WaitGroup
+	// unwatch stops ending the stream with the request's context
+	unwatch func() bool
+}
+
+// Open answers req with the headers of an event stream. Unless interval
+// is zero, a heartbeat is sent whenever nothing else was for that long,
+// which keeps intermediaries from dropping the connection and notices
+// when the client has gone away.
+func Open(w *response.Writer, req *request.Request, interval time.Duration) (*Stream, error) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Connection", "close")
+	if err := w.WriteStatusLine(response.StatusCodeSuccess); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		w:         w,
+		lastWrite: time.Now(),
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+	s.lastEventID, _ = req.Headers.Get("Last-Event-ID")
+	ctx := req.Context()
+	s.unwatch = context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.err == nil {
+			s.end(ctx.Err())
+		}
+	})
+	if interval > 0 {
+		s.stopped.Add(1)
+		go s.heartbeat(interval)
+	}
+	return s, nil
+}
+
+// LastEventID returns the ID of the last event the client saw before it
+// reconnected, or "" on its first connection.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the stream ends, because the client went away, the
+// request's context was cancelled or Close was called, so producers know
+// to stop.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the stream ended, or nil while it is open.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Send writes e and flushes it to the client.
+func (s *Stream) Send(e Event) error {
+	p, err := e.encode()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(p)
+}
+
+// write sends p as one chunk. Callers hold s.mu.
+func (s *Stream) write(p []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	_, err := s.w.WriteChunkedBody(p)
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		s.end(err)
+		return err
+	}
+	s.lastWrite = time.Now()
+	return nil
+}
+
+// end marks the stream as over. Callers hold s.mu.
+func (s *Stream) end(err error) {
+	s.err = err
+	close(s.done)
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	defer s.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		if time.Since(s.lastWrite) >= interval {
+			s.write(heartbeat)
+		}
+		failed := s.err != nil
+		s.mu.Unlock()
+		if failed {
+			return
+		}
+	}
+}
+
+// Follow sends the events of f the client missed, going by its
+// Last-Event-ID, and then every event published until the stream ends.
+// It returns nil when f drops the stream for falling behind, since the
+// client will reconnect and catch up.
+func (s *Stream) Follow(f *Feed) error {
+	missed, events, cancel := f.Subscribe(s.lastEventID)
+	defer cancel()
+	for _, e := range missed {
+		if err := s.Send(e); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := s.Send(e); err != nil {
+				return err
+			}
+		case <-s.done:
+			return s.Err()
+		}
+	}
+}
+
+// Close stops the heartbeat and ends the response. Sends after Close fail
+// with ErrClosed.
+func (s *Stream) Close() error {
+	s.unwatch()
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.stopped.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil
+	}
+	s.end(ErrClosed)
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(headers.NewHeaders())
+}
@@ -0,0 +1,168 @@
+package trace
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+var ErrInvalidTraceparent = errors.New("trace: invalid traceparent")
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// FlagSampled is the trace flag telling that the caller may be recording
+// the trace.
+const FlagSampled byte = 0x01
+
+// SpanContext identifies a span across processes, as carried by the W3C
+// traceparent and tracestate headers.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	// State is the vendor-specific tracestate, passed on unchanged.
+	State string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent formats sc as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01. Versions after
+// 00 are read as far as 00 goes, as the W3C recommendation asks.
+func ParseTraceparent(s string) (SpanContext, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 55 || len(s) > 55 && (s[:2] == "00" || s[55] != '-') {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	version, ok := parseHex(s[0:2])
+	if !ok || version[0] == 0xff || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	traceID, ok1 := parseHex(s[3:35])
+	spanID, ok2 := parseHex(s[36:52])
+	flags, ok3 := parseHex(s[53:55])
+	if !ok1 || !ok2 || !ok3 {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	sc := SpanContext{Flags: flags[0]}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// parseHex decodes lowercase hex, the only case traceparent allows.
+func parseHex(s string) ([]byte, bool) {
+	if strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// maxTracestateMembers is how many list members tracestate may carry.
+const maxTracestateMembers = 32
+
+// ParseTracestate checks a tracestate header and returns it with empty
+// members and surrounding spaces removed. A malformed tracestate is
+// dropped as a whole, so ok is false.
+func ParseTracestate(s string) (state string, ok bool) {
+	var members []string
+	seen := map[string]bool{}
+	for _, member := range strings.Split(s, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		key, value, found := strings.Cut(member, "=")
+		if !found || !validKey(key) || !validValue(value) || seen[key] {
+			return "", false
+		}
+		seen[key] = true
+		members = append(members, member)
+	}
+	if len(members) > maxTracestateMembers {
+		return "", false
+	}
+	return strings.Join(members, ","), true
+}
+
+// validKey checks a tracestate key: lowercase letters, digits and _-*/,
+// optionally as tenant@system.
+func validKey(key string) bool {
+	tenant, system, multi := strings.Cut(key, "@")
+	if !multi {
+		return len(key) <= 256 && validKeyPart(key, true)
+	}
+	return len(tenant) <= 241 && len(system) <= 14 && validKeyPart(tenant, false) && validKeyPart(system, true)
+}
+
+func validKeyPart(s string, letterFirst bool) bool {
+	if s == "" || letterFirst && (s[0] < 'a' || s[0] > 'z') {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("_-*/", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// validValue checks a tracestate value: up to 256 printable ASCII
+// characters other than comma and equals, not ending in a space.
+func validValue(v string) bool {
+	if v == "" || len(v) > 256 || v[len(v)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,290 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/request"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Exporter receives spans as they finish. Export must not block for long,
+// as it runs on the request path.
+type Exporter interface {
+	Export(s *Span)
+}
+
+// spanRecord is a finished span as written by FileExporter.
+type spanRecord struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	DurationMS float64           `json:"duration_ms"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// FileExporter writes each span as a line of JSON.
+type FileExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewFileExporter(w io.Writer) *FileExporter {
+	return &FileExporter{w: w}
+}
+
+func (e *FileExporter) Export(s *Span) {
+	record := spanRecord{
+		TraceID:    s.Context.TraceID.String(),
+		SpanID:     s.Context.SpanID.String(),
+		Name:       s.Name,
+		Kind:       s.Kind.String(),
+		Start:      s.Start,
+		End:        s.End,
+		DurationMS: float64(s.End.Sub(s.Start).Microseconds()) / 1000,
+		Attributes: s.Attrs(),
+		Error:      s.Err(),
+	}
+	if s.Parent.IsValid() {
+		record.ParentID = s.Parent.String()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("trace: error encoding span: %v", err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(append(line, '\n')); err != nil {
+		log.Printf("trace: error writing span: %v", err)
+	}
+}
+
+const (
+	// DefaultBatchSize is how many spans OTLPExporter sends at most in one
+	// request.
+	DefaultBatchSize = 100
+	// DefaultFlushInterval is how long OTLPExporter holds spans before
+	// sending a partial batch.
+	DefaultFlushInterval = 2 * time.Second
+	// queueSize is how many spans can wait to be sent; more are dropped.
+	queueSize = 2048
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector, as
+// OTLP/HTTP with JSON encoding. Spans are queued and sent in the
+// background; when the collector falls behind they are dropped rather than
+// slowing requests down.
+type OTLPExporter struct {
+	// Endpoint is the collector's traces URL, such as
+	// http://localhost:4318/v1/traces.
+	Endpoint    string
+	ServiceName string
+	Client      *client.Client
+
+	queue   chan *Span
+	flush   chan chan struct{}
+	done    chan struct{}
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// NewOTLPExporter starts an exporter sending to endpoint. Close flushes
+// and stops it.
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	e := &OTLPExporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Client:      client.New(),
+		queue:       make(chan *Span, queueSize),
+		flush:       make(chan chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) Export(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Flush sends the spans queued so far and waits until that is done.
+func (e *OTLPExporter) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case e.flush <- flushed:
+		<-flushed
+	case <-e.done:
+	}
+}
+
+// Close sends the spans still queued and stops the exporter.
+func (e *OTLPExporter) Close() error {
+	e.once.Do(func() {
+		e.Flush()
+		close(e.done)
+	})
+	return nil
+}
+
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(DefaultFlushInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	send := func() {
+		if len(batch) > 0 {
+			e.send(batch)
+			batch = nil
+		}
+		if n := e.dropped.Swap(0); n > 0 {
+			log.Printf("trace: dropped %d spans, the collector is falling behind", n)
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= DefaultBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-e.flush:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			close(flushed)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []*Span) {
+	body, err := json.Marshal(otlpRequest(e.ServiceName, spans))
+	if err != nil {
+		log.Printf("trace: error encoding spans: %v", err)
+		return
+	}
+	req, err := request.NewBuilder("POST", e.Endpoint).
+		Header("Content-Type", "application/json").
+		Body(body).
+		Build()
+	if err != nil {
+		log.Printf("trace: error building export request: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := e.Client.Do(ctx, req)
+	if err != nil {
+		log.Printf("trace: error exporting %d spans: %v", len(spans), err)
+		return
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode >= 300 {
+		log.Printf("trace: collector refused %d spans with status %d", len(spans), res.StatusCode)
+	}
+}
+
+// OTLP JSON encoding of ExportTraceServiceRequest, limited to the fields
+// spans here have.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		TraceState        string         `json:"traceState,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Message string `json:"message,omitempty"`
+		Code    int    `json:"code"`
+	}
+)
+
+// otlpStatusError is STATUS_CODE_ERROR.
+const otlpStatusError = 2
+
+func otlpRequest(serviceName string, spans []*Span) otlpTraces {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			TraceState:        s.Context.State,
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        keyValues(s.Attrs()),
+		}
+		if s.Parent.IsValid() {
+			o.ParentSpanID = s.Parent.String()
+		}
+		if err := s.Err(); err != "" {
+			o.Status = &otlpStatus{Message: err, Code: otlpStatusError}
+		}
+		encoded[i] = o
+	}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: keyValues(map[string]string{"service.name": serviceName})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "httpfromtcp/internal/trace"}, Spans: encoded}},
+	}}}
+}
+
+// keyValues returns attrs sorted by key.
+func keyValues(attrs map[string]string) []otlpKeyValue {
+	var kvs []otlpKeyValue
+	for k, v := range attrs {
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs
+}
@@ -0,0 +1,74 @@
+package trace
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"strconv"
+	"time"
+)
+
+// FromRequest returns the span context a caller sent in the traceparent
+// and tracestate headers, or an invalid one when there is none or it is
+// malformed.
+func FromRequest(req *request.Request) SpanContext {
+	v, ok := req.Headers.Get("traceparent")
+	if !ok {
+		return SpanContext{}
+	}
+	sc, err := ParseTraceparent(v)
+	if err != nil {
+		return SpanContext{}
+	}
+	if state, ok := req.Headers.Get("tracestate"); ok {
+		sc.State, _ = ParseTracestate(state)
+	}
+	return sc
+}
+
+// Inject sets the traceparent and tracestate headers of an outgoing
+// request to continue the trace from s. Without a span it does nothing,
+// so whatever the caller sent is passed on.
+func Inject(req *request.Request, s *Span) {
+	if s == nil {
+		return
+	}
+	req.Headers.Override("traceparent", s.Context.Traceparent())
+	req.Headers.Remove("tracestate")
+	if s.Context.State != "" {
+		req.Headers.Override("tracestate", s.Context.State)
+	}
+}
+
+// Middleware traces each request, continuing the trace the caller sent in
+// traceparent, if any. A server span covers the request from when the
+// server started reading it, with child spans for parsing it and for
+// running the handler, under which the handler can start its own, such
+// as the proxy's upstream span.
+func (t *Tracer) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := req.ReadStart
+			if start.IsZero() {
+				start = time.Now()
+			}
+			span := t.start("request", KindServer, FromRequest(req), start)
+			span.SetAttr("http.method", req.RequestLine.Method)
+			span.SetAttr("http.target", req.RequestLine.RequestTarget)
+			span.SetAttr("client.address", req.ClientIP)
+			if id, ok := req.Headers.Get("X-Request-ID"); ok {
+				span.SetAttr("request_id", id)
+			}
+			if !req.ReadDone.IsZero() {
+				t.start("parse", KindInternal, span.Context, start).finishAt(req.ReadDone)
+			}
+
+			handler := t.start("handler", KindInternal, span.Context, time.Now())
+			next(w, req.WithContext(ContextWithSpan(req.Context(), handler)))
+			handler.Finish()
+
+			span.SetAttr("http.status_code", strconv.Itoa(int(w.StatusCode())))
+			span.Finish()
+		}
+	}
+}
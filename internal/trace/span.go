@@ -0,0 +1,165 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kind is the role of a span, as in OpenTelemetry.
+type Kind int
+
+const (
+	KindInternal Kind = iota + 1
+	KindServer
+	KindClient
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindInternal:
+		return "internal"
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Span is a timed phase of work in a trace. A nil *Span records nothing,
+// so code that may run without a tracer can use the span it is given
+// without checking.
+type Span struct {
+	Name    string
+	Kind    Kind
+	Context SpanContext
+	// Parent is the span this one is a child of, invalid for the root of a
+	// trace.
+	Parent SpanID
+	Start  time.Time
+	End    time.Time
+
+	tracer *Tracer
+
+	mu    sync.Mutex
+	attrs map[string]string
+	err   string
+	ended bool
+}
+
+// SetAttr records key=value on the span.
+func (s *Span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// SetError marks the span as failed with err.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// Attrs returns a copy of the attributes recorded so far.
+func (s *Span) Attrs() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs := make(map[string]string, len(s.attrs))
+	for k, v := range s.attrs {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// Err returns the message of the error the span failed with, if any.
+func (s *Span) Err() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Finish ends the span now and exports it if the trace is sampled. Later
+// calls do nothing.
+func (s *Span) Finish() {
+	s.finishAt(time.Now())
+}
+
+func (s *Span) finishAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = end
+	s.mu.Unlock()
+	if s.Context.Sampled() {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// Tracer starts spans and hands them to an exporter when they finish.
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// start begins a span that is a child of parent, or the root of a new
+// sampled trace when parent is invalid.
+func (t *Tracer) start(name string, kind Kind, parent SpanContext, start time.Time) *Span {
+	s := &Span{
+		Name:    name,
+		Kind:    kind,
+		Context: parent,
+		Parent:  parent.SpanID,
+		Start:   start,
+		tracer:  t,
+		attrs:   map[string]string{},
+	}
+	if !parent.IsValid() {
+		s.Context = SpanContext{TraceID: newTraceID(), Flags: FlagSampled}
+	}
+	s.Context.SpanID = newSpanID()
+	return s
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying s, under which Start
+// begins child spans.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span ctx carries, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start begins a child of the span ctx carries, and returns it with a
+// context carrying it. Without a span in ctx, nothing is traced and the
+// span is nil.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := parent.tracer.start(name, kind, parent.Context, time.Now())
+	return ContextWithSpan(ctx, s), s
+}
@@ -0,0 +1,204 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceparent(t *testing.T) {
+	// Test: A valid traceparent round-trips
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(header)
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, header, sc.Traceparent())
+
+	// Test: Later versions are read as far as version 00 goes
+	sc, err = ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-what-comes-next")
+	require.NoError(t, err)
+	assert.False(t, sc.Sampled())
+
+	// Test: Malformed traceparents are refused
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(bad)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, bad)
+	}
+
+	// Test: Tracestate is tidied, or dropped when malformed
+	state, ok := ParseTracestate(" rojo=00f067aa0ba902b7 ,, congo=t61rcWkgMzE,tenant@vendor=x ")
+	assert.True(t, ok)
+	assert.Equal(t, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE,tenant@vendor=x", state)
+	for _, bad := range []string{"Rojo=1", "rojo", "rojo=1,rojo=2", "rojo=a,b", "rojo=" + strings.Repeat("a", 257)} {
+		_, ok := ParseTracestate(bad)
+		assert.False(t, ok, bad)
+	}
+	_, ok = ParseTracestate(strings.Repeat("k=v,", 33))
+	assert.False(t, ok)
+}
+
+// decodeSpans reads the JSON lines FileExporter wrote, by span name.
+func decodeSpans(t *testing.T, out *bytes.Buffer) map[string]spanRecord {
+	spans := map[string]spanRecord{}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var s spanRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &s))
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+func TestMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTracer(NewFileExporter(&out))
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		_, span := Start(req.Context(), "work", KindInternal)
+		span.SetError(errors.New("out of coffee"))
+		span.Finish()
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}, tracer.Middleware())
+	serve := func(raw string) map[string]spanRecord {
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		req.ReadDone = time.Now()
+		req.ReadStart = req.ReadDone.Add(-time.Millisecond)
+		out.Reset()
+		handler(response.NewRecorder().Writer, req)
+		return decodeSpans(t, &out)
+	}
+
+	// Test: The caller's trace is continued, with a span for each phase
+	spans := serve("GET /a HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: abc\r\n" +
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n\r\n")
+	require.Len(t, spans, 4)
+	root, parse, handle, work := spans["request"], spans["parse"], spans["handler"], spans["work"]
+	for _, s := range spans {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.TraceID, s.Name)
+	}
+	assert.Equal(t, "00f067aa0ba902b7", root.ParentID)
+	assert.Equal(t, "server", root.Kind)
+	assert.Equal(t, root.SpanID, parse.ParentID)
+	assert.Equal(t, root.SpanID, handle.ParentID)
+	assert.Equal(t, handle.SpanID, work.ParentID)
+	assert.Equal(t, map[string]string{
+		"http.method":      "GET",
+		"http.target":      "/a",
+		"http.status_code": "200",
+		"client.address":   "",
+		"request_id":       "abc",
+	}, root.Attributes)
+	assert.InDelta(t, 1, parse.DurationMS, 0.01)
+	assert.Equal(t, "out of coffee", work.Error)
+
+	// Test: Without a valid traceparent a new trace is started
+	spans = serve("GET / HTTP/1.1\r\nHost: localhost\r\ntraceparent: garbage\r\n\r\n")
+	require.Len(t, spans, 4)
+	assert.Empty(t, spans["request"].ParentID)
+	assert.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans["request"].TraceID)
+
+	// Test: Traces the caller does not sample are not recorded
+	spans = serve("GET / HTTP/1.1\r\nHost: localhost\r\n" +
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00\r\n\r\n")
+	assert.Empty(t, spans)
+
+	// Test: Nothing is traced without a span in the context
+	ctx, span := Start(context.Background(), "orphan", KindInternal)
+	assert.Nil(t, span)
+	assert.Nil(t, SpanFromContext(ctx))
+	span.SetAttr("a", "b")
+	span.Finish()
+
+	// Test: Inject continues the trace in an outgoing request
+	out.Reset()
+	parent := tracer.start("handler", KindInternal, SpanContext{}, time.Now())
+	parent.Context.State = "rojo=1"
+	_, child := Start(ContextWithSpan(context.Background(), parent), "upstream", KindClient)
+	req, err := request.NewBuilder("GET", "http://origin/").Header("tracestate", "stale=1").Build()
+	require.NoError(t, err)
+	Inject(req, child)
+	sc, err := ParseTraceparent(req.Headers["traceparent"])
+	require.NoError(t, err)
+	assert.Equal(t, parent.Context.TraceID, sc.TraceID)
+	assert.Equal(t, child.Context.SpanID, sc.SpanID)
+	assert.Equal(t, "rojo=1", req.Headers["tracestate"])
+}
+
+func TestOTLPExporter(t *testing.T) {
+	bodies := make(chan []byte, 4)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	collector := server.ServeListener(listener, func(w *response.Writer, req *request.Request) {
+		bodies <- req.Body
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+	defer collector.Close()
+
+	exporter := NewOTLPExporter("http://"+listener.Addr().String()+"/v1/traces", "httpserver")
+	defer exporter.Close()
+	tracer := NewTracer(exporter)
+	root := tracer.start("request", KindServer, SpanContext{}, time.Unix(1, 0))
+	root.SetAttr("http.method", "GET")
+	child := tracer.start("upstream", KindClient, root.Context, time.Unix(1, 500))
+	child.SetError(errors.New("refused"))
+	child.finishAt(time.Unix(2, 0))
+	root.finishAt(time.Unix(3, 0))
+
+	// Test: Flushed spans reach the collector in one OTLP/JSON request
+	exporter.Flush()
+	var body otlpTraces
+	require.NoError(t, json.Unmarshal(<-bodies, &body))
+	require.Len(t, body.ResourceSpans, 1)
+	resource := body.ResourceSpans[0]
+	assert.Equal(t, []otlpKeyValue{{Key: "service.name", Value: otlpValue{StringValue: "httpserver"}}}, resource.Resource.Attributes)
+	require.Len(t, resource.ScopeSpans, 1)
+	spans := resource.ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, otlpSpan{
+		TraceID:           root.Context.TraceID.String(),
+		SpanID:            child.Context.SpanID.String(),
+		ParentSpanID:      root.Context.SpanID.String(),
+		Name:              "upstream",
+		Kind:              int(KindClient),
+		StartTimeUnixNano: "1000000500",
+		EndTimeUnixNano:   "2000000000",
+		Status:            &otlpStatus{Message: "refused", Code: otlpStatusError},
+	}, spans[0])
+	assert.Equal(t, "request", spans[1].Name)
+	assert.Empty(t, spans[1].ParentSpanID)
+	assert.Equal(t, []otlpKeyValue{{Key: "http.method", Value: otlpValue{StringValue: "GET"}}}, spans[1].Attributes)
+
+	// Test: Flushing with nothing queued sends nothing
+	exporter.Flush()
+	select {
+	case <-bodies:
+		t.Fatal("empty batch sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
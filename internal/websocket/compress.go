@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"strings"
+)
+
+// deflateResponse is the only permessage-deflate configuration accepted:
+// both sides start a fresh deflate context for every message, which costs
+// some ratio but keeps no per-connection compressor state (RFC 7692).
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// deflateTail ends every compressed message and is left off the wire.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// negotiateDeflate reports whether one of the permessage-deflate offers in
+// h can be accepted. Offers asking for a server window smaller than the
+// 32KB compress/flate always uses are declined.
+func negotiateDeflate(h headers.Headers) bool {
+	v, ok := h.Get("Sec-WebSocket-Extensions")
+	if !ok {
+		return false
+	}
+	for _, offer := range strings.Split(v, ",") {
+		params := strings.Split(offer, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "permessage-deflate") {
+			continue
+		}
+		acceptable := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(param, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				acceptable = acceptable && value == "15"
+			default:
+				acceptable = false
+			}
+		}
+		if acceptable {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsDeflate reports whether a server's handshake response enabled
+// permessage-deflate on the terms a client offered with deflateResponse.
+func acceptsDeflate(h headers.Headers) bool {
+	v, ok := h.Get("Sec-WebSocket-Extensions")
+	if !ok {
+		return false
+	}
+	params := strings.Split(v, ";")
+	return strings.EqualFold(strings.TrimSpace(params[0]), "permessage-deflate")
+}
+
+func compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(p); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+// decompress inflates a message of at most limit bytes, reporting
+// errMessageTooBig for anything longer.
+func decompress(p []byte, limit int64) ([]byte, error) {
+	// the final empty stored block lets the reader end cleanly
+	r := flate.NewReader(io.MultiReader(
+		bytes.NewReader(p),
+		bytes.NewReader(deflateTail),
+		bytes.NewReader([]byte{0x01, 0x00, 0x00, 0xff, 0xff}),
+	))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCompression, err)
+	}
+	if int64(len(out)) > limit {
+		return nil, ErrMessageTooBig
+	}
+	return out, nil
+}
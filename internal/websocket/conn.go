@@ -0,0 +1,403 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = MessageType(opText)
+	BinaryMessage MessageType = MessageType(opBinary)
+)
+
+// Close codes (RFC 6455 section 7.4.1).
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseMandatoryExt    = 1010
+	CloseInternalError   = 1011
+	CloseServiceRestart  = 1012
+	CloseTryAgainLater   = 1013
+	CloseBadGateway      = 1014
+)
+
+const (
+	// DefaultReadLimit bounds incoming messages unless the Upgrader or
+	// Dialer sets another limit.
+	DefaultReadLimit = 1 << 20
+	// closeTimeout is how long Close waits for the peer to answer.
+	closeTimeout = 5 * time.Second
+)
+
+var (
+	ErrMessageTooBig      = errors.New("websocket: message too big")
+	ErrClosed             = errors.New("websocket: connection closed")
+	errInvalidUTF8        = errors.New("websocket: invalid UTF-8 in text message")
+	errInvalidCompression = errors.New("websocket: invalid compressed message")
+)
+
+// CloseError is returned by ReadMessage once the peer closed the
+// connection. Code is CloseNoStatus when the peer gave none.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with code %d: %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others
+// write: writes are serialised, and pings are answered by the reader.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	server bool
+	// deflate is set when permessage-deflate was negotiated
+	deflate bool
+	// Subprotocol is the subprotocol agreed in the handshake, if any.
+	Subprotocol string
+
+	// readMu is held by ReadMessage, and by Close when nothing is reading
+	readMu    sync.Mutex
+	readLimit int64
+	readErr   error
+	onPong    func([]byte)
+
+	writeMu      sync.Mutex
+	closeSent    bool
+	fragmentSize int
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, server bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, server: server, readLimit: DefaultReadLimit}
+}
+
+// NetConn returns the underlying connection, for setting deadlines.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// SetReadLimit bounds the size of incoming messages, after decompression.
+// A larger message closes the connection with CloseMessageTooBig.
+func (c *Conn) SetReadLimit(n int64) {
+	c.readLimit = n
+}
+
+// SetFragmentSize splits outgoing messages into frames of at most n bytes
+// of payload. Zero sends every message in a single frame.
+func (c *Conn) SetFragmentSize(n int) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.fragmentSize = n
+}
+
+// SetPongHandler sets a function called with the payload of each pong,
+// from the goroutine calling ReadMessage.
+func (c *Conn) SetPongHandler(fn func(data []byte)) {
+	c.onPong = fn
+}
+
+// ReadMessage returns the next data message, reassembling fragments and
+// answering control frames on the way. After the peer closes the
+// connection it returns a *CloseError, and after any other failure the
+// connection is closed, so every later call fails the same way.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	typ, msg, err := c.readMessage()
+	if err != nil {
+		c.readErr = c.fail(err)
+		return 0, nil, c.readErr
+	}
+	return typ, msg, nil
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var msg []byte
+	compressed := false
+	for {
+		h, err := readFrameHeader(c.br)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err := c.checkFrame(h, typ != 0); err != nil {
+			return 0, nil, err
+		}
+		if !h.opcode.control() && int64(len(msg))+h.length > c.readLimit {
+			return 0, nil, ErrMessageTooBig
+		}
+
+		payload := make([]byte, h.length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return 0, nil, err
+		}
+		if h.masked {
+			maskBytes(h.mask, 0, payload)
+		}
+
+		switch h.opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload, false); err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if c.onPong != nil {
+				c.onPong(payload)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.closed(payload)
+		case opText, opBinary:
+			typ = MessageType(h.opcode)
+			compressed = h.rsv&rsv1Bit != 0
+		}
+
+		msg = append(msg, payload...)
+		if h.fin {
+			break
+		}
+	}
+
+	if compressed {
+		var err error
+		msg, err = decompress(msg, c.readLimit)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if typ == TextMessage && !utf8.Valid(msg) {
+		return 0, nil, errInvalidUTF8
+	}
+	return typ, msg, nil
+}
+
+// checkFrame checks the rules a frame header must follow given whether a
+// fragmented message is in progress.
+func (c *Conn) checkFrame(h frameHeader, fragmented bool) error {
+	// clients mask every frame, servers none (RFC 6455 section 5.1)
+	if h.masked != c.server {
+		return fmt.Errorf("%w: wrong masking", errProtocol)
+	}
+	rsv1 := h.rsv&rsv1Bit != 0
+	if h.rsv&^rsv1Bit != 0 || rsv1 && (!c.deflate || h.opcode.control() || h.opcode == opContinuation) {
+		return fmt.Errorf("%w: unexpected reserved bits", errProtocol)
+	}
+	switch {
+	case h.opcode == opContinuation && !fragmented:
+		return fmt.Errorf("%w: continuation without a message", errProtocol)
+	case (h.opcode == opText || h.opcode == opBinary) && fragmented:
+		return fmt.Errorf("%w: new message inside a fragmented one", errProtocol)
+	}
+	return nil
+}
+
+// closed handles a close frame from the peer: it is echoed unless this
+// side closed first, and then the connection is shut.
+func (c *Conn) closed(payload []byte) error {
+	code, reason := CloseNoStatus, ""
+	switch {
+	case len(payload) == 1:
+		return fmt.Errorf("%w: truncated close code", errProtocol)
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		reason = string(payload[2:])
+		if !validCloseCode(code) {
+			return fmt.Errorf("%w: invalid close code %d", errProtocol, code)
+		}
+		if !utf8.ValidString(reason) {
+			return errInvalidUTF8
+		}
+	}
+
+	reply := payload[:min(len(payload), 2)]
+	if err := c.writeFrame(opClose, reply, false); err != nil && !errors.Is(err, ErrClosed) {
+		c.conn.Close()
+		return err
+	}
+	c.conn.Close()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// fail closes the connection after a read error, telling the peer why
+// when the error is its fault. The error ReadMessage returns is passed
+// back.
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		return err
+	}
+
+	code := 0
+	switch {
+	case errors.Is(err, errProtocol):
+		code = CloseProtocolError
+	case errors.Is(err, ErrMessageTooBig):
+		code = CloseMessageTooBig
+	case errors.Is(err, errInvalidUTF8), errors.Is(err, errInvalidCompression):
+		code = CloseInvalidPayload
+	}
+	if code != 0 {
+		c.writeFrame(opClose, closePayload(code, ""), false)
+	}
+	c.conn.Close()
+	return err
+}
+
+// WriteMessage sends a text or binary message. Text must be valid UTF-8.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	if typ == TextMessage && !utf8.Valid(data) {
+		return errInvalidUTF8
+	}
+	compressed := false
+	if c.deflate && len(data) > 0 {
+		var err error
+		data, err = compress(data)
+		if err != nil {
+			return err
+		}
+		compressed = true
+	}
+	return c.writeFrame(opcode(typ), data, compressed)
+}
+
+// Ping sends a ping with an optional payload of up to 125 bytes. The
+// peer's pong goes to the pong handler.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: ping payload too long")
+	}
+	return c.writeFrame(opPing, data, false)
+}
+
+// Close starts the close handshake with code and reason, waits briefly
+// for the peer to answer and closes the connection. If another goroutine
+// is in ReadMessage, that call sees the answer and returns a *CloseError.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.writeFrame(opClose, closePayload(code, reason), false)
+	if errors.Is(err, ErrClosed) {
+		return nil
+	}
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	if !c.readMu.TryLock() {
+		// the reader gets the answer, or times out, and closes
+		return nil
+	}
+	defer c.readMu.Unlock()
+	for c.readErr == nil {
+		if _, _, err := c.readMessage(); err != nil {
+			c.readErr = err
+		}
+	}
+	// the answer has closed the connection already, unless it never came
+	c.conn.Close()
+	return nil
+}
+
+// writeFrame sends one message, fragmented if a fragment size is set, or
+// one control frame. Nothing more is sent after a close frame.
+func (c *Conn) writeFrame(op opcode, payload []byte, compressed bool) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+
+	size := len(payload)
+	if c.fragmentSize > 0 && !op.control() {
+		size = c.fragmentSize
+	}
+	var buf []byte
+	for first := true; first || len(payload) > 0; first = false {
+		n := min(size, len(payload))
+		h := frameHeader{fin: n == len(payload), opcode: opContinuation, length: int64(n), masked: !c.server}
+		if first {
+			h.opcode = op
+			if compressed {
+				h.rsv = rsv1Bit
+			}
+		}
+		if h.masked {
+			if _, err := rand.Read(h.mask[:]); err != nil {
+				return err
+			}
+		}
+
+		buf = appendFrameHeader(buf[:0], h)
+		start := len(buf)
+		buf = append(buf, payload[:n]...)
+		if h.masked {
+			maskBytes(h.mask, 0, buf[start:])
+		}
+		if _, err := c.conn.Write(buf); err != nil {
+			return err
+		}
+		payload = payload[n:]
+	}
+	return nil
+}
+
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatus {
+		return nil
+	}
+	// the reason has to fit a control frame
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+		for !utf8.ValidString(reason) {
+			reason = reason[:len(reason)-1]
+		}
+	}
+	p := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(p, reason...)
+}
+
+// validCloseCode reports whether code may appear in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	return code != 1004 && code != CloseNoStatus && code != CloseAbnormal
+}
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xA
+)
+
+func (op opcode) control() bool {
+	return op&0x8 != 0
+}
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsvBits = 0x70
+	maskBit = 0x80
+
+	// maxControlPayload is the largest payload of a control frame (RFC
+	// 6455 section 5.5).
+	maxControlPayload = 125
+)
+
+// frameHeader is the header of one frame (RFC 6455 section 5.2).
+type frameHeader struct {
+	fin    bool
+	rsv    byte
+	opcode opcode
+	masked bool
+	mask   [4]byte
+	length int64
+}
+
+var errProtocol = errors.New("websocket: protocol error")
+
+func readFrameHeader(r io.Reader) (frameHeader, error) {
+	var h frameHeader
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:2]); err != nil {
+		return h, err
+	}
+	h.fin = b[0]&finBit != 0
+	h.rsv = b[0] & rsvBits
+	h.opcode = opcode(b[0] & 0x0f)
+	h.masked = b[1]&maskBit != 0
+	h.length = int64(b[1] & 0x7f)
+
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(r, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+		if h.length < 126 {
+			return h, fmt.Errorf("%w: length not minimally encoded", errProtocol)
+		}
+	case 127:
+		if _, err := io.ReadFull(r, b[:8]); err != nil {
+			return h, err
+		}
+		n := binary.BigEndian.Uint64(b[:8])
+		if n>>63 != 0 || n <= 0xffff {
+			return h, fmt.Errorf("%w: invalid frame length", errProtocol)
+		}
+		h.length = int64(n)
+	}
+
+	if h.masked {
+		if _, err := io.ReadFull(r, h.mask[:]); err != nil {
+			return h, err
+		}
+	}
+
+	switch h.opcode {
+	case opContinuation, opText, opBinary:
+	case opClose, opPing, opPong:
+		if !h.fin {
+			return h, fmt.Errorf("%w: fragmented control frame", errProtocol)
+		}
+		if h.length > maxControlPayload {
+			return h, fmt.Errorf("%w: control frame too long", errProtocol)
+		}
+	default:
+		return h, fmt.Errorf("%w: unknown opcode %#x", errProtocol, byte(h.opcode))
+	}
+	return h, nil
+}
+
+// appendFrameHeader encodes h in front of a payload of h.length bytes.
+func appendFrameHeader(b []byte, h frameHeader) []byte {
+	first := byte(h.opcode) | h.rsv
+	if h.fin {
+		first |= finBit
+	}
+	second := byte(0)
+	if h.masked {
+		second |= maskBit
+	}
+
+	switch {
+	case h.length < 126:
+		b = append(b, first, second|byte(h.length))
+	case h.length <= 0xffff:
+		b = append(b, first, second|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(h.length))
+	default:
+		b = append(b, first, second|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(h.length))
+	}
+	if h.masked {
+		b = append(b, h.mask[:]...)
+	}
+	return b
+}
+
+// maskBytes XORs p with the masking key, starting at offset pos of the
+// payload, and returns the offset after p.
+func maskBytes(mask [4]byte, pos int, p []byte) int {
+	for i := range p {
+		p[i] ^= mask[(pos+i)&3]
+	}
+	return (pos + len(p)) & 3
+}
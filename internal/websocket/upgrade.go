@@ -0,0 +1,279 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/problem"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// acceptGUID is appended to the client's key to compute
+// Sec-WebSocket-Accept (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Upgrader turns HTTP requests into WebSocket connections.
+type Upgrader struct {
+	// Subprotocols are the subprotocols the server speaks, in order of
+	// preference.
+	Subprotocols []string
+	// CheckOrigin reports whether a browser on the request's Origin may
+	// connect. When nil, only requests without an Origin or from an
+	// origin with the same host as the request are accepted, so other
+	// sites cannot open connections with the user's cookies.
+	CheckOrigin func(req *request.Request) bool
+	// EnableCompression accepts permessage-deflate when clients offer it.
+	EnableCompression bool
+	// ReadLimit bounds incoming messages; zero means DefaultReadLimit.
+	ReadLimit int64
+}
+
+// Upgrade validates the opening handshake in req, answers it with 101
+// Switching Protocols and takes over the connection. When the handshake
+// is invalid it responds with an error status itself and returns an
+// error wrapping ErrBadHandshake.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if req.RequestLine.Method != "GET" {
+		refuse(w, req, response.StatusCodeMethodNotAllowed, "Allow", "GET")
+		return nil, fmt.Errorf("%w: method %s", ErrBadHandshake, req.RequestLine.Method)
+	}
+	if !hasToken(req.Headers, "Connection", "upgrade") || !hasToken(req.Headers, "Upgrade", "websocket") {
+		refuse(w, req, response.StatusCodeUpgradeRequired, "Upgrade", "websocket")
+		return nil, fmt.Errorf("%w: not a websocket upgrade", ErrBadHandshake)
+	}
+	if v, _ := req.Headers.Get("Sec-WebSocket-Version"); strings.TrimSpace(v) != "13" {
+		refuse(w, req, response.StatusCodeUpgradeRequired, "Sec-WebSocket-Version", "13")
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrBadHandshake, v)
+	}
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	key = strings.TrimSpace(key)
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		refuse(w, req, response.StatusCodeBadRequest)
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Key", ErrBadHandshake)
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		refuse(w, req, response.StatusCodeForbidden)
+		return nil, fmt.Errorf("%w: origin not allowed", ErrBadHandshake)
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", acceptKey(key))
+	subprotocol := u.subprotocol(req.Headers)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	deflate := u.EnableCompression && negotiateDeflate(req.Headers)
+	if deflate {
+		h.Set("Sec-WebSocket-Extensions", deflateResponse)
+	}
+	if err := w.WriteStatusLine(response.StatusCodeSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	netConn, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	c := newConn(netConn, nil, true)
+	c.deflate = deflate
+	c.Subprotocol = subprotocol
+	if u.ReadLimit > 0 {
+		c.readLimit = u.ReadLimit
+	}
+	return c, nil
+}
+
+// subprotocol picks the server's most preferred subprotocol the client
+// also offered.
+func (u *Upgrader) subprotocol(h headers.Headers) string {
+	v, ok := h.Get("Sec-WebSocket-Protocol")
+	if !ok {
+		return ""
+	}
+	var offered []string
+	for _, p := range strings.Split(v, ",") {
+		offered = append(offered, strings.TrimSpace(p))
+	}
+	for _, p := range u.Subprotocols {
+		if slices.Contains(offered, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// IsUpgrade reports whether req asks to switch to WebSocket, so handlers
+// can serve a page and its socket on the same path.
+func IsUpgrade(req *request.Request) bool {
+	return hasToken(req.Headers, "Connection", "upgrade") && hasToken(req.Headers, "Upgrade", "websocket")
+}
+
+func sameOrigin(req *request.Request) bool {
+	origin, ok := req.Headers.Get("Origin")
+	if !ok {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host, _ := req.Headers.Get("Host")
+	return strings.EqualFold(u.Host, host)
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// hasToken reports whether the comma-separated header key lists token.
+func hasToken(h headers.Headers, key, token string) bool {
+	v, _ := h.Get(key)
+	for _, t := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// refuse answers a failed handshake. extra holds header name and value
+// pairs, telling the client what the handshake needs.
+func refuse(w *response.Writer, req *request.Request, statusCode response.StatusCode, extra ...string) {
+	w.OnWriteHeaders(func(_ response.StatusCode, h headers.Headers) {
+		for i := 0; i+1 < len(extra); i += 2 {
+			h.Set(extra[i], extra[i+1])
+		}
+	})
+	problem.Error(w, req, statusCode)
+}
+
+// Dialer opens client connections, mostly for tests and tools.
+type Dialer struct {
+	Subprotocols      []string
+	EnableCompression bool
+	// Headers are sent with the handshake, such as Origin or Cookie.
+	Headers   headers.Headers
+	ReadLimit int64
+	TLSConfig *tls.Config
+}
+
+// Dial connects to a ws:// or wss:// URL.
+func (d *Dialer) Dial(ctx context.Context, rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var netDialer net.Dialer
+	var netConn net.Conn
+	switch u.Scheme {
+	case "ws":
+		netConn, err = netDialer.DialContext(ctx, "tcp", addr)
+	case "wss":
+		tlsDialer := tls.Dialer{NetDialer: &netDialer, Config: d.TLSConfig}
+		netConn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	default:
+		return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+
+	c, err := d.handshake(netConn, u)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (d *Dialer) handshake(netConn net.Conn, u *url.URL) (*Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	b := request.NewBuilder("GET", u.RequestURI()).
+		Header("Host", u.Host).
+		Header("Upgrade", "websocket").
+		Header("Connection", "Upgrade").
+		Header("Sec-WebSocket-Key", key).
+		Header("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		b.Header("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	if d.EnableCompression {
+		b.Header("Sec-WebSocket-Extensions", deflateResponse)
+	}
+	for k, v := range d.Headers {
+		b.Header(k, v)
+	}
+	req, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := req.WriteTo(netConn); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(netConn)
+	res, err := client.ReadResponse(br, "GET")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != response.StatusCodeSwitchingProtocols {
+		return nil, fmt.Errorf("%w: status %d", ErrBadHandshake, res.StatusCode)
+	}
+	if !hasToken(res.Headers, "Upgrade", "websocket") || !hasToken(res.Headers, "Connection", "upgrade") {
+		return nil, fmt.Errorf("%w: missing upgrade headers", ErrBadHandshake)
+	}
+	if accept, _ := res.Headers.Get("Sec-WebSocket-Accept"); accept != acceptKey(key) {
+		return nil, fmt.Errorf("%w: wrong Sec-WebSocket-Accept", ErrBadHandshake)
+	}
+
+	c := newConn(netConn, br, false)
+	c.deflate = d.EnableCompression && acceptsDeflate(res.Headers)
+	c.Subprotocol, _ = res.Headers.Get("Sec-WebSocket-Protocol")
+	if d.ReadLimit > 0 {
+		c.readLimit = d.ReadLimit
+	}
+	return c, nil
+}
@@ -0,0 +1,290 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoServer upgrades every request and echoes messages until the
+// connection ends. The error that ended each connection is sent on the
+// returned channel.
+func echoServer(t *testing.T, u *Upgrader) (string, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 10)
+	s := server.ServeListener(listener, func(w *response.Writer, req *request.Request) {
+		c, err := u.Upgrade(w, req)
+		if err != nil {
+			done <- err
+			return
+		}
+		for {
+			typ, msg, err := c.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			if err := c.WriteMessage(typ, msg); err != nil {
+				done <- err
+				return
+			}
+		}
+	})
+	t.Cleanup(func() { s.Close() })
+	return listener.Addr().String(), done
+}
+
+func dial(t *testing.T, d *Dialer, addr string) *Conn {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := d.Dial(ctx, "ws://"+addr+"/chat")
+	require.NoError(t, err)
+	t.Cleanup(func() { c.NetConn().Close() })
+	return c
+}
+
+const handshake = "GET /chat HTTP/1.1\r\n" +
+	"Host: %s\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n"
+
+// rawConn completes the handshake by hand, sending extra right after it,
+// so tests can put arbitrary frames on the wire.
+func rawConn(t *testing.T, addr, extraHeaders string, extra []byte) (net.Conn, *bufio.Reader, *client.Response) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	raw := strings.Replace(handshake, "%s", addr, 1) + extraHeaders + "\r\n"
+	_, err = conn.Write(append([]byte(raw), extra...))
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	res, err := client.ReadResponse(br, "GET")
+	require.NoError(t, err)
+	return conn, br, res
+}
+
+// clientFrame encodes a masked frame as a client sends it.
+func clientFrame(first byte, payload []byte) []byte {
+	h := frameHeader{fin: first&finBit != 0, rsv: first & rsvBits, opcode: opcode(first & 0x0f), masked: true, mask: [4]byte{1, 2, 3, 4}, length: int64(len(payload))}
+	b := appendFrameHeader(nil, h)
+	start := len(b)
+	b = append(b, payload...)
+	maskBytes(h.mask, 0, b[start:])
+	return b
+}
+
+// readClose reads frames from the server until a close frame, and returns
+// its code.
+func readClose(t *testing.T, br *bufio.Reader) int {
+	for {
+		h, err := readFrameHeader(br)
+		require.NoError(t, err)
+		payload := make([]byte, h.length)
+		_, err = io.ReadFull(br, payload)
+		require.NoError(t, err)
+		if h.opcode == opClose {
+			require.GreaterOrEqual(t, len(payload), 2)
+			return int(binary.BigEndian.Uint16(payload))
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	addr, done := echoServer(t, &Upgrader{Subprotocols: []string{"chat.v2", "chat.v1"}})
+
+	// Test: The accept key matches the example in RFC 6455
+	_, _, res := rawConn(t, addr, "Sec-WebSocket-Protocol: chat.v1, chat.v2\r\n", nil)
+	assert.Equal(t, response.StatusCodeSwitchingProtocols, res.StatusCode)
+	accept, _ := res.Headers.Get("Sec-WebSocket-Accept")
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", accept)
+	upgrade, _ := res.Headers.Get("Upgrade")
+	assert.Equal(t, "websocket", upgrade)
+	protocol, _ := res.Headers.Get("Sec-WebSocket-Protocol")
+	assert.Equal(t, "chat.v2", protocol)
+	_, ok := res.Headers.Get("Sec-WebSocket-Extensions")
+	assert.False(t, ok)
+
+	// Test: A frame sent along with the handshake is not lost
+	_, br, _ := rawConn(t, addr, "", clientFrame(finBit|byte(opText), []byte("early")))
+	h, err := readFrameHeader(br)
+	require.NoError(t, err)
+	payload := make([]byte, h.length)
+	io.ReadFull(br, payload)
+	assert.Equal(t, "early", string(payload))
+	assert.False(t, h.masked)
+
+	// Test: Invalid handshakes are refused
+	for _, tc := range []struct {
+		raw    string
+		status response.StatusCode
+	}{
+		{"GET /chat HTTP/1.1\r\nHost: " + addr + "\r\n\r\n", response.StatusCodeUpgradeRequired},
+		{strings.Replace(handshake, "%s", addr, 1) + "Origin: https://evil.example\r\n\r\n", response.StatusCodeForbidden},
+		{strings.Replace(strings.Replace(handshake, "%s", addr, 1), "Version: 13", "Version: 8", 1) + "\r\n", response.StatusCodeUpgradeRequired},
+		{strings.Replace(strings.Replace(handshake, "%s", addr, 1), "dGhlIHNhbXBsZSBub25jZQ==", "short", 1) + "\r\n", response.StatusCodeBadRequest},
+		{strings.Replace(strings.Replace(handshake, "%s", addr, 1), "GET", "POST", 1) + "\r\n", response.StatusCodeMethodNotAllowed},
+	} {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		conn.Write([]byte(tc.raw))
+		res, err := client.ReadResponse(bufio.NewReader(conn), "GET")
+		require.NoError(t, err)
+		assert.Equal(t, tc.status, res.StatusCode, tc.raw)
+		conn.Close()
+		assert.ErrorIs(t, <-done, ErrBadHandshake)
+	}
+	_, _, res = rawConn(t, addr, "Origin: http://"+addr+"\r\n", nil)
+	assert.Equal(t, response.StatusCodeSwitchingProtocols, res.StatusCode)
+}
+
+func TestConn(t *testing.T) {
+	addr, done := echoServer(t, &Upgrader{EnableCompression: true, ReadLimit: 1 << 20})
+
+	for _, compress := range []bool{false, true} {
+		c := dial(t, &Dialer{EnableCompression: compress}, addr)
+		assert.Equal(t, compress, c.deflate)
+
+		// Test: Text and binary messages of every length encoding echo back
+		for _, msg := range []string{"", "hello, 世界", strings.Repeat("a", 200), strings.Repeat("b", 70000)} {
+			require.NoError(t, c.WriteMessage(TextMessage, []byte(msg)))
+			typ, got, err := c.ReadMessage()
+			require.NoError(t, err)
+			assert.Equal(t, TextMessage, typ)
+			assert.Equal(t, msg, string(got))
+		}
+		require.NoError(t, c.WriteMessage(BinaryMessage, []byte{0, 1, 2, 0xff}))
+		typ, got, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, BinaryMessage, typ)
+		assert.Equal(t, []byte{0, 1, 2, 0xff}, got)
+
+		// Test: Fragmented messages are reassembled
+		c.SetFragmentSize(3)
+		require.NoError(t, c.WriteMessage(TextMessage, []byte("fragmented message")))
+		_, got, err = c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, "fragmented message", string(got))
+		c.SetFragmentSize(0)
+
+		// Test: Pings are answered with pongs
+		var pong string
+		c.SetPongHandler(func(data []byte) { pong = string(data) })
+		require.NoError(t, c.Ping([]byte("are you there")))
+		require.NoError(t, c.WriteMessage(TextMessage, []byte("after")))
+		_, got, err = c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, "after", string(got))
+		assert.Equal(t, "are you there", pong)
+
+		// Test: Invalid text is refused before sending
+		assert.Error(t, c.WriteMessage(TextMessage, []byte{0xff}))
+
+		// Test: The close handshake reaches the other side
+		require.NoError(t, c.Close(CloseGoingAway, "bye"))
+		var closeErr *CloseError
+		require.ErrorAs(t, <-done, &closeErr)
+		assert.Equal(t, &CloseError{Code: CloseGoingAway, Reason: "bye"}, closeErr)
+		assert.ErrorIs(t, c.WriteMessage(TextMessage, []byte("late")), ErrClosed)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	addr, done := echoServer(t, &Upgrader{ReadLimit: 100})
+
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{"unmasked frame", appendFrameHeader(nil, frameHeader{fin: true, opcode: opText, length: 2}), CloseProtocolError},
+		{"invalid UTF-8", clientFrame(finBit|byte(opText), []byte{'a', 0xc3, 0x28}), CloseInvalidPayload},
+		{"too large", clientFrame(finBit|byte(opBinary), make([]byte, 101)), CloseMessageTooBig},
+		{"lone continuation", clientFrame(finBit|byte(opContinuation), []byte("x")), CloseProtocolError},
+		{"long ping", clientFrame(finBit|byte(opPing), make([]byte, 126)), CloseProtocolError},
+		{"fragmented ping", clientFrame(byte(opPing), nil), CloseProtocolError},
+		{"reserved bit", clientFrame(finBit|rsv1Bit|byte(opText), []byte("x")), CloseProtocolError},
+		{"unknown opcode", clientFrame(finBit|0x3, nil), CloseProtocolError},
+		{"interleaved message", append(clientFrame(byte(opText), []byte("a")), clientFrame(finBit|byte(opText), []byte("b"))...), CloseProtocolError},
+		{"bad close code", clientFrame(finBit|byte(opClose), []byte{0x03, 0xed}), CloseProtocolError},
+	} {
+		conn, br, res := rawConn(t, addr, "", nil)
+		require.Equal(t, response.StatusCodeSwitchingProtocols, res.StatusCode)
+		conn.Write(tc.frame)
+		assert.Equal(t, tc.code, readClose(t, br), tc.name)
+		err := <-done
+		assert.Error(t, err, tc.name)
+		var closeErr *CloseError
+		assert.False(t, errors.As(err, &closeErr), tc.name)
+	}
+
+	// Test: Control frames may arrive between fragments
+	conn, br, _ := rawConn(t, addr, "", nil)
+	var frames bytes.Buffer
+	frames.Write(clientFrame(byte(opText), []byte("hel")))
+	frames.Write(clientFrame(finBit|byte(opPing), []byte("p")))
+	frames.Write(clientFrame(finBit|byte(opContinuation), []byte("lo")))
+	conn.Write(frames.Bytes())
+	h, err := readFrameHeader(br)
+	require.NoError(t, err)
+	assert.Equal(t, opPong, h.opcode)
+	io.CopyN(io.Discard, br, h.length)
+	h, err = readFrameHeader(br)
+	require.NoError(t, err)
+	payload := make([]byte, h.length)
+	io.ReadFull(br, payload)
+	assert.Equal(t, "hello", string(payload))
+
+	// Test: A close without a code is answered with an empty close
+	conn.Write(clientFrame(finBit|byte(opClose), nil))
+	h, err = readFrameHeader(br)
+	require.NoError(t, err)
+	assert.Equal(t, opClose, h.opcode)
+	assert.Equal(t, int64(0), h.length)
+	var closeErr *CloseError
+	require.ErrorAs(t, <-done, &closeErr)
+	assert.Equal(t, CloseNoStatus, closeErr.Code)
+}
+
+func TestNegotiateDeflate(t *testing.T) {
+	h := func(v string) map[string]string { return map[string]string{"sec-websocket-extensions": v} }
+
+	assert.True(t, negotiateDeflate(h("permessage-deflate")))
+	assert.True(t, negotiateDeflate(h("permessage-deflate; client_max_window_bits")))
+	assert.True(t, negotiateDeflate(h("permessage-deflate; server_max_window_bits=10, permessage-deflate")))
+	assert.False(t, negotiateDeflate(h("permessage-deflate; server_max_window_bits=10")))
+	assert.False(t, negotiateDeflate(h("x-webkit-deflate-frame")))
+	assert.False(t, negotiateDeflate(h("permessage-deflate; unknown_param")))
+	assert.False(t, negotiateDeflate(map[string]string{}))
+
+	// Test: Compressed messages round-trip, and the limit applies after
+	// inflating
+	msg := []byte(strings.Repeat("compress me ", 1000))
+	compressed, err := compress(msg)
+	require.NoError(t, err)
+	assert.Less(t, len(compressed), len(msg)/10)
+	got, err := decompress(compressed, int64(len(msg)))
+	require.NoError(t, err)
+	assert.Equal(t, msg, got)
+	_, err = decompress(compressed, int64(len(msg)-1))
+	assert.ErrorIs(t, err, ErrMessageTooBig)
+	_, err = decompress([]byte{0xff, 0xff, 0xff}, 100)
+	assert.ErrorIs(t, err, errInvalidCompression)
+}